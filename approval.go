@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ApprovalStatus is where a count stands in the four-eyes workflow.
+type ApprovalStatus string
+
+const (
+	// StatusFinal counts did not need an approval.
+	StatusFinal ApprovalStatus = "final"
+	// StatusPending counts wait for a second person to approve or reject them.
+	StatusPending    ApprovalStatus = "pending"
+	StatusApproved   ApprovalStatus = "approved"
+	StatusRejected   ApprovalStatus = "rejected"
+	StatusSuperseded ApprovalStatus = "superseded"
+)
+
+// finalised reports whether counts in the status are settled. Finalised counts are never
+// changed again.
+func (status ApprovalStatus) finalised() bool {
+	return status == StatusFinal || status == StatusApproved
+}
+
+// ApprovalConfig sets which counts need a second person: those whose difference is classified
+// MinSeverity or worse.
+type ApprovalConfig struct {
+	MinSeverity Severity `json:"minSeverity"`
+}
+
+// StatusChange records who moved a count into Status and when.
+type StatusChange struct {
+	Status  ApprovalStatus `json:"status"`
+	By      string         `json:"by"`
+	At      time.Time      `json:"at"`
+	Comment string         `json:"comment,omitempty"`
+}
+
+// StatusEvent is the journal entry of a status change made after the count was saved.
+type StatusEvent struct {
+	CountID uint64 `json:"countId"`
+	StatusChange
+}
+
+// ApprovalRequest approves or rejects a count. User is the person signing; with authentication
+// it is taken from the credentials.
+type ApprovalRequest struct {
+	User    string `json:"user"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// RecountRequest replaces a pending or rejected count with a new count of the same register,
+// kind and target.
+type RecountRequest struct {
+	Cashier  string  `json:"cashier,omitempty"`
+	Currency string  `json:"currency,omitempty"`
+	Counts   []Count `json:"counts"`
+}
+
+// initialStatus returns the status a new count starts in: pending if its difference needs an
+// approval, final otherwise.
+func (c ApprovalConfig) initialStatus(classification Classification) ApprovalStatus {
+	if classification.Severity.AtLeast(c.MinSeverity) {
+		return StatusPending
+	}
+	return StatusFinal
+}
+
+// transition moves the record into change.Status if the workflow allows it and adds the change
+// to its history. Only pending counts can be approved or rejected, and only by someone other
+// than the cashier who submitted them, so counts without a cashier cannot be signed; pending
+// and rejected counts can be recounted.
+func (record *CountRecord) transition(change StatusChange) error {
+	switch change.Status {
+	case StatusApproved, StatusRejected:
+		if record.Status != StatusPending {
+			return &ValidationError{Field: "status", Reason: fmt.Sprintf("count %d is %s, not pending", record.ID, record.Status)}
+		}
+		if change.By == "" {
+			return &ValidationError{Field: "user", Reason: "value is empty"}
+		}
+		if record.Cashier == "" {
+			return &ValidationError{Field: "cashier", Reason: fmt.Sprintf("count %d has no cashier, so the four-eyes check cannot be made", record.ID)}
+		}
+		if change.By == record.Cashier {
+			return &ValidationError{Field: "user", Reason: "must not be the user who submitted the count"}
+		}
+	case StatusSuperseded:
+		if record.Status != StatusPending && record.Status != StatusRejected {
+			return &ValidationError{Field: "status", Reason: fmt.Sprintf("count %d is %s and cannot be recounted", record.ID, record.Status)}
+		}
+	default:
+		return fmt.Errorf("count %d cannot change to %s", record.ID, change.Status)
+	}
+	record.Status = change.Status
+	record.History = append(record.History, change)
+	return nil
+}
+
+// supersedeCount marks the count a recount replaces as superseded, in the transaction that
+// inserts the recount.
+func supersedeCount(tx *bolt.Tx, recount *CountRecord) error {
+	bucket := tx.Bucket(countsBucket)
+	var record CountRecord
+	if err := getJSON(bucket, recount.RecountOf, "count", &record); err != nil {
+		return err
+	}
+	err := record.transition(StatusChange{
+		Status:  StatusSuperseded,
+		By:      recount.Cashier,
+		At:      recount.CreatedAt,
+		Comment: fmt.Sprintf("recounted as count %d", recount.ID),
+	})
+	if err != nil {
+		return err
+	}
+	return putJSON(bucket, itob(record.ID), record)
+}
+
+// changeStatus moves the stored count into a new status and appends the change to the journal
+// in the same transaction, so a change that cannot be journalled is not stored. Callers bound
+// to a register can only change the counts of that register.
+func (s *Server) changeStatus(ctx context.Context, id uint64, change StatusChange) (CountRecord, error) {
+	journal := func(*bolt.Tx) error {
+		_, err := s.journal.Append(StatusEvent{CountID: id, StatusChange: change})
+		return err
+	}
+	return s.store.UpdateCount(id, func(record *CountRecord) error {
+		if err := checkRegister(ctx, record.RegisterID); err != nil {
+			return err
+		}
+		return record.transition(change)
+	}, journal)
+}
+
+// handleApproveCount counter-signs the pending count in the path.
+func (s *Server) handleApproveCount(w http.ResponseWriter, r *http.Request) {
+	s.handleSignCount(w, r, StatusApproved)
+}
+
+// handleRejectCount rejects the pending count in the path, so it has to be recounted.
+func (s *Server) handleRejectCount(w http.ResponseWriter, r *http.Request) {
+	s.handleSignCount(w, r, StatusRejected)
+}
+
+// handleSignCount moves the count in the path into status on behalf of the user in the body
+// and responds with the updated count.
+func (s *Server) handleSignCount(w http.ResponseWriter, r *http.Request, status ApprovalStatus) {
+	id, err := parseID(r, "id")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	var request ApprovalRequest
+	if err := decodeStrict(r, &request); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if identity, ok := identityFrom(r.Context()); ok {
+		if request.User, err = identityValue("user", request.User, identity.User()); err != nil {
+			respondWithError(w, err)
+			return
+		}
+	}
+
+	record, err := s.changeStatus(r.Context(), id, StatusChange{
+		Status:  status,
+		By:      request.User,
+		At:      time.Now().UTC(),
+		Comment: request.Comment,
+	})
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, record)
+}
+
+// handleRecount saves a new count in place of the pending or rejected count in the path. The
+// recount keeps register, kind, target and session of the count it replaces and goes through
+// the approval workflow again.
+func (s *Server) handleRecount(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r, "id")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	var request RecountRequest
+	if err := decodeStrict(r, &request); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	previous, err := s.store.Count(id)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if previous.Status != StatusPending && previous.Status != StatusRejected {
+		respondWithError(w, &ValidationError{Field: "status", Reason: fmt.Sprintf("count %d is %s and cannot be recounted", id, previous.Status)})
+		return
+	}
+	if request.Currency == "" {
+		request.Currency = previous.Currency
+	}
+
+	responsePayload, err := calculateCounts(&s.config.Catalog, CountRequest{
+		Currency:    request.Currency,
+		TargetValue: previous.TargetValue.String(),
+		Counts:      request.Counts,
+	})
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	currency, _ := s.config.Catalog.Currency(request.Currency)
+	record := NewCountRecord(currency, request.Counts, previous.TargetValue)
+	record.RegisterID, record.Cashier, record.Kind = previous.RegisterID, request.Cashier, previous.Kind
+	record.SessionID, record.RecountOf = previous.SessionID, previous.ID
+	if err := s.saveCount(r.Context(), &record); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	responsePayload.CountID = record.ID
+	responsePayload.Attempt = record.Attempt
+	responsePayload.Classification = record.Classification
+	responsePayload.Status = record.Status
+	respondWithJSON(w, responsePayload)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// criticalCount is a v2 request by anna that is 50,00 short.
+const criticalCount = `{"registerId":"R1","cashier":"anna","countKind":"closing","targetValue":"100,00",
+	"counts":[{"denomination":"euro50","form":"loose","quantity":1}]}`
+
+func TestApproveCount(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.routes()
+
+	rec := serveJSON(handler, http.MethodPost, "/api/v2/calculate", `{"cashier":"anna","targetValue":"0","counts":[]}`)
+	assert.JSONEq(t, `"final"`, jsonField(t, rec.Body.Bytes(), "status"))
+	rec = serveJSON(handler, http.MethodPost, "/api/v2/calculate", criticalCount)
+	assert.JSONEq(t, `"pending"`, jsonField(t, rec.Body.Bytes(), "status"))
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/counts?status=pending", "")
+	var records []CountRecord
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&records))
+	assert.Equal(t, []uint64{2}, recordIDs(records))
+	serveJSON(handler, http.MethodPost, "/api/v2/calculate", strings.Replace(criticalCount, `"anna"`, `""`, 1))
+
+	tests := []struct {
+		target string
+		body   string
+		field  string
+	}{
+		{"/api/v1/counts/1/approve", `{"user":"max"}`, "status"},
+		{"/api/v1/counts/2/approve", `{"user":"anna"}`, "user"},
+		{"/api/v1/counts/2/reject", `{"user":""}`, "user"},
+		{"/api/v1/counts/3/approve", `{"user":"max"}`, "cashier"},
+	}
+	for _, tt := range tests {
+		rec = serveJSON(handler, http.MethodPost, tt.target, tt.body)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, tt.target)
+		assert.JSONEq(t, `"`+tt.field+`"`, jsonField(t, []byte(jsonField(t, rec.Body.Bytes(), "error")), "field"))
+	}
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/counts/2/approve", `{"user":"max","comment":"coins found in the safe"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var record CountRecord
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&record))
+	assert.Equal(t, StatusApproved, record.Status)
+	assert.Len(t, record.History, 2)
+	assert.Equal(t, StatusPending, record.History[0].Status)
+	assert.Equal(t, "anna", record.History[0].By)
+	assert.Equal(t, "max", record.History[1].By)
+	assert.Equal(t, "coins found in the safe", record.History[1].Comment)
+	assert.False(t, record.History[1].At.IsZero())
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/counts/2/reject", `{"user":"max"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/counts/9/approve", `{"user":"max"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// three counts and the approval
+	assert.Equal(t, uint64(4), server.journal.sequence)
+}
+
+func TestApproveRollsBackWithoutJournal(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.routes()
+	serveJSON(handler, http.MethodPost, "/api/v2/calculate", criticalCount)
+	assert.NoError(t, server.journal.Close())
+
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/counts/1/approve", `{"user":"max"}`)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	record, err := server.store.Count(1)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusPending, record.Status)
+}
+
+func TestRejectAndRecount(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.routes()
+	serveJSON(handler, http.MethodPost, "/api/v2/calculate", criticalCount)
+
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/counts/1/reject", `{"user":"max"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/counts/1/recount",
+		`{"cashier":"anna","counts":[{"denomination":"euro50","form":"loose","quantity":2}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var response ResponsePayload
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Equal(t, uint64(2), response.CountID)
+	assert.Equal(t, StatusFinal, response.Status)
+	assert.Equal(t, "0,00", response.ResponseValues.DifferenceValue)
+
+	recount, err := server.store.Count(2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), recount.RecountOf)
+	assert.Equal(t, "R1", recount.RegisterID)
+	assert.Equal(t, CountClosing, recount.Kind)
+
+	previous, err := server.store.Count(1)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusSuperseded, previous.Status)
+	assert.Equal(t, []ApprovalStatus{StatusPending, StatusRejected, StatusSuperseded}, statuses(previous.History))
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/counts/1/recount", `{"counts":[]}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/counts/2/recount", `{"counts":[]}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
+
+func TestRecountOfSessionCountIsNextAttempt(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.routes()
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","blind":true,"expectedValue":"100,00"}`)
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/counts", `{"cashier":"anna","counts":[]}`)
+	assert.JSONEq(t, `"pending"`, jsonField(t, rec.Body.Bytes(), "status"))
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/counts/1/recount",
+		`{"cashier":"anna","counts":[{"denomination":"euro100","form":"loose","quantity":1}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `2`, jsonField(t, rec.Body.Bytes(), "attempt"))
+
+	session, err := server.store.Session(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2}, session.Attempts)
+}
+
+func TestLoadConfigApproval(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, `{"approval": {"minSeverity": "warning"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, StatusPending, config.Approval.initialStatus(Classification{Severity: SeverityWarning}))
+	assert.Equal(t, StatusFinal, config.Approval.initialStatus(Classification{Severity: SeverityOK}))
+
+	_, err = LoadConfig(writeConfig(t, `{"approval": {"minSeverity": "high"}}`))
+	assert.Error(t, err)
+}
+
+// statuses returns the statuses of the changes in history.
+func statuses(history []StatusChange) []ApprovalStatus {
+	result := make([]ApprovalStatus, len(history))
+	for i, change := range history {
+		result[i] = change.Status
+	}
+	return result
+}
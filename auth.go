@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrUnauthorized = errors.New("unauthorized")
+
+// jwtLeeway is the clock difference tolerated when checking the times in a token.
+const jwtLeeway = time.Minute
+
+// AuthConfig lists the credentials the API accepts. Without API keys and JWT keys every request
+// is denied, unless Disabled opens the API to everyone.
+type AuthConfig struct {
+	Disabled bool      `json:"disabled,omitempty"`
+	APIKeys  []APIKey  `json:"apiKeys"`
+	JWT      JWTConfig `json:"jwt"`
+}
+
+// APIKey is a static key for a kiosk, sent in the X-API-Key header. Cashier and RegisterID are
+// attached to every count submitted with the key. Without a cashier the key signs as Name;
+// without a register the register is taken from the request.
+type APIKey struct {
+	Name       string `json:"name"`
+	Key        string `json:"key"`
+	Cashier    string `json:"cashier,omitempty"`
+	RegisterID string `json:"registerId,omitempty"`
+	Roles      []Role `json:"roles"`
+}
+
+// JWTConfig sets how bearer tokens are verified. Tokens are signed with HS256 using
+// HS256Secret or with RS256 using the public key in the PEM file PublicKeyFile. Issuer and
+// Audience are checked if they are set.
+type JWTConfig struct {
+	HS256Secret   string `json:"hs256Secret,omitempty"`
+	PublicKeyFile string `json:"publicKeyFile,omitempty"`
+	Issuer        string `json:"issuer,omitempty"`
+	Audience      string `json:"audience,omitempty"`
+
+	publicKey *rsa.PublicKey
+}
+
+// Identity is the authenticated caller. Cashier and RegisterID are empty if the credentials
+// are not bound to a cashier or register.
+type Identity struct {
+	Name       string `json:"name"`
+	Cashier    string `json:"cashier,omitempty"`
+	RegisterID string `json:"registerId,omitempty"`
+	Roles      []Role `json:"roles"`
+}
+
+// jwtClaims are the claims read from a token. The cashier is the subject.
+type jwtClaims struct {
+	Subject    string          `json:"sub"`
+	RegisterID string          `json:"registerId"`
+	Roles      []Role          `json:"roles"`
+	Issuer     string          `json:"iss"`
+	Audience   json.RawMessage `json:"aud"`
+	ExpiresAt  *int64          `json:"exp"`
+	NotBefore  *int64          `json:"nbf"`
+}
+
+type identityKey struct{}
+
+// User returns the name the caller signs with: the cashier, or the name of the credentials.
+func (identity Identity) User() string {
+	if identity.Cashier != "" {
+		return identity.Cashier
+	}
+	return identity.Name
+}
+
+// Enabled reports whether any credentials are configured.
+func (c *AuthConfig) Enabled() bool {
+	return len(c.APIKeys) > 0 || c.JWT.HS256Secret != "" || c.JWT.PublicKeyFile != ""
+}
+
+// Validate checks the API keys and loads the RS256 public key. Credentials cannot be configured
+// while authentication is disabled.
+func (c *AuthConfig) Validate() error {
+	if c.Disabled && c.Enabled() {
+		return fmt.Errorf("credentials are configured but authentication is disabled")
+	}
+	names, keys := make(map[string]bool), make(map[string]bool)
+	for i, key := range c.APIKeys {
+		switch {
+		case key.Name == "":
+			return fmt.Errorf("api key %d has no name", i)
+		case names[key.Name]:
+			return fmt.Errorf("api key %q is defined twice", key.Name)
+		case len(key.Key) < 16:
+			return fmt.Errorf("api key %q must be at least 16 characters long", key.Name)
+		case keys[key.Key]:
+			return fmt.Errorf("api key %q uses the key of another api key", key.Name)
+		}
+		for _, role := range key.Roles {
+			if err := role.Validate(); err != nil {
+				return fmt.Errorf("api key %q: %w", key.Name, err)
+			}
+		}
+		names[key.Name], keys[key.Key] = true, true
+	}
+	if c.JWT.HS256Secret != "" && len(c.JWT.HS256Secret) < 32 {
+		return fmt.Errorf("hs256Secret must be at least 32 characters long")
+	}
+	if c.JWT.PublicKeyFile != "" {
+		key, err := loadRSAPublicKey(c.JWT.PublicKeyFile)
+		if err != nil {
+			return err
+		}
+		c.JWT.publicKey = key
+	}
+	return nil
+}
+
+// loadRSAPublicKey reads an RSA public key from a PEM file in PKIX or PKCS #1 form.
+func loadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing public key %s: %w", path, err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an RSA key", path)
+	}
+	return rsaKey, nil
+}
+
+// authenticate rejects requests without valid credentials and adds the identity of the caller
+// to the request context. If no credentials are configured, requests pass without an identity
+// and authorize decides on them.
+func (s *Server) authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.config.Auth.Enabled() {
+			next(w, r)
+			return
+		}
+		identity, err := s.config.Auth.identify(r, time.Now())
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="register-api"`)
+			respondWithError(w, err)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, identity)))
+	}
+}
+
+// identify returns the identity of the API key or bearer token sent with r.
+func (c *AuthConfig) identify(r *http.Request, now time.Time) (Identity, error) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		for _, apiKey := range c.APIKeys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey.Key)) == 1 {
+				return Identity{Name: apiKey.Name, Cashier: apiKey.Cashier, RegisterID: apiKey.RegisterID, Roles: apiKey.Roles}, nil
+			}
+		}
+		return Identity{}, fmt.Errorf("%w: unknown api key", ErrUnauthorized)
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return Identity{}, fmt.Errorf("%w: missing api key or bearer token", ErrUnauthorized)
+	}
+	claims, err := c.JWT.verify(strings.TrimSpace(token), now)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+	return Identity{Name: claims.Subject, Cashier: claims.Subject, RegisterID: claims.RegisterID, Roles: claims.Roles}, nil
+}
+
+// verify checks the signature and the claims of a compact JWT and returns its claims. Only the
+// algorithms with a configured key are accepted, so a token cannot choose "none" or use the
+// public RSA key as HMAC secret.
+func (c *JWTConfig) verify(token string, now time.Time) (jwtClaims, error) {
+	var claims jwtClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("malformed token")
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return claims, fmt.Errorf("malformed token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, errors.New("malformed token signature")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	switch {
+	case header.Algorithm == "HS256" && c.HS256Secret != "":
+		mac := hmac.New(sha256.New, []byte(c.HS256Secret))
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return claims, errors.New("invalid token signature")
+		}
+	case header.Algorithm == "RS256" && c.publicKey != nil:
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(c.publicKey, crypto.SHA256, digest[:], signature); err != nil {
+			return claims, errors.New("invalid token signature")
+		}
+	default:
+		return claims, fmt.Errorf("token algorithm %q is not accepted", header.Algorithm)
+	}
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return claims, fmt.Errorf("malformed token claims: %w", err)
+	}
+	switch {
+	case claims.Subject == "":
+		return claims, errors.New("token has no subject")
+	case claims.ExpiresAt == nil:
+		return claims, errors.New("token has no expiry")
+	case now.After(time.Unix(*claims.ExpiresAt, 0).Add(jwtLeeway)):
+		return claims, errors.New("token expired")
+	case claims.NotBefore != nil && now.Before(time.Unix(*claims.NotBefore, 0).Add(-jwtLeeway)):
+		return claims, errors.New("token not valid yet")
+	case c.Issuer != "" && claims.Issuer != c.Issuer:
+		return claims, errors.New("token has the wrong issuer")
+	case c.Audience != "" && !claims.hasAudience(c.Audience):
+		return claims, errors.New("token has the wrong audience")
+	}
+	return claims, nil
+}
+
+// hasAudience reports whether the aud claim, a string or a list of strings, contains audience.
+func (claims jwtClaims) hasAudience(audience string) bool {
+	var single string
+	if json.Unmarshal(claims.Audience, &single) == nil {
+		return single == audience
+	}
+	var list []string
+	if json.Unmarshal(claims.Audience, &list) == nil {
+		return slices.Contains(list, audience)
+	}
+	return false
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a token into value.
+func decodeSegment(segment string, value any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// identityFrom returns the identity the authenticate middleware added to ctx.
+func identityFrom(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+// bindIdentity fills cashier and register of a record from the identity of the caller. The
+// cashier is always the user of the identity, so the four-eyes check compares the credentials
+// that submitted and approved a count. A value in the request that differs from the identity
+// is rejected instead of being overwritten.
+func bindIdentity(ctx context.Context, record *CountRecord) error {
+	identity, ok := identityFrom(ctx)
+	if !ok {
+		return nil
+	}
+	var err error
+	if record.Cashier, err = identityValue("cashier", record.Cashier, identity.User()); err != nil {
+		return err
+	}
+	record.RegisterID, err = identityValue("registerId", record.RegisterID, identity.RegisterID)
+	return err
+}
+
+// identityValue returns the value of field taken from the identity, or the requested value if
+// the identity has none.
+func identityValue(field, requested, fromIdentity string) (string, error) {
+	switch {
+	case fromIdentity == "":
+		return requested, nil
+	case requested != "" && requested != fromIdentity:
+		return "", &ValidationError{Field: field, Reason: fmt.Sprintf("the credentials are bound to %q", fromIdentity)}
+	}
+	return fromIdentity, nil
+}
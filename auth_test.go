@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+// signToken returns a compact JWT with the claims, signed with HS256 and testSecret or, if key
+// is set, with RS256.
+func signToken(t *testing.T, claims map[string]any, key *rsa.PrivateKey) string {
+	t.Helper()
+	algorithm := "HS256"
+	if key != nil {
+		algorithm = "RS256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": algorithm, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	assert.NoError(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	if key == nil {
+		mac := hmac.New(sha256.New, []byte(testSecret))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	} else {
+		digest := sha256.Sum256([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		assert.NoError(t, err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// newAuthServer returns a test server accepting the API key of kiosk-1, bound to register R1,
+// and HS256 tokens.
+func newAuthServer(t *testing.T) *Server {
+	t.Helper()
+	server := newTestServer(t)
+	server.config.Auth = AuthConfig{
+		APIKeys: []APIKey{{Name: "kiosk-1", Key: "kiosk-1-secret-key", RegisterID: "R1", Roles: []Role{RoleCashier}}},
+		JWT:     JWTConfig{HS256Secret: testSecret, Issuer: "register-auth", Audience: "register-api"},
+	}
+	assert.NoError(t, server.config.Auth.Validate())
+	return server
+}
+
+// serveAuth sends a request with the given header to handler.
+func serveAuth(handler http.Handler, method, target, body, header, value string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"sub":        "anna",
+		"registerId": "R2",
+		"roles":      []Role{RoleCashier},
+		"iss":        "register-auth",
+		"aud":        []string{"register-api"},
+		"exp":        time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	server := newAuthServer(t)
+	handler := server.routes()
+	body := `{"cashier":"anna","targetValue":"0","counts":[]}`
+
+	rec := serveAuth(handler, http.MethodPost, "/api/v2/calculate", body, "", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("WWW-Authenticate"))
+
+	rec = serveAuth(handler, http.MethodPost, "/api/v2/calculate", body, "X-API-Key", "wrong-key-of-16-chars")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = serveAuth(handler, http.MethodPost, "/api/v2/calculate", `{"targetValue":"0","counts":[]}`, "X-API-Key", "kiosk-1-secret-key")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	record, err := server.store.Count(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "R1", record.RegisterID)
+	assert.Equal(t, "kiosk-1", record.Cashier)
+
+	// the key signs as kiosk-1, not as the cashier in the request
+	rec = serveAuth(handler, http.MethodPost, "/api/v2/calculate", body, "X-API-Key", "kiosk-1-secret-key")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"field":"cashier"`)
+
+	rec = serveAuth(handler, http.MethodPost, "/api/v2/calculate", `{"registerId":"R2","targetValue":"0","counts":[]}`,
+		"X-API-Key", "kiosk-1-secret-key")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"field":"registerId"`)
+
+	// preflight requests carry no credentials
+	rec = serveAuth(handler, http.MethodOptions, "/api/v2/calculate", "", "", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Access-Control-Allow-Headers"), "Authorization")
+}
+
+func TestJWTAuthentication(t *testing.T) {
+	server := newAuthServer(t)
+	handler := server.routes()
+	body := `{"targetValue":"0","counts":[]}`
+
+	rec := serveAuth(handler, http.MethodPost, "/api/v2/calculate", body, "Authorization", "Bearer "+signToken(t, validClaims(), nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	record, err := server.store.Count(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "anna", record.Cashier)
+	assert.Equal(t, "R2", record.RegisterID)
+
+	rec = serveAuth(handler, http.MethodPost, "/api/v2/calculate", `{"cashier":"max","targetValue":"0","counts":[]}`,
+		"Authorization", "Bearer "+signToken(t, validClaims(), nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	invalid := map[string]func(map[string]any){
+		"expired":        func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"no expiry":      func(c map[string]any) { delete(c, "exp") },
+		"not yet valid":  func(c map[string]any) { c["nbf"] = time.Now().Add(time.Hour).Unix() },
+		"wrong issuer":   func(c map[string]any) { c["iss"] = "someone" },
+		"wrong audience": func(c map[string]any) { c["aud"] = "other-api" },
+		"no subject":     func(c map[string]any) { delete(c, "sub") },
+	}
+	for name, change := range invalid {
+		claims := validClaims()
+		change(claims)
+		rec = serveAuth(handler, http.MethodPost, "/api/v2/calculate", body, "Authorization", "Bearer "+signToken(t, claims, nil))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, name)
+	}
+
+	token := signToken(t, validClaims(), nil)
+	parts := strings.Split(token, ".")
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	for name, tampered := range map[string]string{
+		"signature": parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString([]byte("forged")),
+		"claims":    parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"max","exp":9999999999}`)) + "." + parts[2],
+		"none":      none + "." + parts[1] + ".",
+		"malformed": "abc",
+	} {
+		rec = serveAuth(handler, http.MethodPost, "/api/v2/calculate", body, "Authorization", "Bearer "+tampered)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, name)
+	}
+}
+
+func TestRS256Authentication(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "public.pem")
+	assert.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	server := newTestServer(t)
+	server.config.Auth = AuthConfig{JWT: JWTConfig{PublicKeyFile: path}}
+	assert.NoError(t, server.config.Auth.Validate())
+	handler := server.routes()
+	body := `{"targetValue":"0","counts":[]}`
+
+	rec := serveAuth(handler, http.MethodPost, "/api/v2/calculate", body, "Authorization", "Bearer "+signToken(t, validClaims(), key))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// HS256 is not configured, so the public key cannot be used as HMAC secret
+	rec = serveAuth(handler, http.MethodPost, "/api/v2/calculate", body, "Authorization", "Bearer "+signToken(t, validClaims(), nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestApprovalUserFromToken(t *testing.T) {
+	server := newAuthServer(t)
+	handler := server.routes()
+	anna := "Bearer " + signToken(t, validClaims(), nil)
+	claims := validClaims()
+	claims["sub"], claims["roles"] = "max", []Role{RoleShiftLead}
+	manager := "Bearer " + signToken(t, claims, nil)
+
+	rec := serveAuth(handler, http.MethodPost, "/api/v2/calculate", `{"targetValue":"100,00","counts":[]}`, "Authorization", anna)
+	assert.JSONEq(t, `"pending"`, jsonField(t, rec.Body.Bytes(), "status"))
+
+	claims = validClaims()
+	claims["roles"] = []Role{RoleCashier, RoleShiftLead}
+	lead := "Bearer " + signToken(t, claims, nil)
+	rec = serveAuth(handler, http.MethodPost, "/api/v1/counts/1/approve", `{}`, "Authorization", lead)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec = serveAuth(handler, http.MethodPost, "/api/v1/counts/1/approve", `{"user":"max"}`, "Authorization", lead)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec = serveAuth(handler, http.MethodPost, "/api/v1/counts/1/approve", `{}`, "Authorization", manager)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestApprovalBySubmittingKey(t *testing.T) {
+	server := newRoleServer(t)
+	handler := server.routes()
+
+	rec := serveAuth(handler, http.MethodPost, "/api/v2/calculate", `{"targetValue":"100,00","counts":[]}`,
+		"X-API-Key", roleKeys["lead"])
+	assert.JSONEq(t, `"pending"`, jsonField(t, rec.Body.Bytes(), "status"))
+
+	rec = serveAuth(handler, http.MethodPost, "/api/v1/counts/1/approve", `{}`, "X-API-Key", roleKeys["lead"])
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"field":"user"`)
+	rec = serveAuth(handler, http.MethodPost, "/api/v1/counts/1/approve", `{"user":"max"}`, "X-API-Key", roleKeys["lead"])
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	record, err := server.store.Count(1)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusPending, record.Status)
+}
+
+func TestLoadConfigAuth(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, `{"auth": {"apiKeys": [{"name": "kiosk-1", "key": "kiosk-1-secret-key", "roles": ["cashier"]}]}}`))
+	assert.NoError(t, err)
+	assert.True(t, config.Auth.Enabled())
+
+	config, err = LoadConfig("")
+	assert.NoError(t, err)
+	assert.False(t, config.Auth.Enabled())
+
+	for _, content := range []string{
+		`{"auth": {"apiKeys": [{"key": "kiosk-1-secret-key"}]}}`,
+		`{"auth": {"apiKeys": [{"name": "kiosk-1", "key": "short"}]}}`,
+		`{"auth": {"apiKeys": [{"name": "a", "key": "kiosk-1-secret-key"}, {"name": "b", "key": "kiosk-1-secret-key"}]}}`,
+		`{"auth": {"jwt": {"hs256Secret": "too short"}}}`,
+		`{"auth": {"apiKeys": [{"name": "kiosk-1", "key": "kiosk-1-secret-key", "roles": ["boss"]}]}}`,
+		`{"auth": {"jwt": {"publicKeyFile": "missing.pem"}}}`,
+	} {
+		_, err := LoadConfig(writeConfig(t, content))
+		assert.Error(t, err, content)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+type ColumnValues struct {
+	Column string `json:"column"`
+	Value  string `json:"value"`
+}
+
+type DenominationValues struct {
+	Denomination string `json:"denomination"`
+	LooseValue   string `json:"looseValue"`
+	RollValue    string `json:"rollValue"`
+	BoxValue     string `json:"boxValue"`
+	TotalValue   string `json:"totalValue"`
+}
+
+type BreakdownValues struct {
+	LooseValue    string               `json:"looseValue"`
+	RollValue     string               `json:"rollValue"`
+	BoxValue      string               `json:"boxValue"`
+	Columns       []ColumnValues       `json:"columns"`
+	Denominations []DenominationValues `json:"denominations"`
+}
+
+// CalculateDenominationValues returns the loose, roll, box and total value of every denomination
+// of the currency, in catalog order. The values of all denominations add up to the subtotals of
+// SumCounts. Counts must have been validated against the currency.
+func CalculateDenominationValues(currency *Currency, counts []Count) []DenominationValues {
+	type formValues struct{ loose, rolls, boxes Money }
+	values := make(map[string]*formValues)
+	for _, d := range currency.Denominations {
+		values[d.Code] = &formValues{}
+	}
+	for _, c := range counts {
+		d, _ := currency.Denomination(c.Denomination)
+		value := CountValue(d, c.Form, c.Quantity)
+		switch c.Form {
+		case FormRoll:
+			values[d.Code].rolls += value
+		case FormBox:
+			values[d.Code].boxes += value
+		default:
+			values[d.Code].loose += value
+		}
+	}
+
+	result := make([]DenominationValues, 0, len(currency.Denominations))
+	for _, d := range currency.Denominations {
+		v := values[d.Code]
+		result = append(result, DenominationValues{
+			Denomination: d.Code,
+			LooseValue:   FormatNumber(v.loose),
+			RollValue:    FormatNumber(v.rolls),
+			BoxValue:     FormatNumber(v.boxes),
+			TotalValue:   FormatNumber(v.loose + v.rolls + v.boxes),
+		})
+	}
+	return result
+}
+
+// CalculateColumnValues returns the value of each column named in the counts, in the order the
+// columns first appear. The columns given in columns are always listed first, even if empty.
+// Counts without column are left out.
+func CalculateColumnValues(currency *Currency, counts []Count, columns []string) []ColumnValues {
+	sums := make(map[string]Money)
+	order := append([]string(nil), columns...)
+	for _, column := range columns {
+		sums[column] = 0
+	}
+	for _, c := range counts {
+		if c.Column == "" {
+			continue
+		}
+		if _, ok := sums[c.Column]; !ok {
+			order = append(order, c.Column)
+		}
+		d, _ := currency.Denomination(c.Denomination)
+		sums[c.Column] += CountValue(d, c.Form, c.Quantity)
+	}
+
+	result := make([]ColumnValues, 0, len(order))
+	for _, column := range order {
+		result = append(result, ColumnValues{Column: column, Value: FormatNumber(sums[column])})
+	}
+	return result
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// zeroColumns returns the column breakdown of an empty count.
+func zeroColumns() []ColumnValues {
+	return []ColumnValues{
+		{Column: "1", Value: "0,00"},
+		{Column: "2", Value: "0,00"},
+		{Column: "3", Value: "0,00"},
+		{Column: "4", Value: "0,00"},
+		{Column: "5", Value: "0,00"},
+	}
+}
+
+// zeroDenominations returns the denomination breakdown of an empty count.
+func zeroDenominations() []DenominationValues {
+	var result []DenominationValues
+	for _, key := range []string{"euro200", "euro100", "euro50", "euro20", "euro10", "euro5", "euro2", "euro1",
+		"cent50", "cent20", "cent10", "cent5", "cent2", "cent1"} {
+		result = append(result, DenominationValues{
+			Denomination: key,
+			LooseValue:   "0,00",
+			RollValue:    "0,00",
+			BoxValue:     "0,00",
+			TotalValue:   "0,00",
+		})
+	}
+	return result
+}
+
+func TestCalculateColumnValues(t *testing.T) {
+	request := RequestPayload{
+		RequestValues: RequestValues{
+			"euro50": {1, 0, 0, 0, 2},
+			"euro1":  {0, 3, 0, 0, 0},
+			"cent1":  {0, 0, 0, 7, 0},
+		},
+		RollValues: RollValues{"euro2": {1, 0}},
+	}
+
+	want := zeroColumns()
+	want[0].Value = "50,00"
+	want[1].Value = "3,00"
+	want[3].Value = "0,07"
+	want[4].Value = "100,00"
+
+	assert.Equal(t, want, CalculateColumnValues(euro(), request.Counts(), v1Columns))
+}
+
+func TestCalculateDenominationValues(t *testing.T) {
+	request := RequestPayload{
+		RequestValues: RequestValues{"cent2": {1, 2, 0, 0, 0}},
+		RollValues:    RollValues{"cent2": {1, 1}},
+		BoxValues:     BoxValues{"cent2": {1}},
+	}
+
+	want := zeroDenominations()
+	want[12] = DenominationValues{
+		Denomination: "cent2",
+		LooseValue:   "0,06",
+		RollValue:    "2,00",
+		BoxValue:     "5,00",
+		TotalValue:   "7,06",
+	}
+
+	assert.Equal(t, want, CalculateDenominationValues(euro(), request.Counts()))
+}
+
+func TestDenominationValuesMatchSubtotals(t *testing.T) {
+	request := RequestPayload{
+		RequestValidation: RequestValidation{TargetValue: "0"},
+		RequestValues:     RequestValues{"euro20": {1, 2, 3, 4, 5}, "cent10": {9, 0, 0, 0, 1}},
+		RollValues:        RollValues{"euro2": {3, 2}, "cent5": {10, 9}, "cent1": {6, 5}},
+		BoxValues: BoxValues{"euro2": {1}, "euro1": {1}, "cent50": {1}, "cent20": {1},
+			"cent10": {1}, "cent5": {1}, "cent2": {1}, "cent1": {1}},
+	}
+
+	response, err := calculateTotalValue(&testCatalog, request)
+	assert.NoError(t, err)
+
+	var loose, rolls, boxes Money
+	for _, d := range response.ResponseValues.Breakdown.Denominations {
+		loose += parseFormatted(t, d.LooseValue)
+		rolls += parseFormatted(t, d.RollValue)
+		boxes += parseFormatted(t, d.BoxValue)
+	}
+	breakdown := response.ResponseValues.Breakdown
+	assert.Equal(t, breakdown.LooseValue, FormatNumber(loose))
+	assert.Equal(t, breakdown.RollValue, FormatNumber(rolls))
+	assert.Equal(t, breakdown.BoxValue, FormatNumber(boxes))
+	assert.Equal(t, response.ResponseValues.TotalValue, FormatNumber(loose+rolls+boxes))
+}
+
+// parseFormatted converts a string produced by FormatNumber back to Money.
+func parseFormatted(t *testing.T, s string) Money {
+	t.Helper()
+	value, err := ParseMoney(s)
+	if err != nil {
+		t.Fatalf("ParseMoney(%q) error = %v", s, err)
+	}
+	return value
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrImmutable is returned for a change to a finalised record. Finalised records are only
+// cancelled by reversal entries in the cash book.
+var ErrImmutable = errors.New("finalised records cannot be changed")
+
+// CashBookKind is what a cash book entry books.
+type CashBookKind string
+
+const (
+	// BookCount books a finalised closing count. Amount is the counted cash, DifferenceValue
+	// the shortage or overage.
+	BookCount CashBookKind = "count"
+	// BookSale books a sale of a session; refunds and payouts are negative sales.
+	BookSale CashBookKind = "sale"
+	// BookSkim books cash taken out of a session, by a skim or a drop, as a negative amount.
+	BookSkim CashBookKind = "skim"
+	// BookRefill books cash put into the drawer of a session from the safe.
+	BookRefill CashBookKind = "refill"
+	// BookDeposit books a deposit slip as a negative amount.
+	BookDeposit CashBookKind = "deposit"
+	// BookReversal cancels the entry it reverses with the negated amounts.
+	BookReversal CashBookKind = "reversal"
+)
+
+// CashBookEntry is one line of the cash book. Entries are numbered without gaps in the order
+// they are booked, in the transaction that stores the record they book, and are never changed
+// or removed: a wrong entry is cancelled by a reversal entry and replaced by a correction
+// entry, both linked to it. Hash is the SHA-256 of the entry with an empty hash, so like the
+// journal, changing, removing or reordering an entry breaks the chain from that entry on.
+type CashBookEntry struct {
+	Number          uint64       `json:"number"`
+	BookedAt        time.Time    `json:"bookedAt"`
+	Kind            CashBookKind `json:"kind"`
+	RegisterID      string       `json:"registerId"`
+	StoreID         string       `json:"storeId,omitempty"`
+	Currency        string       `json:"currency"`
+	Amount          Money        `json:"amount"`
+	DifferenceValue Money        `json:"differenceValue,omitempty"`
+	Text            string       `json:"text"`
+	By              string       `json:"by,omitempty"`
+	CountID         uint64       `json:"countId,omitempty"`
+	SessionID       uint64       `json:"sessionId,omitempty"`
+	SlipNumber      uint64       `json:"slipNumber,omitempty"`
+	Reverses        uint64       `json:"reverses,omitempty"`
+	Corrects        uint64       `json:"corrects,omitempty"`
+	PreviousHash    string       `json:"previousHash"`
+	Hash            string       `json:"hash"`
+}
+
+// CashBookFilter selects cash book entries. Empty fields do not filter; From is inclusive, To
+// exclusive.
+type CashBookFilter struct {
+	RegisterID string
+	From       time.Time
+	To         time.Time
+}
+
+// ReversalRequest reverses a cash book entry. Reason is required. With an amount, a correction
+// entry booking the amount in place of the reversed one is added; it accepts the same formats
+// as a target value.
+type ReversalRequest struct {
+	User   string `json:"user,omitempty"`
+	Reason string `json:"reason"`
+	Amount string `json:"amount,omitempty"`
+}
+
+// CashBookProof is the result of checking the cash book for a period. The chain is checked
+// from the first entry up to the end of the period; Missing lists the numbers that were handed
+// out but are not in the book, Broken the entries whose hash or link does not match. First and
+// Last are the first and last number booked in the period.
+type CashBookProof struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	First    uint64    `json:"first,omitempty"`
+	Last     uint64    `json:"last,omitempty"`
+	Entries  int       `json:"entries"`
+	Gapless  bool      `json:"gapless"`
+	Intact   bool      `json:"intact"`
+	Missing  []uint64  `json:"missing"`
+	Broken   []uint64  `json:"broken"`
+	LastHash string    `json:"lastHash"`
+}
+
+// computeHash returns the hash the entry must carry.
+func (entry CashBookEntry) computeHash() string {
+	entry.Hash = ""
+	data, _ := json.Marshal(entry)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// countEntry books a finalised closing count.
+func countEntry(record *CountRecord) CashBookEntry {
+	return CashBookEntry{
+		Kind:            BookCount,
+		RegisterID:      record.RegisterID,
+		StoreID:         record.StoreID,
+		Currency:        record.Currency,
+		Amount:          record.TotalValue,
+		DifferenceValue: record.DifferenceValue,
+		Text:            fmt.Sprintf("closing count %d", record.ID),
+		By:              record.Cashier,
+		CountID:         record.ID,
+		SessionID:       record.SessionID,
+	}
+}
+
+// cashBookEntries books the session entries from index on.
+func (session *Session) cashBookEntries(from int) []CashBookEntry {
+	var entries []CashBookEntry
+	for _, entry := range session.Entries[from:] {
+		booked := CashBookEntry{
+			Kind:       BookSale,
+			RegisterID: session.RegisterID,
+			StoreID:    session.StoreID,
+			Currency:   session.Currency,
+			Amount:     entry.Amount,
+			Text:       entry.Reference,
+			By:         entry.By,
+			CountID:    entry.CountID,
+			SessionID:  session.ID,
+		}
+		switch entry.Type {
+		case EntrySkim:
+			booked.Kind, booked.Amount = BookSkim, -entry.Amount
+		case EntryRefill:
+			booked.Kind = BookRefill
+		}
+		if booked.Text == "" {
+			booked.Text = fmt.Sprintf("%s of session %d", entry.Type, session.ID)
+		}
+		entries = append(entries, booked)
+	}
+	return entries
+}
+
+// slipEntry books a deposit slip.
+func slipEntry(slip *DepositSlip) CashBookEntry {
+	return CashBookEntry{
+		Kind:       BookDeposit,
+		RegisterID: slip.RegisterID,
+		Currency:   slip.Currency,
+		Amount:     -slip.TotalValue,
+		Text:       fmt.Sprintf("deposit slip %06d", slip.Number),
+		CountID:    slip.CountID,
+		SlipNumber: slip.Number,
+	}
+}
+
+// appendCashBook books entries under the next numbers and sets their numbers, booking time and
+// hashes. It is the only way entries get into the cash book.
+func appendCashBook(tx *bolt.Tx, entries ...CashBookEntry) error {
+	bucket := tx.Bucket(cashBookBucket)
+	previous := genesisHash
+	if key, data := bucket.Cursor().Last(); key != nil {
+		var last CashBookEntry
+		if err := json.Unmarshal(data, &last); err != nil {
+			return fmt.Errorf("cash book entry %d: %w", btoi(key), err)
+		}
+		previous = last.Hash
+	}
+	now := time.Now().UTC()
+	for i := range entries {
+		entry := &entries[i]
+		number, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry.Number, entry.BookedAt, entry.PreviousHash = number, now, previous
+		entry.Hash = entry.computeHash()
+		if err := putJSON(bucket, itob(number), entry); err != nil {
+			return err
+		}
+		previous = entry.Hash
+	}
+	return nil
+}
+
+// CashBookEntry returns the entry with the given number or ErrNotFound.
+func (s *Store) CashBookEntry(number uint64) (CashBookEntry, error) {
+	var entry CashBookEntry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(cashBookBucket), number, "cash book entry", &entry)
+	})
+	return entry, err
+}
+
+// ListCashBook returns the entries matching filter in the order they were booked.
+func (s *Store) ListCashBook(filter CashBookFilter) ([]CashBookEntry, error) {
+	entries := []CashBookEntry{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(cashBookBucket).ForEach(func(key, data []byte) error {
+			var entry CashBookEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return fmt.Errorf("cash book entry %d: %w", btoi(key), err)
+			}
+			if filter.RegisterID != "" && entry.RegisterID != filter.RegisterID ||
+				!filter.From.IsZero() && entry.BookedAt.Before(filter.From) ||
+				!filter.To.IsZero() && !entry.BookedAt.Before(filter.To) {
+				return nil
+			}
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// ReverseCashBookEntry books a reversal of the entry with the given number and, if correction
+// is not nil, a correction entry booking that amount in its place. Reversals cannot be
+// reversed, and an entry is reversed at most once. If then is not nil, it is called last in the
+// transaction with the booked entries; if it fails, nothing is booked.
+func (s *Store) ReverseCashBookEntry(number uint64, by, reason string, correction *Money, then func([]CashBookEntry) error) ([]CashBookEntry, error) {
+	var entries []CashBookEntry
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(cashBookBucket)
+		var original CashBookEntry
+		if err := getJSON(bucket, number, "cash book entry", &original); err != nil {
+			return err
+		}
+		if original.Kind == BookReversal {
+			return &ValidationError{Field: "number", Reason: fmt.Sprintf("entry %d is a reversal and cannot be reversed", number)}
+		}
+		err := bucket.ForEach(func(key, data []byte) error {
+			var entry CashBookEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return fmt.Errorf("cash book entry %d: %w", btoi(key), err)
+			}
+			if entry.Reverses == number {
+				return &ValidationError{Field: "number", Reason: fmt.Sprintf("entry %d is already reversed by entry %d", number, entry.Number)}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		reversal := original
+		reversal.Kind, reversal.Amount, reversal.DifferenceValue = BookReversal, -original.Amount, -original.DifferenceValue
+		reversal.Text = fmt.Sprintf("reversal of entry %d: %s", number, reason)
+		reversal.By, reversal.Reverses, reversal.Corrects = by, number, 0
+		entries = append(entries, reversal)
+		if correction != nil {
+			corrected := original
+			corrected.Amount = *correction
+			if original.Kind == BookCount {
+				corrected.DifferenceValue += *correction - original.Amount
+			}
+			corrected.Text = fmt.Sprintf("correction of entry %d: %s", number, reason)
+			corrected.By, corrected.Corrects = by, number
+			entries = append(entries, corrected)
+		}
+		if err := appendCashBook(tx, entries...); err != nil || then == nil {
+			return err
+		}
+		return then(entries)
+	})
+	return entries, err
+}
+
+// VerifyCashBook checks the numbers and the hash chain of the cash book from the first entry
+// up to to, and reports the entries booked from from on. A zero to checks the whole book.
+func (s *Store) VerifyCashBook(from, to time.Time) (CashBookProof, error) {
+	proof := CashBookProof{From: from, To: to, Missing: []uint64{}, Broken: []uint64{}}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(cashBookBucket)
+		// the numbers handed out before the period ends
+		end := bucket.Sequence()
+		expected, previous := uint64(1), genesisHash
+		cursor := bucket.Cursor()
+		for key, data := cursor.First(); key != nil; key, data = cursor.Next() {
+			number := btoi(key)
+			var entry CashBookEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return fmt.Errorf("cash book entry %d: %w", number, err)
+			}
+			if !to.IsZero() && !entry.BookedAt.Before(to) {
+				end = number - 1
+				break
+			}
+			for ; expected < number; expected++ {
+				proof.Missing = append(proof.Missing, expected)
+			}
+			expected = number + 1
+			if entry.Number != number || entry.PreviousHash != previous || entry.Hash != entry.computeHash() {
+				proof.Broken = append(proof.Broken, number)
+			}
+			previous = entry.Hash
+			if entry.BookedAt.Before(from) {
+				continue
+			}
+			if proof.First == 0 {
+				proof.First = number
+			}
+			proof.Last, proof.Entries = number, proof.Entries+1
+		}
+		for ; expected <= end; expected++ {
+			proof.Missing = append(proof.Missing, expected)
+		}
+		proof.LastHash = previous
+		return nil
+	})
+	proof.Gapless, proof.Intact = len(proof.Missing) == 0, len(proof.Broken) == 0
+	return proof, err
+}
+
+// handleListCashBook returns the cash book entries in the order they were booked. The query
+// parameters registerId, from and to narrow the list; from and to take a date or an RFC 3339
+// timestamp. Callers bound to a register only see the entries of that register.
+func (s *Server) handleListCashBook(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var filter CashBookFilter
+	var err error
+	if filter.RegisterID, err = boundRegister(r.Context(), query.Get("registerId")); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if filter.From, err = parseTimeParam(query.Get("from"), "from"); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if filter.To, err = parseTimeParam(query.Get("to"), "to"); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	entries, err := s.store.ListCashBook(filter)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, entries)
+}
+
+// handleReverseCashBookEntry reverses the cash book entry in the path and returns the reversal
+// and, if an amount is given, the correction entry. The entries are appended to the audit
+// journal in the transaction that books them.
+func (s *Server) handleReverseCashBookEntry(w http.ResponseWriter, r *http.Request) {
+	number, err := parseID(r, "number")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	var request ReversalRequest
+	if err := decodeStrict(r, &request); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if identity, ok := identityFrom(r.Context()); ok {
+		if request.User, err = identityValue("user", request.User, identity.User()); err != nil {
+			respondWithError(w, err)
+			return
+		}
+	}
+	if request.Reason == "" {
+		respondWithError(w, &ValidationError{Field: "reason", Reason: "value is empty"})
+		return
+	}
+	var correction *Money
+	if request.Amount != "" {
+		amount, err := ParseMoney(request.Amount)
+		if err != nil {
+			respondWithError(w, &ValidationError{Field: "amount", Reason: err.Error()})
+			return
+		}
+		correction = &amount
+	}
+
+	journal := func(entries []CashBookEntry) error {
+		for _, entry := range entries {
+			if _, err := s.journal.Append(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	entries, err := s.store.ReverseCashBookEntry(number, request.User, request.Reason, correction, journal)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, entries)
+}
+
+// handleVerifyCashBook proves that the cash book has no gaps up to the end of a period. from
+// and to take a date or an RFC 3339 timestamp and default to the current month.
+func (s *Server) handleVerifyCashBook(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	to := from.AddDate(0, 1, 0)
+	if value := query.Get("from"); value != "" {
+		var err error
+		if from, err = parseTimeParam(value, "from"); err != nil {
+			respondWithError(w, err)
+			return
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		var err error
+		if to, err = parseTimeParam(value, "to"); err != nil {
+			respondWithError(w, err)
+			return
+		}
+	}
+	if !to.After(from) {
+		respondWithError(w, &ValidationError{Field: "to", Reason: "must be after from"})
+		return
+	}
+	proof, err := s.store.VerifyCashBook(from, to)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, proof)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+// decodeCashBook decodes a list of cash book entries.
+func decodeCashBook(t *testing.T, rec *httptest.ResponseRecorder) []CashBookEntry {
+	t.Helper()
+	var entries []CashBookEntry
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&entries))
+	return entries
+}
+
+// decodeProof decodes a cash book proof.
+func decodeProof(t *testing.T, rec *httptest.ResponseRecorder) CashBookProof {
+	t.Helper()
+	var proof CashBookProof
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&proof))
+	return proof
+}
+
+func TestCashBookBooksRecords(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.routes()
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R5","expectedValue":"300,00"}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/sales", `{"amount":"30,00","reference":"bon 4711"}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/skims", `{"amount":"20,00"}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/registers/R5/drops", `{"counts":[{"denomination":"euro200","form":"loose","quantity":1}]}`)
+	// the pending count is booked once it is approved, the interim count not at all
+	serveJSON(handler, http.MethodPost, "/api/v2/calculate", criticalCount)
+	serveJSON(handler, http.MethodPost, "/api/v2/calculate", `{"registerId":"R2","cashier":"anna","countKind":"closing","targetValue":"49,00",
+		"counts":[{"denomination":"euro50","form":"loose","quantity":1}]}`)
+	serveJSON(handler, http.MethodPost, "/api/v2/calculate", `{"registerId":"R3","targetValue":"0","counts":[]}`)
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/counts/2/approve", `{"user":"max"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/deposit-slips", `{"countId":3,"branch":"Berlin"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/cash-book", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	entries := decodeCashBook(t, rec)
+	if !assert.Len(t, entries, 6) {
+		return
+	}
+	previous := genesisHash
+	for i, entry := range entries {
+		assert.Equal(t, uint64(i+1), entry.Number)
+		assert.Equal(t, previous, entry.PreviousHash)
+		assert.Equal(t, entry.computeHash(), entry.Hash)
+		previous = entry.Hash
+	}
+	assert.Equal(t, CashBookEntry{Kind: BookSale, RegisterID: "R5", Currency: "EUR", Amount: 3000, Text: "bon 4711", SessionID: 1},
+		CashBookEntry{Kind: entries[0].Kind, RegisterID: entries[0].RegisterID, Currency: entries[0].Currency,
+			Amount: entries[0].Amount, Text: entries[0].Text, SessionID: entries[0].SessionID})
+	assert.Equal(t, BookSkim, entries[1].Kind)
+	assert.Equal(t, Money(-2000), entries[1].Amount)
+	assert.Equal(t, "skim of session 1", entries[1].Text)
+	assert.Equal(t, Money(-20000), entries[2].Amount)
+	assert.Equal(t, uint64(1), entries[2].CountID)
+	assert.Equal(t, BookCount, entries[3].Kind)
+	assert.Equal(t, uint64(3), entries[3].CountID)
+	assert.Equal(t, Money(5000), entries[3].Amount)
+	assert.Equal(t, Money(100), entries[3].DifferenceValue)
+	assert.Equal(t, uint64(2), entries[4].CountID)
+	assert.Equal(t, Money(-5000), entries[4].DifferenceValue)
+	assert.Equal(t, BookDeposit, entries[5].Kind)
+	assert.Equal(t, Money(-5000), entries[5].Amount)
+	assert.Equal(t, uint64(1), entries[5].SlipNumber)
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/cash-book?registerId=R5", "")
+	assert.Len(t, decodeCashBook(t, rec), 3)
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/cash-book?from=2020-01-01&to=2020-02-01", "")
+	assert.Len(t, decodeCashBook(t, rec), 0)
+}
+
+func TestUpdateFinalisedCount(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.routes()
+	serveJSON(handler, http.MethodPost, "/api/v2/calculate", `{"registerId":"R1","countKind":"closing","targetValue":"0","counts":[]}`)
+
+	_, updateErr := server.store.UpdateCount(1, func(record *CountRecord) error {
+		record.TotalValue = 100
+		return nil
+	})
+	assert.ErrorIs(t, updateErr, ErrImmutable)
+	record, err := server.store.Count(1)
+	assert.NoError(t, err)
+	assert.Equal(t, Money(0), record.TotalValue)
+
+	rec := httptest.NewRecorder()
+	respondWithError(rec, updateErr)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+}
+
+func TestReverseCashBookEntry(t *testing.T) {
+	handler := newTestServer(t).routes()
+	serveJSON(handler, http.MethodPost, "/api/v2/calculate", `{"registerId":"R2","cashier":"anna","countKind":"closing","targetValue":"49,00",
+		"counts":[{"denomination":"euro50","form":"loose","quantity":1}]}`)
+
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/cash-book/1/reverse", `{"user":"max","reason":"miscounted","amount":"49,00"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	entries := decodeCashBook(t, rec)
+	if !assert.Len(t, entries, 2) {
+		return
+	}
+	assert.Equal(t, uint64(2), entries[0].Number)
+	assert.Equal(t, BookReversal, entries[0].Kind)
+	assert.Equal(t, uint64(1), entries[0].Reverses)
+	assert.Equal(t, Money(-5000), entries[0].Amount)
+	assert.Equal(t, Money(-100), entries[0].DifferenceValue)
+	assert.Equal(t, "reversal of entry 1: miscounted", entries[0].Text)
+	assert.Equal(t, "max", entries[0].By)
+	assert.Equal(t, uint64(3), entries[1].Number)
+	assert.Equal(t, BookCount, entries[1].Kind)
+	assert.Equal(t, uint64(1), entries[1].Corrects)
+	assert.Equal(t, Money(4900), entries[1].Amount)
+	assert.Equal(t, Money(0), entries[1].DifferenceValue)
+	assert.Equal(t, entries[0].Hash, entries[1].PreviousHash)
+
+	tests := []struct {
+		target string
+		body   string
+		field  string
+	}{
+		{"/api/v1/cash-book/1/reverse", `{"reason":"twice"}`, "number"},
+		{"/api/v1/cash-book/2/reverse", `{"reason":"undo"}`, "number"},
+		{"/api/v1/cash-book/3/reverse", `{"reason":""}`, "reason"},
+		{"/api/v1/cash-book/3/reverse", `{"reason":"typo","amount":"abc"}`, "amount"},
+		{"/api/v1/cash-book/x/reverse", `{"reason":"typo"}`, "number"},
+	}
+	for _, tt := range tests {
+		rec = serveJSON(handler, http.MethodPost, tt.target, tt.body)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, tt.body)
+		assert.JSONEq(t, `"`+tt.field+`"`, jsonField(t, []byte(jsonField(t, rec.Body.Bytes(), "error")), "field"), tt.body)
+	}
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/cash-book/9/reverse", `{"reason":"typo"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// the correction is an entry of its own and can be reversed in turn
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/cash-book/3/reverse", `{"reason":"wrong register"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	entries = decodeCashBook(t, rec)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, uint64(4), entries[0].Number)
+}
+
+func TestReverseRollsBackWithoutJournal(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.routes()
+	serveJSON(handler, http.MethodPost, "/api/v2/calculate", `{"registerId":"R2","cashier":"anna","countKind":"closing","targetValue":"49,00",
+		"counts":[{"denomination":"euro50","form":"loose","quantity":1}]}`)
+	assert.NoError(t, server.journal.Close())
+
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/cash-book/1/reverse", `{"user":"max","reason":"miscounted"}`)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	entries, err := server.store.ListCashBook(CashBookFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestCashBookOfOtherRegister(t *testing.T) {
+	handler := newRoleServer(t).routes()
+	for _, register := range []string{"R1", "R2"} {
+		rec := serveAuth(handler, http.MethodPost, "/api/v2/calculate", `{"registerId":"`+register+`","countKind":"closing",
+			"targetValue":"50,00","counts":[{"denomination":"euro50","form":"loose","quantity":1}]}`, "X-API-Key", roleKeys["manager"])
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	rec := serveAuth(handler, http.MethodGet, "/api/v1/cash-book", "", "X-API-Key", roleKeys["lead"])
+	assert.Equal(t, http.StatusOK, rec.Code)
+	entries := decodeCashBook(t, rec)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "R1", entries[0].RegisterID)
+	}
+	rec = serveAuth(handler, http.MethodGet, "/api/v1/cash-book?registerId=R2", "", "X-API-Key", roleKeys["lead"])
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestVerifyCashBook(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.routes()
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","expectedValue":"100,00"}`)
+	for _, amount := range []string{"10,00", "20,00", "30,00"} {
+		serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/sales", `{"amount":"`+amount+`"}`)
+	}
+
+	rec := serveJSON(handler, http.MethodGet, "/api/v1/cash-book/verify", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	proof := decodeProof(t, rec)
+	assert.True(t, proof.Gapless)
+	assert.True(t, proof.Intact)
+	assert.Equal(t, []uint64{1, 3, 3}, []uint64{proof.First, proof.Last, uint64(proof.Entries)})
+	entry, err := server.store.CashBookEntry(3)
+	assert.NoError(t, err)
+	assert.Equal(t, entry.Hash, proof.LastHash)
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/cash-book/verify?from=2020-01-01&to=2020-02-01", "")
+	proof = decodeProof(t, rec)
+	assert.True(t, proof.Gapless)
+	assert.Equal(t, 0, proof.Entries)
+	assert.Equal(t, genesisHash, proof.LastHash)
+
+	// remove the second entry and change the amount of the first behind the store's back
+	err = server.store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(cashBookBucket)
+		var first CashBookEntry
+		if err := getJSON(bucket, 1, "cash book entry", &first); err != nil {
+			return err
+		}
+		first.Amount = 1
+		if err := putJSON(bucket, itob(1), first); err != nil {
+			return err
+		}
+		return bucket.Delete(itob(2))
+	})
+	assert.NoError(t, err)
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/cash-book/verify?from=2020-01-01", "")
+	proof = decodeProof(t, rec)
+	assert.False(t, proof.Gapless)
+	assert.False(t, proof.Intact)
+	assert.Equal(t, []uint64{2}, proof.Missing)
+	assert.Equal(t, []uint64{1, 3}, proof.Broken)
+	assert.Equal(t, 2, proof.Entries)
+
+	for query, field := range map[string]string{
+		"from=yesterday":                "from",
+		"to=tomorrow":                   "to",
+		"from=2026-10-01&to=2026-10-01": "to",
+	} {
+		rec := serveJSON(handler, http.MethodGet, "/api/v1/cash-book/verify?"+query, "")
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, query)
+		assert.Contains(t, rec.Body.String(), `"field":"`+field+`"`, query)
+	}
+}
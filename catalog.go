@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+type DenominationKind string
+
+const (
+	KindCoin DenominationKind = "coin"
+	KindNote DenominationKind = "note"
+)
+
+// Denomination describes a single note or coin of a currency. Value is the face value in minor
+// units (cents, Rappen). Coins are packed according to their PackingRule.
+type Denomination struct {
+	Code  string           `json:"code"`
+	Value Money            `json:"value"`
+	Kind  DenominationKind `json:"kind"`
+	PackingRule
+}
+
+// Currency lists the denominations of a currency from the largest to the smallest. The order is
+// kept in every response that lists denominations.
+type Currency struct {
+	Code          string         `json:"code"`
+	Denominations []Denomination `json:"denominations"`
+}
+
+// Catalog holds every currency the server can count. Requests that do not name a currency
+// are counted in DefaultCurrency.
+type Catalog struct {
+	DefaultCurrency string     `json:"defaultCurrency"`
+	Currencies      []Currency `json:"currencies"`
+}
+
+// DefaultCatalog returns the catalog used when no configuration file is given. It holds the euro
+// with the codes the v1 API has always used as JSON keys, packed the way German banks hand out coins.
+func DefaultCatalog() Catalog {
+	return Catalog{
+		DefaultCurrency: "EUR",
+		Currencies: []Currency{{
+			Code: "EUR",
+			Denominations: []Denomination{
+				{Code: "euro200", Value: 20000, Kind: KindNote},
+				{Code: "euro100", Value: 10000, Kind: KindNote},
+				{Code: "euro50", Value: 5000, Kind: KindNote},
+				{Code: "euro20", Value: 2000, Kind: KindNote},
+				{Code: "euro10", Value: 1000, Kind: KindNote},
+				{Code: "euro5", Value: 500, Kind: KindNote},
+				{Code: "euro2", Value: 200, Kind: KindCoin, PackingRule: PackingRule{RollSize: 25, RollsPerBox: 3}},
+				{Code: "euro1", Value: 100, Kind: KindCoin, PackingRule: PackingRule{RollSize: 25, RollsPerBox: 3}},
+				{Code: "cent50", Value: 50, Kind: KindCoin, PackingRule: PackingRule{RollSize: 40, RollsPerBox: 3}},
+				{Code: "cent20", Value: 20, Kind: KindCoin, PackingRule: PackingRule{RollSize: 40, RollsPerBox: 3}},
+				{Code: "cent10", Value: 10, Kind: KindCoin, PackingRule: PackingRule{RollSize: 40, RollsPerBox: 3}},
+				{Code: "cent5", Value: 5, Kind: KindCoin, PackingRule: PackingRule{RollSize: 50, RollsPerBox: 3}},
+				{Code: "cent2", Value: 2, Kind: KindCoin, PackingRule: PackingRule{RollSize: 50, RollsPerBox: 5}},
+				{Code: "cent1", Value: 1, Kind: KindCoin, PackingRule: PackingRule{RollSize: 50, RollsPerBox: 5}},
+			},
+		}},
+	}
+}
+
+// Currency returns the currency with the given code. An empty code selects the default currency.
+func (c *Catalog) Currency(code string) (*Currency, bool) {
+	if code == "" {
+		code = c.DefaultCurrency
+	}
+	for i := range c.Currencies {
+		if c.Currencies[i].Code == code {
+			return &c.Currencies[i], true
+		}
+	}
+	return nil, false
+}
+
+// Denomination returns the denomination with the given code.
+func (c *Currency) Denomination(code string) (*Denomination, bool) {
+	for i := range c.Denominations {
+		if c.Denominations[i].Code == code {
+			return &c.Denominations[i], true
+		}
+	}
+	return nil, false
+}
+
+// RollValue returns the value of a full roll of the denomination.
+func (d *Denomination) RollValue() Money {
+	return d.Value * Money(d.RollSize)
+}
+
+// BoxValue returns the value of a full box of the denomination.
+func (d *Denomination) BoxValue() Money {
+	return d.RollValue() * Money(d.RollsPerBox)
+}
+
+// Validate checks that the catalog is usable for calculations: the default currency exists,
+// codes are unique, face values are positive and only coins are packed into rolls and boxes.
+func (c *Catalog) Validate() error {
+	if len(c.Currencies) == 0 {
+		return errors.New("catalog has no currencies")
+	}
+	if _, ok := c.Currency(c.DefaultCurrency); !ok {
+		return fmt.Errorf("default currency %q is not in the catalog", c.DefaultCurrency)
+	}
+
+	currencies := make(map[string]bool)
+	for _, currency := range c.Currencies {
+		if currency.Code == "" {
+			return errors.New("currency without code")
+		}
+		if currencies[currency.Code] {
+			return fmt.Errorf("currency %s is listed twice", currency.Code)
+		}
+		currencies[currency.Code] = true
+
+		codes := make(map[string]bool)
+		for _, d := range currency.Denominations {
+			switch {
+			case d.Code == "":
+				return fmt.Errorf("%s: denomination without code", currency.Code)
+			case codes[d.Code]:
+				return fmt.Errorf("%s: denomination %s is listed twice", currency.Code, d.Code)
+			case d.Value <= 0:
+				return fmt.Errorf("%s: denomination %s needs a positive value", currency.Code, d.Code)
+			case d.Kind != KindCoin && d.Kind != KindNote:
+				return fmt.Errorf("%s: denomination %s has unknown kind %q", currency.Code, d.Code, d.Kind)
+			case d.RollSize < 0 || d.RollsPerBox < 0:
+				return fmt.Errorf("%s: denomination %s has negative packing", currency.Code, d.Code)
+			case d.Kind == KindNote && (d.RollSize > 0 || d.RollsPerBox > 0):
+				return fmt.Errorf("%s: note %s cannot be rolled or boxed", currency.Code, d.Code)
+			case d.RollSize == 0 && d.RollsPerBox > 0:
+				return fmt.Errorf("%s: denomination %s is boxed but has no roll size", currency.Code, d.Code)
+			}
+			codes[d.Code] = true
+		}
+	}
+	return nil
+}
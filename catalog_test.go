@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const swissConfig = `{
+	"catalog": {
+		"defaultCurrency": "EUR",
+		"currencies": [
+			{"code": "EUR", "denominations": [{"code": "euro2", "value": 200, "kind": "coin", "rollSize": 25, "rollsPerBox": 3}]},
+			{"code": "CHF", "denominations": [
+				{"code": "chf100", "value": 10000, "kind": "note"},
+				{"code": "chf5", "value": 500, "kind": "coin", "rollSize": 20, "rollsPerBox": 5},
+				{"code": "rappen20", "value": 20, "kind": "coin", "rollSize": 50}
+			]}
+		]
+	}
+}`
+
+// writeConfig writes content to a config file in a temporary directory and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigDefault(t *testing.T) {
+	config, err := LoadConfig("")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultCatalog(), config.Catalog)
+}
+
+func TestLoadConfigCatalog(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, swissConfig))
+	assert.NoError(t, err)
+
+	chf, ok := config.Catalog.Currency("CHF")
+	assert.True(t, ok)
+	request := RequestPayload{
+		Currency:          "CHF",
+		RequestValidation: RequestValidation{TargetValue: "700"},
+		RequestValues:     RequestValues{"chf100": {1, 0, 0, 0, 0}, "chf5": {1, 1, 0, 0, 0}},
+		RollValues:        RollValues{"chf5": {1, 0}},
+		BoxValues:         BoxValues{"chf5": {1}},
+	}
+
+	loose, boxes, rolls, difference, err := CalculateValuesForCashCounts(&config.Catalog, request)
+	assert.NoError(t, err)
+	assert.Equal(t, Money(11000), loose)
+	assert.Equal(t, Money(10000), rolls)
+	assert.Equal(t, Money(50000), boxes)
+	assert.Equal(t, Money(1000), difference)
+	assert.Equal(t, Money(11000), CalculateDailyValues(chf, request.RequestValues))
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown field":         `{"catalog": {"currencies": []}, "colour": "blue"}`,
+		"missing default":       `{"catalog": {"defaultCurrency": "USD", "currencies": [{"code": "CHF"}]}}`,
+		"duplicate code":        `{"catalog": {"defaultCurrency": "CHF", "currencies": [{"code": "CHF", "denominations": [{"code": "a", "value": 1, "kind": "coin"}, {"code": "a", "value": 2, "kind": "coin"}]}]}}`,
+		"rolled note":           `{"catalog": {"defaultCurrency": "CHF", "currencies": [{"code": "CHF", "denominations": [{"code": "a", "value": 1000, "kind": "note", "rollSize": 10}]}]}}`,
+		"zero value":            `{"catalog": {"defaultCurrency": "CHF", "currencies": [{"code": "CHF", "denominations": [{"code": "a", "value": 0, "kind": "coin"}]}]}}`,
+		"boxed without rolling": `{"catalog": {"defaultCurrency": "CHF", "currencies": [{"code": "CHF", "denominations": [{"code": "a", "value": 5, "kind": "coin", "rollsPerBox": 2}]}]}}`,
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfig(t, content))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCalculateValuesForCashCountsInvalidDenomination(t *testing.T) {
+	tests := []struct {
+		name      string
+		request   RequestPayload
+		wantField string
+	}{
+		{"unknown currency", RequestPayload{Currency: "USD"}, "currency"},
+		{"unknown denomination", RequestPayload{RequestValues: RequestValues{"chf5": {1}}}, "requestValues.chf5"},
+		{"rolled note", RequestPayload{RollValues: RollValues{"euro10": {1}}}, "rollValues.euro10"},
+		{"boxed note", RequestPayload{BoxValues: BoxValues{"euro50": {1}}}, "boxValues.euro50"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.request.RequestValidation.TargetValue = "0"
+			_, _, _, _, err := CalculateValuesForCashCounts(&testCatalog, tt.request)
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("CalculateValuesForCashCounts() error = %v, want a ValidationError", err)
+			}
+			assert.Equal(t, tt.wantField, validationErr.Field)
+		})
+	}
+}
+
+func TestLoadConfigCatalogDoesNotInheritDefaults(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, `{"catalog": {"defaultCurrency": "XTS", "currencies": [
+		{"code": "XTS", "denominations": [
+			{"code": "n7", "value": 700, "kind": "note"}, {"code": "n6", "value": 600, "kind": "note"},
+			{"code": "n5", "value": 500, "kind": "note"}, {"code": "n4", "value": 400, "kind": "note"},
+			{"code": "n3", "value": 300, "kind": "note"}, {"code": "n2", "value": 200, "kind": "note"},
+			{"code": "c1", "value": 1, "kind": "coin"}
+		]}
+	]}}`))
+	assert.NoError(t, err)
+	currency, ok := config.Catalog.Currency("")
+	assert.True(t, ok)
+	for _, d := range currency.Denominations {
+		assert.Zero(t, d.RollSize, d.Code)
+		assert.Zero(t, d.RollsPerBox, d.Code)
+	}
+}
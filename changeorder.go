@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// ChangeOrderConfig sets how change orders are planned. HistoryWeeks is how far back counts
+// are used to estimate consumption, CoverWeeks how long an order has to last. MinimumRolls
+// is the stock of rolls, per currency and denomination, a register should never fall below;
+// boxes count with their rolls.
+type ChangeOrderConfig struct {
+	HistoryWeeks int                       `json:"historyWeeks"`
+	CoverWeeks   int                       `json:"coverWeeks"`
+	MinimumRolls map[string]map[string]int `json:"minimumRolls"`
+}
+
+// maxHistoryWeeks caps how far back counts are read for a change order. Consumption older
+// than a year says little about the next order.
+const maxHistoryWeeks = 52
+
+// ChangeOrderLine is the estimated consumption, the stock and the suggested order of one
+// coin denomination. Stock and minimum are in rolls, boxes counted with their rolls.
+type ChangeOrderLine struct {
+	Denomination string  `json:"denomination"`
+	RollsPerWeek float64 `json:"rollsPerWeek"`
+	BoxesPerWeek float64 `json:"boxesPerWeek"`
+	StockRolls   int     `json:"stockRolls"`
+	MinimumRolls int     `json:"minimumRolls"`
+	OrderBoxes   int     `json:"orderBoxes"`
+	OrderRolls   int     `json:"orderRolls"`
+	OrderValue   Money   `json:"orderValue"`
+}
+
+// ChangeOrder is the change a register should order from the bank. Amounts are in cents.
+type ChangeOrder struct {
+	RegisterID string            `json:"registerId"`
+	Currency   string            `json:"currency"`
+	From       time.Time         `json:"from"`
+	To         time.Time         `json:"to"`
+	Counts     int               `json:"counts"`
+	CoverWeeks int               `json:"coverWeeks"`
+	Lines      []ChangeOrderLine `json:"lines"`
+	TotalValue Money             `json:"totalValue"`
+}
+
+// Validate checks the weeks and that every minimum names a coin packed in rolls.
+func (c ChangeOrderConfig) Validate(catalog *Catalog) error {
+	if c.HistoryWeeks <= 0 || c.CoverWeeks <= 0 {
+		return fmt.Errorf("historyWeeks and coverWeeks must be positive")
+	}
+	if c.HistoryWeeks > maxHistoryWeeks {
+		return fmt.Errorf("historyWeeks must not exceed %d", maxHistoryWeeks)
+	}
+	for _, code := range sortedKeys(c.MinimumRolls) {
+		currency, ok := catalog.Currency(code)
+		if !ok || code == "" {
+			return fmt.Errorf("minimumRolls: unknown currency %q", code)
+		}
+		for _, denomination := range sortedKeys(c.MinimumRolls[code]) {
+			d, ok := currency.Denomination(denomination)
+			if !ok || d.RollSize == 0 {
+				return fmt.Errorf("minimumRolls: %s %s is not packed in rolls", code, denomination)
+			}
+			if c.MinimumRolls[code][denomination] < 0 {
+				return fmt.Errorf("minimumRolls: %s %s must not be negative", code, denomination)
+			}
+		}
+	}
+	return nil
+}
+
+// PlanChangeOrder estimates the weekly consumption of rolls and boxes from the counts of a
+// register, given in the order they were saved, and suggests an order that keeps every coin
+// above its minimum for coverWeeks. Consumption is the drop in stock from one count to the
+// next; a rise is a delivery and is not counted. The rate is taken over the time between the
+// first and the last count. The latest count is the current stock.
+func PlanChangeOrder(currency *Currency, records []CountRecord, minimums map[string]int, coverWeeks int) ChangeOrder {
+	order := ChangeOrder{Currency: currency.Code, Counts: len(records), CoverWeeks: coverWeeks, Lines: []ChangeOrderLine{}}
+	if len(records) == 0 {
+		return order
+	}
+	weeks := records[len(records)-1].CreatedAt.Sub(records[0].CreatedAt).Hours() / (7 * 24)
+
+	stocks := make([]map[countKey]int, len(records))
+	for i, record := range records {
+		stocks[i] = make(map[countKey]int)
+		for _, c := range record.Counts {
+			stocks[i][countKey{c.Denomination, c.Form}] += c.Quantity
+		}
+	}
+
+	for _, d := range currency.Denominations {
+		if d.RollSize == 0 {
+			continue
+		}
+		rollKey, boxKey := countKey{d.Code, FormRoll}, countKey{d.Code, FormBox}
+		inRolls := func(stock map[countKey]int) int {
+			return stock[rollKey] + stock[boxKey]*d.RollsPerBox
+		}
+
+		var usedRolls, usedBoxes int
+		for i := 1; i < len(stocks); i++ {
+			usedRolls += max(inRolls(stocks[i-1])-inRolls(stocks[i]), 0)
+			usedBoxes += max(stocks[i-1][boxKey]-stocks[i][boxKey], 0)
+		}
+
+		line := ChangeOrderLine{
+			Denomination: d.Code,
+			StockRolls:   inRolls(stocks[len(stocks)-1]),
+			MinimumRolls: minimums[d.Code],
+		}
+		if weeks > 0 {
+			line.RollsPerWeek = math.Round(float64(usedRolls)/weeks*10) / 10
+			line.BoxesPerWeek = math.Round(float64(usedBoxes)/weeks*10) / 10
+		}
+
+		needed := line.MinimumRolls + int(math.Ceil(line.RollsPerWeek*float64(coverWeeks))) - line.StockRolls
+		if needed > 0 {
+			if d.RollsPerBox > 0 {
+				line.OrderBoxes, line.OrderRolls = needed/d.RollsPerBox, needed%d.RollsPerBox
+			} else {
+				line.OrderRolls = needed
+			}
+			line.OrderValue = CountValue(&d, FormBox, line.OrderBoxes) + CountValue(&d, FormRoll, line.OrderRolls)
+			order.TotalValue += line.OrderValue
+		}
+		if line.StockRolls > 0 || line.MinimumRolls > 0 || usedRolls > 0 {
+			order.Lines = append(order.Lines, line)
+		}
+	}
+	return order
+}
+
+// handleChangeOrder suggests a change order for the register in the path from its counts of the
+// last weeks, taken from the "weeks" query parameter or the configuration.
+func (s *Server) handleChangeOrder(w http.ResponseWriter, r *http.Request) {
+	registerID := r.PathValue("registerId")
+	weeks := s.config.ChangeOrder.HistoryWeeks
+	if value := r.URL.Query().Get("weeks"); value != "" {
+		var err error
+		weeks, err = strconv.Atoi(value)
+		if err != nil || weeks <= 0 || weeks > maxHistoryWeeks {
+			respondWithError(w, &ValidationError{Field: "weeks", Reason: fmt.Sprintf("must be a number between 1 and %d", maxHistoryWeeks)})
+			return
+		}
+	}
+
+	to := time.Now()
+	from := to.AddDate(0, 0, -7*weeks)
+	records, err := s.store.ListCounts(CountFilter{RegisterID: registerID, From: from})
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	// rejected and superseded counts did not find the stock that was in the drawer
+	records = slices.DeleteFunc(records, func(record CountRecord) bool {
+		return record.Status == StatusRejected || record.Status == StatusSuperseded
+	})
+	if len(records) == 0 {
+		respondWithError(w, fmt.Errorf("counts of register %s: %w", registerID, ErrNotFound))
+		return
+	}
+	reverseRecords(records)
+
+	code := records[len(records)-1].Currency
+	currency, ok := s.config.Catalog.Currency(code)
+	if !ok {
+		respondWithError(w, fmt.Errorf("register %s counts in currency %s which is no longer configured", registerID, code))
+		return
+	}
+	// drops are cash taken out of the drawer, not its stock
+	var sameCurrency []CountRecord
+	for _, record := range records {
+		if record.Currency == currency.Code && record.Kind != CountDrop {
+			sameCurrency = append(sameCurrency, record)
+		}
+	}
+
+	order := PlanChangeOrder(currency, sameCurrency, s.config.ChangeOrder.MinimumRolls[currency.Code], s.config.ChangeOrder.CoverWeeks)
+	order.RegisterID = registerID
+	order.From, order.To = from.UTC(), to.UTC()
+	respondWithJSON(w, order)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// consumptionRecords returns three weekly counts of register R1 ending at end: cent1 is used
+// up steadily, cent10 is restocked in the last week.
+func consumptionRecords(end time.Time) []CountRecord {
+	counts := [][]Count{
+		{
+			{Denomination: "cent1", Form: FormRoll, Quantity: 10},
+			{Denomination: "cent1", Form: FormBox, Quantity: 2},
+			{Denomination: "cent10", Form: FormRoll, Quantity: 6},
+		},
+		{
+			{Denomination: "cent1", Form: FormRoll, Quantity: 8},
+			{Denomination: "cent1", Form: FormBox, Quantity: 1},
+			{Denomination: "cent10", Form: FormRoll, Quantity: 2},
+		},
+		{
+			{Denomination: "cent1", Form: FormRoll, Quantity: 4},
+			{Denomination: "cent1", Form: FormBox, Quantity: 1},
+			{Denomination: "cent10", Form: FormRoll, Quantity: 10},
+			{Denomination: "euro50", Form: FormLoose, Quantity: 3},
+		},
+	}
+	records := make([]CountRecord, len(counts))
+	for i := range counts {
+		records[i] = NewCountRecord(euro(), counts[i], 0)
+		records[i].RegisterID = "R1"
+		records[i].CreatedAt = end.AddDate(0, 0, 7*(i-len(counts)+1))
+	}
+	return records
+}
+
+func TestPlanChangeOrder(t *testing.T) {
+	records := consumptionRecords(time.Date(2026, 10, 17, 18, 0, 0, 0, time.UTC))
+
+	order := PlanChangeOrder(euro(), records, map[string]int{"cent1": 10}, 1)
+	assert.Equal(t, 3, order.Counts)
+	assert.Equal(t, []ChangeOrderLine{
+		{Denomination: "cent10", RollsPerWeek: 2, StockRolls: 10},
+		// 7 + 4 rolls in two weeks, one box opened; 10 + 6 needed, 9 in stock
+		{Denomination: "cent1", RollsPerWeek: 5.5, BoxesPerWeek: 0.5, StockRolls: 9, MinimumRolls: 10, OrderBoxes: 1, OrderRolls: 2, OrderValue: 350},
+	}, order.Lines)
+	assert.Equal(t, Money(350), order.TotalValue)
+
+	// two weeks of cover without minimum: 11 needed, 9 in stock
+	order = PlanChangeOrder(euro(), records, nil, 2)
+	assert.Equal(t, 0, order.Lines[0].OrderRolls)
+	assert.Equal(t, 0, order.Lines[1].OrderBoxes)
+	assert.Equal(t, 2, order.Lines[1].OrderRolls)
+
+	// a single count has no consumption yet
+	order = PlanChangeOrder(euro(), records[2:], map[string]int{"cent1": 10}, 1)
+	assert.Equal(t, 0.0, order.Lines[1].RollsPerWeek)
+	assert.Equal(t, 1, order.Lines[1].OrderRolls)
+}
+
+func TestHandleChangeOrder(t *testing.T) {
+	server := newTestServer(t)
+	server.config.ChangeOrder.MinimumRolls = map[string]map[string]int{"EUR": {"cent1": 10}}
+	for _, record := range consumptionRecords(time.Now().Add(-time.Hour)) {
+		assert.NoError(t, server.store.SaveCount(&record))
+	}
+	// a rejected count of an empty drawer does not count as consumption
+	rejected := NewCountRecord(euro(), nil, 0)
+	rejected.RegisterID, rejected.Status, rejected.CreatedAt = "R1", StatusRejected, time.Now().Add(-2*time.Hour)
+	assert.NoError(t, server.store.SaveCount(&rejected))
+	handler := server.routes()
+
+	rec := serveJSON(handler, http.MethodGet, "/api/v1/registers/R1/change-order", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var order ChangeOrder
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&order))
+	assert.Equal(t, "R1", order.RegisterID)
+	assert.Equal(t, Money(350), order.TotalValue)
+
+	// only the last count falls into one week
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/registers/R1/change-order?weeks=1", "")
+	order = ChangeOrder{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&order))
+	assert.Equal(t, 1, order.Counts)
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/registers/R1/change-order?weeks=0", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/registers/R1/change-order?weeks=53", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/registers/R2/change-order", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestLoadConfigChangeOrder(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, `{"changeOrder": {"coverWeeks": 2, "minimumRolls": {"EUR": {"cent1": 10}}}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, config.ChangeOrder.HistoryWeeks)
+	assert.Equal(t, 2, config.ChangeOrder.CoverWeeks)
+
+	for _, content := range []string{
+		`{"changeOrder": {"historyWeeks": 0}}`,
+		`{"changeOrder": {"minimumRolls": {"USD": {"cent1": 10}}}}`,
+		`{"changeOrder": {"minimumRolls": {"EUR": {"euro50": 10}}}}`,
+		`{"changeOrder": {"minimumRolls": {"EUR": {"cent1": -1}}}}`,
+	} {
+		_, err := LoadConfig(writeConfig(t, content))
+		assert.Error(t, err, content)
+	}
+}
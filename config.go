@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Config is read from the JSON file passed with the -config flag.
+// Sections missing from the file keep the values of DefaultConfig.
+type Config struct {
+	Catalog     Catalog           `json:"catalog"`
+	Packing     PackingRules      `json:"packing"`
+	Bank        BankAccount       `json:"bank"`
+	ChangeOrder ChangeOrderConfig `json:"changeOrder"`
+	Tolerance   ToleranceRules    `json:"tolerance"`
+	Approval    ApprovalConfig    `json:"approval"`
+	Auth        AuthConfig        `json:"auth"`
+	Datev       DatevConfig       `json:"datev"`
+	Tenancy     TenancyConfig     `json:"tenancy"`
+}
+
+// DefaultConfig returns the configuration used when no file is given.
+func DefaultConfig() Config {
+	return Config{
+		Catalog:     DefaultCatalog(),
+		ChangeOrder: ChangeOrderConfig{HistoryWeeks: 4, CoverWeeks: 1},
+		Tolerance:   DefaultToleranceRules(),
+		Approval:    ApprovalConfig{MinSeverity: SeverityCritical},
+		Datev:       DatevConfig{FiscalYearStart: 1, AccountLength: 4},
+	}
+}
+
+// LoadConfig reads the configuration file at path on top of DefaultConfig and validates it.
+// An empty path returns the default configuration.
+func LoadConfig(path string) (*Config, error) {
+	config := DefaultConfig()
+	if path == "" {
+		return &config, nil
+	}
+	// the decoder would merge a list from the file into the default elements, so lists start
+	// empty and get their defaults back only if the file leaves them out
+	config.Catalog.Currencies, config.Tolerance = nil, nil
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening config: %w", err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("error decoding config %s: %w", path, err)
+	}
+	if config.Catalog.Currencies == nil {
+		config.Catalog.Currencies = DefaultCatalog().Currencies
+	}
+	if config.Tolerance == nil {
+		config.Tolerance = DefaultToleranceRules()
+	}
+	if err := config.Catalog.ApplyPacking(config.Packing); err != nil {
+		return nil, fmt.Errorf("invalid packing in %s: %w", path, err)
+	}
+	if err := config.Catalog.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid catalog in %s: %w", path, err)
+	}
+	if err := config.ChangeOrder.Validate(&config.Catalog); err != nil {
+		return nil, fmt.Errorf("invalid changeOrder in %s: %w", path, err)
+	}
+	if err := config.Tolerance.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid tolerance in %s: %w", path, err)
+	}
+	if err := config.Approval.MinSeverity.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid approval in %s: %w", path, err)
+	}
+	if err := config.Auth.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid auth in %s: %w", path, err)
+	}
+	if err := config.Datev.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid datev in %s: %w", path, err)
+	}
+	return &config, nil
+}
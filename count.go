@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type CountForm string
+
+const (
+	FormLoose CountForm = "loose"
+	FormRoll  CountForm = "roll"
+	FormBox   CountForm = "box"
+)
+
+// CountKind tells where in the day a count was taken. Counts without kind are interim counts.
+type CountKind string
+
+const (
+	CountOpening CountKind = "opening"
+	CountInterim CountKind = "interim"
+	CountClosing CountKind = "closing"
+	// CountHandover counts are taken by both cashiers when a session changes hands.
+	CountHandover CountKind = "handover"
+	// CountDrop counts are cash taken out of the drawer during a shift, not what is left in it.
+	CountDrop CountKind = "drop"
+)
+
+// Validate returns a ValidationError if k is not one of the known kinds.
+func (k CountKind) Validate() error {
+	switch k {
+	case "", CountOpening, CountInterim, CountClosing, CountHandover, CountDrop:
+		return nil
+	}
+	return &ValidationError{Field: "countKind", Reason: fmt.Sprintf("unknown count kind %q", k)}
+}
+
+// Count is the quantity of one denomination in one form. Column is a free name such as
+// "drawer-A" that groups counts in the breakdown; counts without column are left out of it.
+type Count struct {
+	Denomination string    `json:"denomination"`
+	Form         CountForm `json:"form"`
+	Quantity     int       `json:"quantity"`
+	Column       string    `json:"column,omitempty"`
+}
+
+// CashValues counts cash in the structures of the v1 endpoint, in the counts list of v2, or in
+// both. Drops and the counts and movements of the safe take it.
+type CashValues struct {
+	RequestValues RequestValues `json:"requestValues,omitempty"`
+	RollValues    RollValues    `json:"rollValues,omitempty"`
+	BoxValues     BoxValues     `json:"boxValues,omitempty"`
+	Counts        []Count       `json:"counts,omitempty"`
+}
+
+// counts validates the values against the currency and returns them as one list of counts,
+// the v2 counts first.
+func (values CashValues) counts(currency *Currency) ([]Count, error) {
+	if err := validateCountList(currency, values.Counts); err != nil {
+		return nil, err
+	}
+	v1 := RequestPayload{RequestValues: values.RequestValues, RollValues: values.RollValues, BoxValues: values.BoxValues}
+	if err := validateCounts(currency, v1); err != nil {
+		return nil, err
+	}
+	return append(append([]Count{}, values.Counts...), v1.Counts()...), nil
+}
+
+// CountRequest is the payload of the v2 calculate endpoint. Unlike RequestPayload it takes
+// any number of counts per denomination, form and column.
+type CountRequest struct {
+	RegisterID  string    `json:"registerId,omitempty"`
+	Cashier     string    `json:"cashier,omitempty"`
+	CountKind   CountKind `json:"countKind,omitempty"`
+	Currency    string    `json:"currency,omitempty"`
+	TargetValue string    `json:"targetValue"`
+	Counts      []Count   `json:"counts"`
+}
+
+// v1Columns names the five columns of the RequestValues arrays the way Counts does.
+var v1Columns = []string{"1", "2", "3", "4", "5"}
+
+// Counts converts the fixed-size arrays of a v1 request to counts. Loose counts get the 1-based
+// position of their array element as column, rolls and boxes no column. Zero quantities are skipped.
+func (request RequestPayload) Counts() []Count {
+	var counts []Count
+	for _, code := range sortedKeys(request.RequestValues) {
+		for i, quantity := range request.RequestValues[code] {
+			if quantity != 0 {
+				counts = append(counts, Count{Denomination: code, Form: FormLoose, Quantity: quantity, Column: v1Columns[i]})
+			}
+		}
+	}
+	for _, code := range sortedKeys(request.RollValues) {
+		rolls := request.RollValues[code]
+		if quantity := SumArray(rolls[:]); quantity != 0 {
+			counts = append(counts, Count{Denomination: code, Form: FormRoll, Quantity: quantity})
+		}
+	}
+	for _, code := range sortedKeys(request.BoxValues) {
+		boxes := request.BoxValues[code]
+		if quantity := SumArray(boxes[:]); quantity != 0 {
+			counts = append(counts, Count{Denomination: code, Form: FormBox, Quantity: quantity})
+		}
+	}
+	return counts
+}
+
+// CountValue returns the value of quantity pieces of the denomination in the given form.
+func CountValue(d *Denomination, form CountForm, quantity int) Money {
+	switch form {
+	case FormRoll:
+		return Money(quantity) * d.RollValue()
+	case FormBox:
+		return Money(quantity) * d.BoxValue()
+	default:
+		return Money(quantity) * d.Value
+	}
+}
+
+// validateCountList checks every count against the currency: the denomination must exist,
+// the form must be one the denomination is packed in and the quantity must not be negative.
+func validateCountList(currency *Currency, counts []Count) error {
+	for i, c := range counts {
+		field := "counts[" + strconv.Itoa(i) + "]"
+		d, ok := currency.Denomination(c.Denomination)
+		if !ok {
+			return &ValidationError{Field: field + ".denomination", Reason: "unknown denomination for " + currency.Code}
+		}
+		switch {
+		case c.Form != FormLoose && c.Form != FormRoll && c.Form != FormBox:
+			return &ValidationError{Field: field + ".form", Reason: fmt.Sprintf("unknown form %q", c.Form)}
+		case c.Form == FormRoll && d.RollSize == 0:
+			return &ValidationError{Field: field + ".form", Reason: "denomination is not packed in rolls"}
+		case c.Form == FormBox && d.RollsPerBox == 0:
+			return &ValidationError{Field: field + ".form", Reason: "denomination is not packed in boxes"}
+		case c.Quantity < 0:
+			return &ValidationError{Field: field + ".quantity", Reason: "must not be negative"}
+		}
+	}
+	return nil
+}
+
+// SumCounts returns the value of the loose, rolled and boxed counts. Counts must have been
+// validated against the currency.
+func SumCounts(currency *Currency, counts []Count) (Money, Money, Money) {
+	var loose, rolls, boxes Money
+	for _, c := range counts {
+		d, _ := currency.Denomination(c.Denomination)
+		value := CountValue(d, c.Form, c.Quantity)
+		switch c.Form {
+		case FormRoll:
+			rolls += value
+		case FormBox:
+			boxes += value
+		default:
+			loose += value
+		}
+	}
+	return loose, rolls, boxes
+}
+
+// calculateCounts validates a v2 request and calculates the total value, the difference to the
+// target value and the breakdown. The response has the same shape as the one of the v1 endpoint.
+func calculateCounts(catalog *Catalog, request CountRequest) (ResponsePayload, error) {
+	currency, ok := catalog.Currency(request.Currency)
+	if !ok {
+		return ResponsePayload{}, &ValidationError{Field: "currency", Reason: "unknown currency " + request.Currency}
+	}
+	if err := validateCountList(currency, request.Counts); err != nil {
+		return ResponsePayload{}, err
+	}
+	targetValue, err := ParseMoney(request.TargetValue)
+	if err != nil {
+		return ResponsePayload{}, &ValidationError{Field: "targetValue", Reason: err.Error()}
+	}
+
+	loose, rolls, boxes := SumCounts(currency, request.Counts)
+	return ResponsePayload{
+		PayloadType: 2,
+		ResponseValues: ResponseValues{
+			TotalValue:      FormatNumber(loose + rolls + boxes),
+			DifferenceValue: FormatNumber(loose + rolls + boxes - targetValue),
+			Breakdown: BreakdownValues{
+				LooseValue:    FormatNumber(loose),
+				RollValue:     FormatNumber(rolls),
+				BoxValue:      FormatNumber(boxes),
+				Columns:       CalculateColumnValues(currency, request.Counts, nil),
+				Denominations: CalculateDenominationValues(currency, request.Counts),
+			},
+		},
+	}, nil
+}
+
+// handleCalculateV2 decodes a CountRequest, saves it in the store and responds with the calculated values.
+// Unknown fields are rejected, so a misspelled field cannot silently count as zero. Without a
+// target value, the target imported from the POS for the register is used.
+func (s *Server) handleCalculateV2(w http.ResponseWriter, r *http.Request) {
+	var request CountRequest
+	if err := decodeStrict(r, &request); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := request.CountKind.Validate(); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if strings.TrimSpace(request.TargetValue) == "" {
+		target, err := s.importedTarget(r.Context(), request.RegisterID, request.Currency)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		request.TargetValue = target
+	}
+	responsePayload, err := calculateCounts(&s.config.Catalog, request)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	currency, _ := s.config.Catalog.Currency(request.Currency)
+	targetValue, _ := ParseMoney(request.TargetValue)
+	record := NewCountRecord(currency, request.Counts, targetValue)
+	record.RegisterID, record.Cashier, record.Kind = request.RegisterID, request.Cashier, request.CountKind
+	if err := s.saveCount(r.Context(), &record); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	responsePayload.CountID = record.ID
+	responsePayload.Classification = record.Classification
+	responsePayload.Status = record.Status
+	respondWithJSON(w, responsePayload)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestPayloadCounts(t *testing.T) {
+	request := RequestPayload{
+		RequestValues: RequestValues{"euro10": {1, 0, 0, 0, 2}, "cent1": {0, 3, 0, 0, 0}},
+		RollValues:    RollValues{"euro2": {1, 2}, "cent5": {0, 0}},
+		BoxValues:     BoxValues{"cent1": {1}},
+	}
+
+	assert.Equal(t, []Count{
+		{Denomination: "cent1", Form: FormLoose, Quantity: 3, Column: "2"},
+		{Denomination: "euro10", Form: FormLoose, Quantity: 1, Column: "1"},
+		{Denomination: "euro10", Form: FormLoose, Quantity: 2, Column: "5"},
+		{Denomination: "euro2", Form: FormRoll, Quantity: 3},
+		{Denomination: "cent1", Form: FormBox, Quantity: 1},
+	}, request.Counts())
+}
+
+func TestCalculateCounts(t *testing.T) {
+	request := CountRequest{
+		TargetValue: "200,00",
+		Counts: []Count{
+			{Denomination: "euro50", Form: FormLoose, Quantity: 1, Column: "drawer-A"},
+			{Denomination: "euro2", Form: FormRoll, Quantity: 3, Column: "drawer-B"},
+			{Denomination: "euro50", Form: FormLoose, Quantity: 1, Column: "drawer-B"},
+			{Denomination: "cent1", Form: FormBox, Quantity: 2, Column: "safe"},
+			{Denomination: "euro1", Form: FormLoose, Quantity: 4, Column: "drawer-F"},
+			{Denomination: "cent10", Form: FormLoose, Quantity: 5},
+		},
+	}
+
+	response, err := calculateCounts(&testCatalog, request)
+	assert.NoError(t, err)
+
+	values := response.ResponseValues
+	assert.Equal(t, "259,50", values.TotalValue)
+	assert.Equal(t, "59,50", values.DifferenceValue)
+	assert.Equal(t, "104,50", values.Breakdown.LooseValue)
+	assert.Equal(t, "150,00", values.Breakdown.RollValue)
+	assert.Equal(t, "5,00", values.Breakdown.BoxValue)
+	assert.Equal(t, []ColumnValues{
+		{Column: "drawer-A", Value: "50,00"},
+		{Column: "drawer-B", Value: "200,00"},
+		{Column: "safe", Value: "5,00"},
+		{Column: "drawer-F", Value: "4,00"},
+	}, values.Breakdown.Columns)
+	assert.Equal(t, DenominationValues{
+		Denomination: "euro50",
+		LooseValue:   "100,00",
+		RollValue:    "0,00",
+		BoxValue:     "0,00",
+		TotalValue:   "100,00",
+	}, values.Breakdown.Denominations[2])
+}
+
+func TestCalculateCountsMatchesV1(t *testing.T) {
+	request := RequestPayload{
+		RequestValidation: RequestValidation{TargetValue: "1.000,00"},
+		RequestValues:     RequestValues{"euro20": {1, 2, 3, 4, 5}, "cent10": {9, 0, 0, 0, 1}},
+		RollValues:        RollValues{"euro2": {3, 2}, "cent1": {6, 5}},
+		BoxValues:         BoxValues{"cent50": {1}, "cent2": {2}},
+	}
+
+	v1, err := calculateTotalValue(&testCatalog, request)
+	assert.NoError(t, err)
+	v2, err := calculateCounts(&testCatalog, CountRequest{TargetValue: "1.000,00", Counts: request.Counts()})
+	assert.NoError(t, err)
+
+	v2.ResponseValues.Breakdown.Columns = CalculateColumnValues(euro(), request.Counts(), v1Columns)
+	assert.Equal(t, v1, v2)
+}
+
+func TestCalculateCountsInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		count     Count
+		wantField string
+	}{
+		{"unknown denomination", Count{Denomination: "EUR_2", Form: FormLoose, Quantity: 1}, "counts[1].denomination"},
+		{"unknown form", Count{Denomination: "euro2", Form: "bag", Quantity: 1}, "counts[1].form"},
+		{"rolled note", Count{Denomination: "euro5", Form: FormRoll, Quantity: 1}, "counts[1].form"},
+		{"negative quantity", Count{Denomination: "euro2", Form: FormLoose, Quantity: -1}, "counts[1].quantity"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := CountRequest{
+				TargetValue: "0",
+				Counts:      []Count{{Denomination: "euro2", Form: FormBox, Quantity: 1}, tt.count},
+			}
+			_, err := calculateCounts(&testCatalog, request)
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("calculateCounts() error = %v, want a ValidationError", err)
+			}
+			assert.Equal(t, tt.wantField, validationErr.Field)
+		})
+	}
+}
+
+func TestHandleCalculateV2(t *testing.T) {
+	handler := newTestServer(t).routes()
+
+	body := `{"targetValue":"75","counts":[{"denomination":"euro2","form":"roll","quantity":3,"column":"drawer-A"}]}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v2/calculate", strings.NewReader(body)))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var response ResponsePayload
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Equal(t, "150,00", response.ResponseValues.TotalValue)
+	assert.Equal(t, "75,00", response.ResponseValues.DifferenceValue)
+
+	body = `{"targetValue":"75","counts":[{"denomination":"euro2","form":"roll","quantity":3,"colum":"drawer-A"}]}`
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v2/calculate", strings.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
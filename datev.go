@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DatevConfig holds what the cash book export needs to know about the books at DATEV: the
+// numbers of the tax advisor and the client, the month the fiscal year starts with, the length
+// of the general ledger accounts and the accounts the cash book is booked to. The difference
+// account takes shortages and overages, the safe account cash moved between the drawers and the
+// safe, the transit account cash on its way to the bank.
+type DatevConfig struct {
+	ConsultantNumber  int    `json:"consultantNumber"`
+	ClientNumber      int    `json:"clientNumber"`
+	FiscalYearStart   int    `json:"fiscalYearStart"`
+	AccountLength     int    `json:"accountLength"`
+	CashAccount       string `json:"cashAccount"`
+	DifferenceAccount string `json:"differenceAccount"`
+	SafeAccount       string `json:"safeAccount"`
+	TransitAccount    string `json:"transitAccount"`
+}
+
+// Validate checks the values that are set. Whether everything the export needs is set is only
+// checked by the export, so the server runs without a DATEV configuration.
+func (c DatevConfig) Validate() error {
+	switch {
+	case c.FiscalYearStart < 1 || c.FiscalYearStart > 12:
+		return errors.New("fiscalYearStart must be a month from 1 to 12")
+	case c.AccountLength < 4 || c.AccountLength > 8:
+		return errors.New("accountLength must be from 4 to 8")
+	case c.ConsultantNumber != 0 && (c.ConsultantNumber < 1001 || c.ConsultantNumber > 9999999):
+		return errors.New("consultantNumber must be from 1001 to 9999999")
+	case c.ClientNumber != 0 && (c.ClientNumber < 1 || c.ClientNumber > 99999):
+		return errors.New("clientNumber must be from 1 to 99999")
+	}
+	for _, account := range []struct{ name, number string }{
+		{"cashAccount", c.CashAccount},
+		{"differenceAccount", c.DifferenceAccount},
+		{"safeAccount", c.SafeAccount},
+		{"transitAccount", c.TransitAccount},
+	} {
+		_, err := strconv.ParseUint(account.number, 10, 32)
+		if account.number != "" && (err != nil || len(account.number) != c.AccountLength) {
+			return fmt.Errorf("%s must have %d digits", account.name, c.AccountLength)
+		}
+	}
+	return nil
+}
+
+// complete returns a ValidationError naming the first setting the export needs but is missing.
+func (c DatevConfig) complete() error {
+	for _, setting := range []struct {
+		name string
+		set  bool
+	}{
+		{"consultantNumber", c.ConsultantNumber != 0},
+		{"clientNumber", c.ClientNumber != 0},
+		{"cashAccount", c.CashAccount != ""},
+		{"differenceAccount", c.DifferenceAccount != ""},
+		{"safeAccount", c.SafeAccount != ""},
+		{"transitAccount", c.TransitAccount != ""},
+	} {
+		if !setting.set {
+			return &ValidationError{Field: "datev." + setting.name, Reason: "is not configured"}
+		}
+	}
+	return nil
+}
+
+// DatevBooking is one line of a DATEV booking batch. Amount is positive, Debit tells whether the
+// cash account is debited ("S") or credited ("H") against the contra account.
+type DatevBooking struct {
+	Date          time.Time
+	Amount        Money
+	Debit         bool
+	ContraAccount string
+	Document      string
+	Text          string
+}
+
+// datevBookings collects the bookings of the cash book entries booked between from and to, in
+// the order of the cash book: the difference of every finalised closing count against the
+// difference account, skims, drops and refills against the safe account and deposit slips
+// against the transit account. Reversals and corrections are booked like the entries they
+// reverse and correct; sales are left to the export of the POS.
+func (s *Server) datevBookings(currency *Currency, from, to time.Time) ([]DatevBooking, error) {
+	entries, err := s.store.ListCashBook(CashBookFilter{From: from, To: to})
+	if err != nil {
+		return nil, err
+	}
+	var bookings []DatevBooking
+	for _, entry := range entries {
+		if entry.Currency != currency.Code {
+			continue
+		}
+		kind, prefix := entry.Kind, ""
+		switch {
+		case entry.Reverses != 0:
+			original, err := s.store.CashBookEntry(entry.Reverses)
+			if err != nil {
+				return nil, err
+			}
+			kind, prefix = original.Kind, "Storno "
+		case entry.Corrects != 0:
+			prefix = "Korrektur "
+		}
+		if booking, ok := datevBooking(s.config.Datev, kind, entry, prefix != "Storno "); ok {
+			booking.Text = prefix + booking.Text
+			bookings = append(bookings, booking)
+		}
+	}
+	return bookings, nil
+}
+
+// datevBooking returns the booking of a cash book entry that books kind, or false if kind is
+// not exported or the entry moves no money. Shortages, skims, drops and deposits credit the
+// cash account, overages and refills debit it; the text names what the entry books if forward
+// is true and what it reverses otherwise.
+func datevBooking(config DatevConfig, kind CashBookKind, entry CashBookEntry, forward bool) (DatevBooking, bool) {
+	booking := DatevBooking{Date: entry.BookedAt, Amount: entry.Amount, ContraAccount: config.TransitAccount,
+		Document: fmt.Sprintf("S%d", entry.SessionID)}
+	if entry.CountID != 0 {
+		booking.Document = fmt.Sprintf("Z%d", entry.CountID)
+	}
+	switch kind {
+	case BookCount:
+		booking.Amount, booking.ContraAccount = entry.DifferenceValue, config.DifferenceAccount
+		booking.Text = "Kassenüberschuss " + entry.RegisterID
+		if (booking.Amount < 0) == forward {
+			booking.Text = "Kassenfehlbetrag " + entry.RegisterID
+		}
+	case BookSkim:
+		booking.ContraAccount, booking.Text = config.SafeAccount, "Abschöpfung "+entry.RegisterID
+	case BookRefill:
+		booking.ContraAccount, booking.Text = config.SafeAccount, "Wechselgeld "+entry.RegisterID
+	case BookDeposit:
+		booking.Document, booking.Text = fmt.Sprintf("E%06d", entry.SlipNumber), "Bankeinzahlung "+entry.RegisterID
+	default:
+		return booking, false
+	}
+	booking.Debit = booking.Amount > 0
+	if booking.Amount < 0 {
+		booking.Amount = -booking.Amount
+	}
+	return booking, booking.Amount != 0
+}
+
+// datevColumns are the leading columns of the DATEV booking batch up to the booking text. The
+// columns after it are optional and left out.
+var datevColumns = []string{"Umsatz (ohne Soll/Haben-Kz)", "Soll/Haben-Kennzeichen", "WKZ Umsatz", "Kurs",
+	"Basis-Umsatz", "WKZ Basis-Umsatz", "Konto", "Gegenkonto (ohne BU-Schlüssel)", "BU-Schlüssel", "Belegdatum",
+	"Belegfeld 1", "Belegfeld 2", "Skonto", "Buchungstext"}
+
+// writeDatev writes the bookings as a DATEV booking batch (format EXTF 700, category 21) for the
+// dates from up to, but not including, to. The file is encoded in Windows-1252 with CRLF line
+// endings, as DATEV expects.
+func writeDatev(w io.Writer, config DatevConfig, currency *Currency, from, to, created time.Time, bookings []DatevBooking) error {
+	fiscalYear := fiscalYearStart(from, config.FiscalYearStart)
+	last := to.AddDate(0, 0, -1)
+	header := []string{`"EXTF"`, "700", "21", `"Buchungsstapel"`, "13",
+		strings.Replace(created.Format("20060102150405.000"), ".", "", 1), "", `"RE"`, `""`, `""`,
+		strconv.Itoa(config.ConsultantNumber), strconv.Itoa(config.ClientNumber), fiscalYear.Format("20060102"),
+		strconv.Itoa(config.AccountLength), from.Format("20060102"), last.Format("20060102"),
+		datevText("Kassenbuch", 30), `""`,
+		"1", "0", "0", datevText(currency.Code, 3), "", `""`, "", "", `""`, "", "", "", `""`}
+
+	lines := []string{strings.Join(header, ";"), strings.Join(datevColumns, ";")}
+	for _, b := range bookings {
+		side := `"H"`
+		if b.Debit {
+			side = `"S"`
+		}
+		lines = append(lines, strings.Join([]string{
+			strings.Replace(b.Amount.String(), ".", ",", 1), side, `""`, "", "", `""`,
+			config.CashAccount, b.ContraAccount, `""`, b.Date.In(time.Local).Format("0201"),
+			datevText(b.Document, 36), `""`, "", datevText(b.Text, 60),
+		}, ";"))
+	}
+	_, err := w.Write(encodeWindows1252(strings.Join(lines, "\r\n") + "\r\n"))
+	return err
+}
+
+// fiscalYearStart returns the first day of the fiscal year that date falls into, for a fiscal
+// year starting with the given month.
+func fiscalYearStart(date time.Time, month int) time.Time {
+	start := time.Date(date.Year(), time.Month(month), 1, 0, 0, 0, 0, time.Local)
+	if start.After(date) {
+		start = start.AddDate(-1, 0, 0)
+	}
+	return start
+}
+
+// datevText quotes s as a DATEV text field of at most max characters. Quotes are doubled and
+// semicolons and line breaks, which some importers trip over even in quotes, are replaced.
+func datevText(s string, max int) string {
+	s = strings.NewReplacer(";", ",", "\r", " ", "\n", " ").Replace(s)
+	if runes := []rune(s); len(runes) > max {
+		s = string(runes[:max])
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// handleExportDatev exports the cash book of one currency as a DATEV booking batch. "from" and
+// "to" are dates, to exclusive, and default to the current month; they have to lie in one
+// fiscal year.
+func (s *Server) handleExportDatev(w http.ResponseWriter, r *http.Request) {
+	config := s.config.Datev
+	if err := config.complete(); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	query := r.URL.Query()
+	currency, ok := s.config.Catalog.Currency(query.Get("currency"))
+	if !ok {
+		respondWithError(w, &ValidationError{Field: "currency", Reason: "unknown currency " + query.Get("currency")})
+		return
+	}
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	to := from.AddDate(0, 1, 0)
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		if value := query.Get(param.name); value != "" {
+			date, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+			if err != nil {
+				respondWithError(w, &ValidationError{Field: param.name, Reason: "must be a date (YYYY-MM-DD)"})
+				return
+			}
+			*param.value = date
+		}
+	}
+	if !from.Before(to) {
+		respondWithError(w, &ValidationError{Field: "to", Reason: "must be after from"})
+		return
+	}
+	if to.After(fiscalYearStart(from, config.FiscalYearStart).AddDate(1, 0, 0)) {
+		respondWithError(w, &ValidationError{Field: "to", Reason: "from and to must lie in one fiscal year"})
+		return
+	}
+
+	bookings, err := s.datevBookings(currency, from, to)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=windows-1252")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="EXTF_Kassenbuch_%s_%s.csv"`,
+		from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102")))
+	if err := writeDatev(w, config, currency, from, to, now, bookings); err != nil {
+		log.Println("error writing DATEV export:", err)
+	}
+}
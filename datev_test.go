@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newDatevServer returns a test server with a complete DATEV configuration.
+func newDatevServer(t *testing.T) *Server {
+	t.Helper()
+	s := newTestServer(t)
+	s.config.Datev = DatevConfig{ConsultantNumber: 1234567, ClientNumber: 12345, FiscalYearStart: 1, AccountLength: 4,
+		CashAccount: "1000", DifferenceAccount: "2150", SafeAccount: "1010", TransitAccount: "1360"}
+	return s
+}
+
+func TestExportDatev(t *testing.T) {
+	handler := newDatevServer(t).routes()
+	for _, body := range []string{
+		// a shortage, an overage, a pending shortage and an interim count
+		`{"registerId":"R1","countKind":"closing","targetValue":"102,50","counts":[{"denomination":"euro50","form":"loose","quantity":2}]}`,
+		`{"registerId":"R2","countKind":"closing","targetValue":"49,00","counts":[{"denomination":"euro50","form":"loose","quantity":1}]}`,
+		`{"registerId":"R3","countKind":"closing","targetValue":"60,00","counts":[{"denomination":"euro50","form":"loose","quantity":1}]}`,
+		`{"registerId":"R4","targetValue":"51,00","counts":[{"denomination":"euro50","form":"loose","quantity":1}]}`,
+	} {
+		rec := serveJSON(handler, http.MethodPost, "/api/v2/calculate", body)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R5","expectedValue":"300,00"}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/registers/R5/drops", `{"counts":[{"denomination":"euro200","form":"loose","quantity":1}]}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/skims", `{"amount":"20,00"}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/sales", `{"amount":"30,00"}`)
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/deposit-slips", `{"countId":1,"branch":"Berlin"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	serveJSON(handler, http.MethodPost, "/api/v1/safes/main/movements", `{"type":"fromBank","requestValues":{"euro50":[1,0,0,0,0]}}`)
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/safes/main/movements",
+		`{"type":"toRegister","registerId":"R5","counts":[{"denomination":"euro50","form":"loose","quantity":1}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/exports/datev", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv; charset=windows-1252", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Header().Get("Content-Disposition"), `filename="EXTF_Kassenbuch_`)
+
+	body := rec.Body.String()
+	assert.True(t, strings.HasSuffix(body, "\r\n"))
+	lines := strings.Split(strings.TrimSuffix(body, "\r\n"), "\r\n")
+	now := time.Now()
+	first := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	header := strings.Split(lines[0], ";")
+	assert.Len(t, header, 31)
+	assert.Equal(t, []string{`"EXTF"`, "700", "21", `"Buchungsstapel"`, "13"}, header[:5])
+	assert.Equal(t, []string{"1234567", "12345", now.Format("2006") + "0101", "4", first.Format("20060102"),
+		first.AddDate(0, 1, -1).Format("20060102")}, header[10:16])
+	assert.Equal(t, `"EUR"`, header[21])
+	assert.Equal(t, "Umsatz (ohne Soll/Haben-Kz);Soll/Haben-Kennzeichen;WKZ Umsatz;Kurs;Basis-Umsatz;WKZ Basis-Umsatz;"+
+		"Konto;Gegenkonto (ohne BU-Schl\xfcssel);BU-Schl\xfcssel;Belegdatum;Belegfeld 1;Belegfeld 2;Skonto;Buchungstext", lines[1])
+
+	// the pending and the interim count and the sale are left out
+	day := now.Format("0201")
+	assert.Equal(t, []string{
+		`2,50;"H";"";;;"";1000;2150;"";` + day + `;"Z1";"";;"Kassenfehlbetrag R1"`,
+		`1,00;"S";"";;;"";1000;2150;"";` + day + `;"Z2";"";;"Kassen` + "\xfc" + `berschuss R2"`,
+		`200,00;"H";"";;;"";1000;1010;"";` + day + `;"Z5";"";;"Absch` + "\xf6" + `pfung R5"`,
+		`20,00;"H";"";;;"";1000;1010;"";` + day + `;"S1";"";;"Absch` + "\xf6" + `pfung R5"`,
+		`100,00;"H";"";;;"";1000;1360;"";` + day + `;"E000001";"";;"Bankeinzahlung R1"`,
+		`50,00;"S";"";;;"";1000;1010;"";` + day + `;"S1";"";;"Wechselgeld R5"`,
+	}, lines[2:])
+}
+
+func TestExportDatevReversals(t *testing.T) {
+	handler := newDatevServer(t).routes()
+	serveJSON(handler, http.MethodPost, "/api/v2/calculate",
+		`{"registerId":"R1","countKind":"closing","targetValue":"102,50","counts":[{"denomination":"euro50","form":"loose","quantity":2}]}`)
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/cash-book/1/reverse", `{"reason":"miscounted","amount":"101,00"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/exports/datev", "")
+	lines := strings.Split(strings.TrimSuffix(rec.Body.String(), "\r\n"), "\r\n")
+	day := time.Now().Format("0201")
+	assert.Equal(t, []string{
+		`2,50;"H";"";;;"";1000;2150;"";` + day + `;"Z1";"";;"Kassenfehlbetrag R1"`,
+		`2,50;"S";"";;;"";1000;2150;"";` + day + `;"Z1";"";;"Storno Kassenfehlbetrag R1"`,
+		`1,50;"H";"";;;"";1000;2150;"";` + day + `;"Z1";"";;"Korrektur Kassenfehlbetrag R1"`,
+	}, lines[2:])
+}
+
+func TestExportDatevErrors(t *testing.T) {
+	rec := serveJSON(newTestServer(t).routes(), http.MethodGet, "/api/v1/exports/datev", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"field":"datev.consultantNumber"`)
+
+	handler := newDatevServer(t).routes()
+	for query, field := range map[string]string{
+		"from=01.10.2026":                            "from",
+		"from=2026-10-01&to=2026-10-01":              "to",
+		"from=2026-12-01&to=2027-01-02":              "to",
+		"from=2026-10-01&to=2026-11-01&currency=XXX": "currency",
+	} {
+		rec := serveJSON(handler, http.MethodGet, "/api/v1/exports/datev?"+query, "")
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, query)
+		assert.Contains(t, rec.Body.String(), `"field":"`+field+`"`, query)
+	}
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/exports/datev?from=2026-12-01&to=2027-01-01", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestFiscalYearStart(t *testing.T) {
+	date := time.Date(2026, 3, 15, 0, 0, 0, 0, time.Local)
+	assert.Equal(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local), fiscalYearStart(date, 1))
+	assert.Equal(t, time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local), fiscalYearStart(date, 3))
+	assert.Equal(t, time.Date(2025, 7, 1, 0, 0, 0, 0, time.Local), fiscalYearStart(date, 7))
+}
+
+func TestDatevText(t *testing.T) {
+	assert.Equal(t, `"Kasse ""1"", R1"`, datevText(`Kasse "1"; R1`, 60))
+	assert.Equal(t, `"Kassen"`, datevText("Kassenbuch", 6))
+}
+
+func TestLoadConfigDatev(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, `{"datev": {"consultantNumber": 1234567, "clientNumber": 1, "cashAccount": "1000"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, config.Datev.FiscalYearStart)
+	assert.Equal(t, 4, config.Datev.AccountLength)
+
+	for _, body := range []string{
+		`{"datev": {"fiscalYearStart": 13}}`,
+		`{"datev": {"accountLength": 3}}`,
+		`{"datev": {"consultantNumber": 99}}`,
+		`{"datev": {"clientNumber": 100000}}`,
+		`{"datev": {"cashAccount": "10000"}}`,
+		`{"datev": {"accountLength": 5, "transitAccount": "1360x"}}`,
+		`{"datev": {"safeAccount": "101"}}`,
+	} {
+		_, err := LoadConfig(writeConfig(t, body))
+		assert.Error(t, err, body)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DropRequest records cash taken out of the drawer during a shift, usually large notes put into
+// the safe.
+type DropRequest struct {
+	Cashier  string `json:"cashier,omitempty"`
+	Currency string `json:"currency,omitempty"`
+	CashValues
+}
+
+// OpenSession returns the latest session of the register if it is not closed yet, or ErrNotFound.
+func (s *Store) OpenSession(registerID string) (Session, error) {
+	var session Session
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		session, found, err = openSession(tx, registerID)
+		return err
+	})
+	if err == nil && !found {
+		err = fmt.Errorf("open session of register %s: %w", registerID, ErrNotFound)
+	}
+	return session, err
+}
+
+// openSession is OpenSession within the transaction tx; it reports whether there is an open
+// session instead of returning ErrNotFound.
+func openSession(tx *bolt.Tx, registerID string) (Session, bool, error) {
+	cursor := tx.Bucket(sessionsBucket).Cursor()
+	for key, data := cursor.Last(); key != nil; key, data = cursor.Prev() {
+		var session Session
+		if err := json.Unmarshal(data, &session); err != nil {
+			return Session{}, false, fmt.Errorf("session %d: %w", btoi(key), err)
+		}
+		if session.RegisterID == registerID {
+			return session, session.State != SessionClosed, nil
+		}
+	}
+	return Session{}, false, nil
+}
+
+// handleDrop records a drop from the drawer of the register in the path. The drop is saved as a
+// count of the open session of the register and subtracted from its expected value. If the
+// register belongs to a store, the drop is also moved into the safe of the store in the same
+// transaction; the journal entry of the count covers the movement. The response is the saved
+// count.
+func (s *Server) handleDrop(w http.ResponseWriter, r *http.Request) {
+	var request DropRequest
+	if err := decodeStrict(r, &request); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	session, err := s.store.OpenSession(r.PathValue("registerId"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if request.Currency == "" {
+		request.Currency = session.Currency
+	}
+	currency, ok := s.config.Catalog.Currency(request.Currency)
+	if !ok {
+		respondWithError(w, &ValidationError{Field: "currency", Reason: "unknown currency " + request.Currency})
+		return
+	}
+	counts, err := request.CashValues.counts(currency)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	loose, rolls, boxes := SumCounts(currency, counts)
+	if loose+rolls+boxes <= 0 {
+		respondWithError(w, &ValidationError{Field: "counts", Reason: "the drop is empty"})
+		return
+	}
+
+	// a drop has no target to differ from, it only lowers the expected value of the session
+	record := NewCountRecord(currency, counts, loose+rolls+boxes)
+	record.RegisterID, record.Cashier, record.Kind = session.RegisterID, request.Cashier, CountDrop
+	record.SessionID = session.ID
+	// the store of the register is only known once saveCount has bound the count to it
+	moveToSafe := func(tx *bolt.Tx) error {
+		if record.StoreID == "" {
+			return nil
+		}
+		movement := dropMovement(&record)
+		return insertSafeMovement(tx, &movement)
+	}
+	if err := s.saveCount(r.Context(), &record, moveToSafe); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, record)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDropLowersExpectedValue(t *testing.T) {
+	handler := newTestServer(t).routes()
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","expectedValue":"100,00"}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/sales", `{"amount":"900,00"}`)
+
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/registers/R1/drops",
+		`{"cashier":"anna","requestValues":{"euro200":[1,1,0,0,0],"euro100":[0,0,1,0,0]},"counts":[{"denomination":"euro50","form":"loose","quantity":2}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var drop CountRecord
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&drop))
+	assert.Equal(t, CountDrop, drop.Kind)
+	assert.Equal(t, uint64(1), drop.SessionID)
+	assert.Equal(t, Money(60000), drop.TotalValue)
+	assert.Equal(t, Money(0), drop.DifferenceValue)
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/sessions/1", "")
+	session := decodeSession(t, rec)
+	assert.Equal(t, Money(60000), session.Skims)
+	assert.Equal(t, Money(40000), *session.ExpectedValue)
+	assert.Equal(t, drop.ID, session.Entries[1].CountID)
+	assert.Empty(t, session.Attempts)
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/counts",
+		`{"cashier":"anna","countKind":"closing","counts":[{"denomination":"euro200","form":"loose","quantity":2}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/registers/R1/z-report", "")
+	var report ZReport
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+	assert.Len(t, report.Drops, 1)
+	assert.Empty(t, report.InterimCounts)
+	assert.Equal(t, Money(60000), report.DropValue)
+	assert.Equal(t, Money(0), report.DifferenceValue)
+
+	// the session is closed now
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/registers/R1/drops", `{"counts":[{"denomination":"euro50","form":"loose","quantity":1}]}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestDropErrors(t *testing.T) {
+	handler := newTestServer(t).routes()
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/registers/R1/drops", `{"counts":[{"denomination":"euro50","form":"loose","quantity":1}]}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","expectedValue":"100,00"}`)
+	for body, field := range map[string]string{
+		`{"counts":[]}`: "counts",
+		`{"requestValues":{"euro3":[1,0,0,0,0]}}`:                          "requestValues.euro3",
+		`{"requestValues":{"euro50":[-1,0,0,0,0]}}`:                        "requestValues.euro50",
+		`{"counts":[{"denomination":"euro50","form":"box","quantity":1}]}`: "counts[0].form",
+	} {
+		rec = serveJSON(handler, http.MethodPost, "/api/v1/registers/R1/drops", body)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, body)
+		assert.Contains(t, rec.Body.String(), `"field":"`+field+`"`, body)
+	}
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/counts", `{"countKind":"drop","counts":[]}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
+
+func TestZReportAddsBackDropsOutsideTheSession(t *testing.T) {
+	eur := euro()
+	drop := NewCountRecord(eur, []Count{{Denomination: "euro100", Form: FormLoose, Quantity: 3}}, 30000)
+	drop.Kind = CountDrop
+	closing := NewCountRecord(eur, []Count{{Denomination: "euro50", Form: FormLoose, Quantity: 2}}, 40000)
+	closing.Kind = CountClosing
+
+	report, err := BuildZReport(&Catalog{Currencies: []Currency{*eur}}, "R1", closing.CreatedAt, []CountRecord{drop, closing})
+	assert.NoError(t, err)
+	assert.Equal(t, Money(-30000), closing.DifferenceValue)
+	assert.Equal(t, Money(0), report.DifferenceValue)
+}
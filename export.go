@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NumberFormat selects how amounts are written to an export.
+type NumberFormat string
+
+const (
+	// NumbersGerman writes amounts as FormatNumber does, "1.234,56".
+	NumbersGerman NumberFormat = "german"
+	// NumbersPlain writes amounts with a dot as decimal separator and no grouping, "1234.56".
+	NumbersPlain NumberFormat = "plain"
+)
+
+// rowWriter writes the rows of an export in one file format. Cells are strings or Money.
+type rowWriter interface {
+	WriteRow(cells []any) error
+	Close() error
+}
+
+// csvRows writes rows as CSV. German numbers are separated by semicolons, as their decimal
+// separator is the comma.
+type csvRows struct {
+	writer  *csv.Writer
+	numbers NumberFormat
+}
+
+func (c csvRows) WriteRow(cells []any) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		switch value := cell.(type) {
+		case Money:
+			record[i] = value.String()
+			if c.numbers == NumbersGerman {
+				record[i] = FormatNumber(value)
+			}
+		default:
+			record[i] = fmt.Sprint(value)
+		}
+	}
+	return c.writer.Write(record)
+}
+
+func (c csvRows) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// exportHeader returns the column names of a count export: the count, one column per
+// denomination of the currency, the subtotals per form, the total, target and difference.
+func exportHeader(currency *Currency) []any {
+	header := []any{"id", "createdAt", "registerId", "storeId", "cashier", "kind", "status", "currency"}
+	for _, d := range currency.Denominations {
+		header = append(header, d.Code)
+	}
+	return append(header, "looseValue", "rollValue", "boxValue", "totalValue", "targetValue", "differenceValue")
+}
+
+// exportRow returns the cells of a count in the order of exportHeader. The denomination
+// columns hold the value counted of the denomination in all forms.
+func exportRow(currency *Currency, record CountRecord) []any {
+	kind := record.Kind
+	if kind == "" {
+		kind = CountInterim
+	}
+	row := []any{fmt.Sprint(record.ID), record.CreatedAt.In(time.Local).Format(time.DateTime), record.RegisterID,
+		record.StoreID, record.Cashier, string(kind), string(record.Status), record.Currency}
+	values := make(map[string]Money)
+	for _, c := range record.Counts {
+		if d, ok := currency.Denomination(c.Denomination); ok {
+			values[c.Denomination] += CountValue(d, c.Form, c.Quantity)
+		}
+	}
+	for _, d := range currency.Denominations {
+		row = append(row, values[d.Code])
+	}
+	return append(row, record.LooseValue, record.RollValue, record.BoxValue, record.TotalValue,
+		record.TargetValue, record.DifferenceValue)
+}
+
+// handleExportCounts streams the stored counts of one currency as CSV or, with "format=xlsx", as
+// an XLSX workbook, one row per count, oldest first. The query parameters from, to, storeId and
+// status narrow the export as they narrow the list of counts; registerId may be given
+// several times or as a comma-separated list, countKind selects one kind of count. "numbers"
+// selects the German (default) or plain number format.
+func (s *Server) handleExportCounts(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseCountFilter(r)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	query := r.URL.Query()
+	registers := make(map[string]bool)
+	for _, value := range query["registerId"] {
+		for _, id := range strings.Split(value, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				registers[id] = true
+			}
+		}
+	}
+	filter.RegisterID = ""
+	kind := CountKind(query.Get("countKind"))
+	if err := kind.Validate(); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	currency, ok := s.config.Catalog.Currency(query.Get("currency"))
+	if !ok {
+		respondWithError(w, &ValidationError{Field: "currency", Reason: "unknown currency " + query.Get("currency")})
+		return
+	}
+	numbers := NumberFormat(query.Get("numbers"))
+	switch numbers {
+	case "":
+		numbers = NumbersGerman
+	case NumbersGerman, NumbersPlain:
+	default:
+		respondWithError(w, &ValidationError{Field: "numbers", Reason: fmt.Sprintf("unknown number format %q", numbers)})
+		return
+	}
+
+	var rows rowWriter
+	filename := "counts-" + time.Now().Format("20060102-150405")
+	switch format := query.Get("format"); format {
+	case "", "csv":
+		writer := csv.NewWriter(w)
+		if numbers == NumbersGerman {
+			writer.Comma = ';'
+		}
+		rows = csvRows{writer: writer, numbers: numbers}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, filename))
+	case "xlsx":
+		numberFormat := xlsxDecimal
+		if numbers == NumbersGerman {
+			numberFormat = xlsxGroupedDecimal
+		}
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.xlsx"`, filename))
+		if rows, err = newXLSX(w, "Zählungen", numberFormat); err != nil {
+			respondWithError(w, err)
+			return
+		}
+	default:
+		respondWithError(w, &ValidationError{Field: "format", Reason: fmt.Sprintf("unknown format %q", format)})
+		return
+	}
+
+	// the response has started once the first row is written, so later errors can only be logged
+	err = rows.WriteRow(exportHeader(currency))
+	if err == nil {
+		err = s.store.EachCount(filter, func(record CountRecord) error {
+			switch {
+			case record.Currency != currency.Code:
+				return nil
+			case len(registers) > 0 && !registers[record.RegisterID]:
+				return nil
+			case kind != "" && record.Kind != kind && !(kind == CountInterim && record.Kind == ""):
+				return nil
+			}
+			return rows.WriteRow(exportRow(currency, record))
+		})
+	}
+	if err == nil {
+		err = rows.Close()
+	}
+	if err != nil {
+		log.Println("error writing count export:", err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// exportRecords posts three counts: a closing count of R1, an interim count of R2 and a closing
+// count of R3.
+func exportRecords(t *testing.T, handler http.Handler) {
+	t.Helper()
+	for _, body := range []string{
+		`{"registerId":"R1","countKind":"closing","targetValue":"140,00","counts":[{"denomination":"euro50","form":"loose","quantity":2},{"denomination":"euro2","form":"roll","quantity":1}]}`,
+		`{"registerId":"R2","targetValue":"1.200,00","counts":[{"denomination":"euro200","form":"loose","quantity":6}]}`,
+		`{"registerId":"R3","countKind":"closing","targetValue":"10,00","counts":[{"denomination":"euro10","form":"loose","quantity":1}]}`,
+	} {
+		rec := serveJSON(handler, http.MethodPost, "/api/v2/calculate", body)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+}
+
+// readExport parses a CSV export and returns its rows as maps from the column names to the cells.
+func readExport(t *testing.T, body string, comma rune) []map[string]string {
+	t.Helper()
+	reader := csv.NewReader(strings.NewReader(body))
+	reader.Comma = comma
+	records, err := reader.ReadAll()
+	assert.NoError(t, err)
+	var rows []map[string]string
+	for _, record := range records[1:] {
+		row := make(map[string]string)
+		for i, name := range records[0] {
+			row[name] = record[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestExportCountsCSV(t *testing.T) {
+	handler := newTestServer(t).routes()
+	exportRecords(t, handler)
+
+	rec := serveJSON(handler, http.MethodGet, "/api/v1/exports/counts?registerId=R1,R2", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Header().Get("Content-Disposition"), ".csv")
+	header, _, _ := strings.Cut(rec.Body.String(), "\n")
+	assert.True(t, strings.HasPrefix(header, "id;createdAt;registerId;storeId;cashier;kind;status;currency;euro200;euro100;euro50;"))
+	assert.True(t, strings.HasSuffix(header, ";cent1;looseValue;rollValue;boxValue;totalValue;targetValue;differenceValue"))
+
+	rows := readExport(t, rec.Body.String(), ';')
+	assert.Len(t, rows, 2)
+	assert.Equal(t, "R1", rows[0]["registerId"])
+	assert.Equal(t, "closing", rows[0]["kind"])
+	assert.Equal(t, "100,00", rows[0]["euro50"])
+	assert.Equal(t, "50,00", rows[0]["euro2"])
+	assert.Equal(t, "0,00", rows[0]["euro200"])
+	assert.Equal(t, "100,00", rows[0]["looseValue"])
+	assert.Equal(t, "50,00", rows[0]["rollValue"])
+	assert.Equal(t, "10,00", rows[0]["differenceValue"])
+	assert.Equal(t, "interim", rows[1]["kind"])
+	assert.Equal(t, "1.200,00", rows[1]["totalValue"])
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/exports/counts?countKind=closing&numbers=plain", "")
+	rows = readExport(t, rec.Body.String(), ',')
+	assert.Equal(t, []string{"R1", "R3"}, []string{rows[0]["registerId"], rows[1]["registerId"]})
+	assert.Equal(t, "100.00", rows[0]["euro50"])
+	assert.Equal(t, "140.00", rows[0]["targetValue"])
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/exports/counts?registerId=R9&from=2020-01-01", "")
+	assert.Len(t, readExport(t, rec.Body.String(), ';'), 0)
+}
+
+func TestExportCountsXLSX(t *testing.T) {
+	handler := newTestServer(t).routes()
+	exportRecords(t, handler)
+
+	rec := serveJSON(handler, http.MethodGet, "/api/v1/exports/counts?format=xlsx&registerId=R2", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", rec.Header().Get("Content-Type"))
+	parts := readXLSX(t, rec.Body.Bytes())
+	assert.Contains(t, parts["xl/workbook.xml"], `name="Zählungen"`)
+	assert.Contains(t, parts["xl/styles.xml"], `numFmtId="4"`)
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	assert.Contains(t, sheet, `<c r="A1" t="inlineStr"><is><t>id</t></is></c>`)
+	assert.Contains(t, sheet, `<c r="C2" t="inlineStr"><is><t>R2</t></is></c>`)
+	assert.Contains(t, sheet, `<c r="I2" s="1"><v>1200.00</v></c>`)
+	assert.Equal(t, 2, strings.Count(sheet, "<row "))
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/exports/counts?format=xlsx&numbers=plain", "")
+	parts = readXLSX(t, rec.Body.Bytes())
+	assert.Contains(t, parts["xl/styles.xml"], `numFmtId="2"`)
+	assert.Equal(t, 4, strings.Count(parts["xl/worksheets/sheet1.xml"], "<row "))
+}
+
+func TestExportCountsErrors(t *testing.T) {
+	handler := newTestServer(t).routes()
+	for query, field := range map[string]string{
+		"format=pdf":      "format",
+		"numbers=swiss":   "numbers",
+		"countKind=final": "countKind",
+		"currency=XXX":    "currency",
+		"from=yesterday":  "from",
+	} {
+		rec := serveJSON(handler, http.MethodGet, "/api/v1/exports/counts?"+query, "")
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, query)
+		assert.Contains(t, rec.Body.String(), `"field":"`+field+`"`, query)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"net/http"
+	"slices"
+)
+
+// maxFloatValue caps the target float. The planner's table grows with the target in cents,
+// and a drawer float is far below this.
+const maxFloatValue Money = 200000
+
+// maxFloatUnits caps the pieces, rolls or boxes of one denomination the float can hold. It
+// bounds the number of items the planner has to consider.
+const maxFloatUnits = 100
+
+// ErrFloatNotReachable is returned when the available cash cannot make up the target float exactly.
+var ErrFloatNotReachable = errors.New("the available cash cannot make up the target float exactly")
+
+// FloatPlanRequest takes the counted cash in the v1 structures, the float to leave in the drawer
+// and the preferred number of loose pieces per denomination in the float.
+type FloatPlanRequest struct {
+	Currency      string         `json:"currency,omitempty"`
+	RequestValues RequestValues  `json:"requestValues"`
+	RollValues    RollValues     `json:"rollValues"`
+	BoxValues     BoxValues      `json:"boxValues"`
+	TargetFloat   string         `json:"targetFloat"`
+	PreferredMix  map[string]int `json:"preferredMix"`
+}
+
+// FloatPlanPayload lists what stays in the drawer and what goes into the bank bag.
+type FloatPlanPayload struct {
+	FloatValue   string  `json:"floatValue"`
+	DepositValue string  `json:"depositValue"`
+	Keep         []Count `json:"keep"`
+	Deposit      []Count `json:"deposit"`
+}
+
+// planItem is a group of identical units the planner can take at once, such as four loose
+// 2 euro coins or two rolls of 10 cent. Groups are sized in powers of two, so any quantity
+// up to the available one can be taken as a subset of groups.
+type planItem struct {
+	key   countKey
+	units int
+	value Money
+	cost  int64
+}
+
+// countKey identifies a denomination in one form.
+type countKey struct {
+	denomination string
+	form         CountForm
+}
+
+// preferredCost and otherCost weigh the units in the plan. Preferred loose pieces lower the
+// cost, every other unit raises it by far more, so the plan first fulfils as much of the
+// preferred mix as possible and then uses as few other units as possible.
+const (
+	preferredCost int64 = -1
+	otherCost     int64 = 1 << 20
+)
+
+// PlanFloat splits the available cash into the float to keep and the rest to deposit. It solves
+// a bounded change-making problem: the kept units must add up to target exactly and may not
+// exceed the available quantities or maxFloatUnits. Rolls and boxes are kept or deposited whole.
+// Available counts must have been validated against the currency and must not be negative.
+func PlanFloat(currency *Currency, available []Count, target Money, preferred map[string]int) ([]Count, []Count, error) {
+	quantities := make(map[countKey]int)
+	for _, c := range available {
+		quantities[countKey{c.Denomination, c.Form}] += c.Quantity
+	}
+
+	var items []planItem
+	for _, d := range currency.Denominations {
+		for _, form := range []CountForm{FormLoose, FormRoll, FormBox} {
+			key := countKey{d.Code, form}
+			value := CountValue(&d, form, 1)
+			if value <= 0 {
+				continue
+			}
+			quantity := min(quantities[key], int(target/value), maxFloatUnits)
+			if quantity <= 0 {
+				continue
+			}
+			preferredQuantity := 0
+			if form == FormLoose {
+				preferredQuantity = max(min(preferred[d.Code], quantity), 0)
+			}
+			items = appendPlanItems(items, key, value, preferredQuantity, preferredCost)
+			items = appendPlanItems(items, key, value, quantity-preferredQuantity, otherCost)
+		}
+	}
+
+	taken, ok := solveFloat(items, target)
+	if !ok {
+		return nil, nil, ErrFloatNotReachable
+	}
+
+	keep := make(map[countKey]int)
+	for i, item := range items {
+		if taken[i] {
+			keep[item.key] += item.units
+		}
+	}
+
+	var kept, deposited []Count
+	for _, d := range currency.Denominations {
+		for _, form := range []CountForm{FormLoose, FormRoll, FormBox} {
+			key := countKey{d.Code, form}
+			if quantity := keep[key]; quantity > 0 {
+				kept = append(kept, Count{Denomination: d.Code, Form: form, Quantity: quantity})
+			}
+			if quantity := quantities[key] - keep[key]; quantity > 0 {
+				deposited = append(deposited, Count{Denomination: d.Code, Form: form, Quantity: quantity})
+			}
+		}
+	}
+	return kept, deposited, nil
+}
+
+// appendPlanItems splits quantity units into groups of 1, 2, 4, ... units and a remainder.
+func appendPlanItems(items []planItem, key countKey, value Money, quantity int, cost int64) []planItem {
+	for units := 1; quantity > 0; units *= 2 {
+		units = min(units, quantity)
+		items = append(items, planItem{key: key, units: units, value: value * Money(units), cost: cost * int64(units)})
+		quantity -= units
+	}
+	return items
+}
+
+// solveFloat picks the subset of items whose values add up to target at the lowest cost
+// (a 0/1 knapsack over the amount in cents). It reports false if no subset adds up to target.
+func solveFloat(items []planItem, target Money) ([]bool, bool) {
+	const unreachable = int64(1) << 62
+	amount := int(target)
+
+	// Items are taken smallest first. The trace back from the target then only visits amounts
+	// below the sum of the items so far that differ from the target by a multiple of the
+	// greatest common divisor of the items still to come, so only those amounts are marked.
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int { return cmp.Compare(items[a].value, items[b].value) })
+	divisors := make([]int, len(order))
+	for k, divisor := len(order)-1, 0; k >= 0; k-- {
+		divisor = gcd(divisor, int(items[order[k]].value))
+		divisors[k] = divisor
+	}
+
+	cost := make([]int64, amount+1)
+	for a := 1; a <= amount; a++ {
+		cost[a] = unreachable
+	}
+	// improved[k] marks the amounts whose best cost improved by taking item order[k]
+	improved := make([][]uint64, len(order))
+	reach := 0
+	for k, i := range order {
+		value, divisor := int(items[i].value), divisors[k]
+		reach = min(reach+value, amount)
+		improved[k] = make([]uint64, reach/divisor/64+1)
+		for a := reach; a >= value; a-- {
+			if cost[a-value] == unreachable {
+				continue
+			}
+			if candidate := cost[a-value] + items[i].cost; candidate < cost[a] {
+				cost[a] = candidate
+				if (amount-a)%divisor == 0 {
+					bit := a / divisor
+					improved[k][bit/64] |= 1 << (bit % 64)
+				}
+			}
+		}
+	}
+	if cost[amount] == unreachable {
+		return nil, false
+	}
+
+	taken := make([]bool, len(items))
+	for k, a := len(order)-1, amount; k >= 0 && a > 0; k-- {
+		bit := a / divisors[k]
+		if bit/64 < len(improved[k]) && improved[k][bit/64]&(1<<(bit%64)) != 0 {
+			taken[order[k]] = true
+			a -= int(items[order[k]].value)
+		}
+	}
+	return taken, true
+}
+
+// gcd returns the greatest common divisor of a and b. gcd(0, b) is b.
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// handleFloatPlan responds with the float to keep and the cash to deposit for the counted cash.
+func (s *Server) handleFloatPlan(w http.ResponseWriter, r *http.Request) {
+	var request FloatPlanRequest
+	if err := decodeStrict(r, &request); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	payload := RequestPayload{
+		Currency:      request.Currency,
+		RequestValues: request.RequestValues,
+		RollValues:    request.RollValues,
+		BoxValues:     request.BoxValues,
+	}
+	currency, ok := s.config.Catalog.Currency(request.Currency)
+	if !ok {
+		respondWithError(w, &ValidationError{Field: "currency", Reason: "unknown currency " + request.Currency})
+		return
+	}
+	if err := validateCounts(currency, payload); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	available := payload.Counts()
+	for _, code := range sortedKeys(request.PreferredMix) {
+		if _, ok := currency.Denomination(code); !ok {
+			respondWithError(w, &ValidationError{Field: "preferredMix." + code, Reason: "unknown denomination for " + currency.Code})
+			return
+		}
+		if request.PreferredMix[code] < 0 {
+			respondWithError(w, &ValidationError{Field: "preferredMix." + code, Reason: "must not be negative"})
+			return
+		}
+	}
+	target, err := ParseMoney(request.TargetFloat)
+	if err == nil && (target < 0 || target > maxFloatValue) {
+		err = errors.New("must be between 0,00 and " + FormatNumber(maxFloatValue))
+	}
+	if err != nil {
+		respondWithError(w, &ValidationError{Field: "targetFloat", Reason: err.Error()})
+		return
+	}
+
+	keep, deposit, err := PlanFloat(currency, available, target, request.PreferredMix)
+	if err != nil {
+		respondWithError(w, &ValidationError{Field: "targetFloat", Reason: err.Error()})
+		return
+	}
+	loose, rolls, boxes := SumCounts(currency, deposit)
+	respondWithJSON(w, FloatPlanPayload{
+		FloatValue:   FormatNumber(target),
+		DepositValue: FormatNumber(loose + rolls + boxes),
+		Keep:         emptyIfNil(keep),
+		Deposit:      emptyIfNil(deposit),
+	})
+}
+
+// emptyIfNil returns an empty slice for nil, so it is encoded as [] instead of null.
+func emptyIfNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
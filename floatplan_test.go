@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanFloatPrefersMix(t *testing.T) {
+	currency := euro()
+	available := []Count{
+		{Denomination: "euro50", Form: FormLoose, Quantity: 4},
+		{Denomination: "euro10", Form: FormLoose, Quantity: 10},
+		{Denomination: "euro5", Form: FormLoose, Quantity: 10},
+		{Denomination: "euro2", Form: FormRoll, Quantity: 1},
+	}
+
+	keep, deposit, err := PlanFloat(currency, available, 15000, map[string]int{"euro10": 5, "euro5": 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []Count{
+		{Denomination: "euro50", Form: FormLoose, Quantity: 1},
+		{Denomination: "euro10", Form: FormLoose, Quantity: 5},
+		{Denomination: "euro5", Form: FormLoose, Quantity: 10},
+	}, keep)
+	assert.Equal(t, []Count{
+		{Denomination: "euro50", Form: FormLoose, Quantity: 3},
+		{Denomination: "euro10", Form: FormLoose, Quantity: 5},
+		{Denomination: "euro2", Form: FormRoll, Quantity: 1},
+	}, deposit)
+}
+
+func TestPlanFloatFewestPieces(t *testing.T) {
+	currency := euro()
+	available := []Count{
+		{Denomination: "euro20", Form: FormLoose, Quantity: 3},
+		{Denomination: "euro2", Form: FormLoose, Quantity: 30},
+		{Denomination: "euro2", Form: FormRoll, Quantity: 2},
+	}
+
+	// a 50 euro roll beats 25 loose coins
+	keep, _, err := PlanFloat(currency, available, 11000, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []Count{
+		{Denomination: "euro20", Form: FormLoose, Quantity: 3},
+		{Denomination: "euro2", Form: FormRoll, Quantity: 1},
+	}, keep)
+}
+
+func TestPlanFloatNotReachable(t *testing.T) {
+	currency := euro()
+	available := []Count{{Denomination: "euro50", Form: FormLoose, Quantity: 10}}
+
+	_, _, err := PlanFloat(currency, available, 12000, nil)
+	assert.ErrorIs(t, err, ErrFloatNotReachable)
+
+	// available quantities are respected
+	_, _, err = PlanFloat(currency, available, 100000, nil)
+	assert.ErrorIs(t, err, ErrFloatNotReachable)
+
+	keep, deposit, err := PlanFloat(currency, available, 0, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, keep)
+	assert.Equal(t, available, deposit)
+}
+
+func TestPlanFloatLargeFloat(t *testing.T) {
+	currency := euro()
+	var available []Count
+	for _, d := range currency.Denominations {
+		for _, form := range []CountForm{FormLoose, FormRoll, FormBox} {
+			if CountValue(&d, form, 1) > 0 {
+				available = append(available, Count{Denomination: d.Code, Form: form, Quantity: 100000})
+			}
+		}
+	}
+
+	keep, _, err := PlanFloat(currency, available, maxFloatValue-1, nil)
+	assert.NoError(t, err)
+	loose, rolls, boxes := SumCounts(currency, keep)
+	assert.Equal(t, maxFloatValue-1, loose+rolls+boxes)
+	for _, c := range keep {
+		assert.LessOrEqual(t, c.Quantity, maxFloatUnits)
+	}
+
+	// at most maxFloatUnits of one denomination and form are kept
+	_, _, err = PlanFloat(currency, []Count{{Denomination: "euro1", Form: FormLoose, Quantity: 500}}, 20000, nil)
+	assert.ErrorIs(t, err, ErrFloatNotReachable)
+}
+
+func TestHandleFloatPlan(t *testing.T) {
+	handler := newTestServer(t).routes()
+
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/float-plan", `{
+		"requestValues": {"euro100": [2,0,0,0,0], "euro10": [5,0,0,0,0], "cent50": [0,0,0,0,8]},
+		"rollValues": {"euro1": [1,0]},
+		"targetFloat": "179,00",
+		"preferredMix": {"euro10": 5, "cent50": 8}
+	}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var plan FloatPlanPayload
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&plan))
+	assert.Equal(t, "179,00", plan.FloatValue)
+	assert.Equal(t, "100,00", plan.DepositValue)
+	assert.Equal(t, []Count{
+		{Denomination: "euro100", Form: FormLoose, Quantity: 1},
+		{Denomination: "euro10", Form: FormLoose, Quantity: 5},
+		{Denomination: "euro1", Form: FormRoll, Quantity: 1},
+		{Denomination: "cent50", Form: FormLoose, Quantity: 8},
+	}, plan.Keep)
+	assert.Equal(t, []Count{{Denomination: "euro100", Form: FormLoose, Quantity: 1}}, plan.Deposit)
+}
+
+func TestHandleFloatPlanErrors(t *testing.T) {
+	handler := newTestServer(t).routes()
+
+	tests := []struct {
+		name  string
+		body  string
+		field string
+	}{
+		{"not reachable", `{"requestValues": {"euro50": [1,0,0,0,0]}, "targetFloat": "20,00"}`, "targetFloat"},
+		{"too large", `{"requestValues": {}, "targetFloat": "2.000,01"}`, "targetFloat"},
+		{"negative count", `{"requestValues": {"euro50": [-1,0,0,0,0]}, "targetFloat": "0"}`, "requestValues.euro50"},
+		{"unknown mix", `{"requestValues": {}, "targetFloat": "0", "preferredMix": {"euro3": 1}}`, "preferredMix.euro3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveJSON(handler, http.MethodPost, "/api/v1/float-plan", tt.body)
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+			var payload ErrorPayload
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(&payload))
+			assert.Equal(t, tt.field, payload.Error.Field)
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// saveCount binds a count submitted to one of the calculate endpoints or to a session to the
+// caller and to the store of its register, classifies its difference, puts it into the approval
+// workflow, stores it and appends it to the audit journal. The journal entry is written last in
+// the transaction that stores the count, after the functions in then, so a count that cannot be
+// journalled is not stored.
+func (s *Server) saveCount(ctx context.Context, record *CountRecord, then ...func(*bolt.Tx) error) error {
+	if err := s.prepareCount(ctx, record); err != nil {
+		return err
+	}
+	save := s.store.SaveCount
+	if record.SessionID != 0 {
+		save = s.store.SaveSessionCount
+	}
+	return save(record, append(then, s.journalCount(record))...)
+}
+
+// prepareCount does everything saveCount does before the count is stored.
+func (s *Server) prepareCount(ctx context.Context, record *CountRecord) error {
+	if err := bindIdentity(ctx, record); err != nil {
+		return err
+	}
+	storeID, err := s.store.RegisterStore(record.RegisterID, s.config.Tenancy)
+	if err != nil {
+		return err
+	}
+	record.StoreID = storeID
+	classification := s.toleranceRules().Classify(record.RegisterID, record.DifferenceValue, record.TargetValue)
+	record.Classification = &classification
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now().UTC()
+	}
+	record.Status = s.config.Approval.initialStatus(classification)
+	record.History = []StatusChange{{Status: record.Status, By: record.Cashier, At: record.CreatedAt}}
+	return nil
+}
+
+// journalCount returns the function that appends record to the audit journal within the
+// transaction that stores it.
+func (s *Server) journalCount(record *CountRecord) func(*bolt.Tx) error {
+	return func(*bolt.Tx) error {
+		_, err := s.journal.Append(record)
+		return err
+	}
+}
+
+// handleListCounts returns the stored counts, newest first. The query parameters registerId,
+// storeId, status, from, to and limit narrow the list; from and to take a date or an RFC 3339
+// timestamp. Callers bound to a register only see the counts of that register.
+func (s *Server) handleListCounts(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseCountFilter(r)
+	if err == nil {
+		filter.RegisterID, err = boundRegister(r.Context(), filter.RegisterID)
+	}
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	records, err := s.store.ListCounts(filter)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, records)
+}
+
+// handleGetCount returns the stored count with the ID given in the path. Callers bound to a
+// register only get the counts of that register.
+func (s *Server) handleGetCount(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r, "id")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	record, err := s.store.Count(id)
+	if err == nil {
+		err = checkRegister(r.Context(), record.RegisterID)
+	}
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, record)
+}
+
+// parseCountFilter reads a CountFilter from the query parameters of r.
+func parseCountFilter(r *http.Request) (CountFilter, error) {
+	query := r.URL.Query()
+	filter := CountFilter{RegisterID: query.Get("registerId"), StoreID: query.Get("storeId"), Status: ApprovalStatus(query.Get("status"))}
+
+	var err error
+	if filter.From, err = parseTimeParam(query.Get("from"), "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parseTimeParam(query.Get("to"), "to"); err != nil {
+		return filter, err
+	}
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit < 0 {
+			return filter, &ValidationError{Field: "limit", Reason: "must be a non-negative number"}
+		}
+	}
+	return filter, nil
+}
+
+// parseTimeParam parses a date ("2006-01-02", local midnight, where the business day starts) or
+// an RFC 3339 timestamp. An empty value returns the zero time.
+func parseTimeParam(value, field string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, &ValidationError{Field: field, Reason: "must be a date (YYYY-MM-DD) or an RFC 3339 timestamp"}
+	}
+	return t, nil
+}
+
+// startOfDay returns the local midnight that starts the business day of t.
+func startOfDay(t time.Time) time.Time {
+	t = t.In(time.Local)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// parseID reads the numeric path parameter name of r.
+func parseID(r *http.Request, name string) (uint64, error) {
+	id, err := strconv.ParseUint(r.PathValue(name), 10, 64)
+	if err != nil {
+		return 0, &ValidationError{Field: name, Reason: "must be a positive number"}
+	}
+	return id, nil
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// genesisHash is the previous hash of the first journal entry.
+var genesisHash = strings.Repeat("0", sha256.Size*2)
+
+// JournalEntry is one line of the audit journal. Hash is the SHA-256 of the sequence number,
+// the time, the hash of the previous entry and the payload, so changing, removing or
+// reordering any entry breaks the chain from that entry on.
+type JournalEntry struct {
+	Sequence     uint64          `json:"sequence"`
+	Time         time.Time       `json:"time"`
+	PreviousHash string          `json:"previousHash"`
+	Payload      json.RawMessage `json:"payload"`
+	Hash         string          `json:"hash"`
+}
+
+// ChainError reports the first journal entry that breaks the hash chain.
+type ChainError struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("journal line %d: %s", e.Line, e.Reason)
+}
+
+// JournalHead is the sequence number and hash of the last entry. It is kept in a head file next
+// to the journal, so entries cut off the end of the journal break the chain as well.
+type JournalHead struct {
+	Sequence uint64 `json:"sequence"`
+	Hash     string `json:"hash"`
+}
+
+// check returns a ChainError if the journal ending with last does not reach the head. A journal
+// past the head is accepted: the server stopped between syncing an entry and writing the head.
+func (head JournalHead) check(last *JournalEntry) error {
+	var sequence uint64
+	if last != nil {
+		sequence = last.Sequence
+	}
+	switch {
+	case sequence < head.Sequence:
+		return &ChainError{Line: int(sequence) + 1, Reason: "journal ends at sequence " + strconv.FormatUint(sequence, 10) +
+			", head is at " + strconv.FormatUint(head.Sequence, 10) + " (entries removed from the end)"}
+	case sequence == head.Sequence && sequence > 0 && last.Hash != head.Hash:
+		return &ChainError{Line: int(sequence), Reason: "hash does not match the journal head (entry replaced)"}
+	}
+	return nil
+}
+
+// headPath returns the path of the head file of the journal at path.
+func headPath(path string) string {
+	return path + ".head"
+}
+
+// readJournalHead reads the head file of the journal at path. A missing head file is read as
+// the head of an empty journal.
+func readJournalHead(path string) (JournalHead, error) {
+	var head JournalHead
+	data, err := os.ReadFile(headPath(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return head, nil
+	}
+	if err != nil {
+		return head, fmt.Errorf("error reading journal head: %w", err)
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return head, fmt.Errorf("error decoding journal head: %w", err)
+	}
+	return head, nil
+}
+
+// writeJournalHead replaces the head file of the journal at path. The head is written to a
+// temporary file first, so a failed write leaves the previous head in place.
+func writeJournalHead(path string, head JournalHead) error {
+	data, err := json.Marshal(head)
+	if err != nil {
+		return fmt.Errorf("error encoding journal head: %w", err)
+	}
+	tmp := headPath(path) + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("error writing journal head: %w", err)
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, headPath(path))
+	}
+	if err != nil {
+		return fmt.Errorf("error writing journal head: %w", err)
+	}
+	return nil
+}
+
+// Journal is an append-only file of hash-chained JSON lines. It is safe for concurrent use.
+type Journal struct {
+	mu       sync.Mutex
+	file     *os.File
+	path     string
+	size     int64
+	sequence uint64
+	lastHash string
+}
+
+// computeHash returns the hash the entry must carry.
+func (e *JournalEntry) computeHash() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n%s\n%s\n", e.Sequence, e.Time.Format(time.RFC3339Nano), e.PreviousHash)
+	h.Write(e.Payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// OpenJournal opens the journal file at path, creating it if needed. The existing entries are
+// verified against each other and the head file first; a broken chain is returned as ChainError
+// and the journal is not opened.
+func OpenJournal(path string) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal %s: %w", path, err)
+	}
+
+	last, err := verifyJournalFile(file, path)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error verifying journal %s: %w", path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error opening journal %s: %w", path, err)
+	}
+
+	journal := &Journal{file: file, path: path, size: info.Size(), lastHash: genesisHash}
+	if last != nil {
+		journal.sequence = last.Sequence
+		journal.lastHash = last.Hash
+	}
+	return journal, nil
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
+
+// Append adds payload as the next entry and syncs the file and the head before returning. If
+// any of that fails, the journal is cut back to the entry before, so no torn line remains.
+func (j *Journal) Append(payload any) (JournalEntry, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return JournalEntry{}, fmt.Errorf("error encoding journal payload: %w", err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entry := JournalEntry{
+		Sequence:     j.sequence + 1,
+		Time:         time.Now().UTC(),
+		PreviousHash: j.lastHash,
+		Payload:      data,
+	}
+	entry.Hash = entry.computeHash()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return JournalEntry{}, fmt.Errorf("error encoding journal entry: %w", err)
+	}
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return JournalEntry{}, j.truncate(fmt.Errorf("error writing journal: %w", err))
+	}
+	if err := j.file.Sync(); err != nil {
+		return JournalEntry{}, j.truncate(fmt.Errorf("error syncing journal: %w", err))
+	}
+	if err := writeJournalHead(j.path, JournalHead{Sequence: entry.Sequence, Hash: entry.Hash}); err != nil {
+		return JournalEntry{}, j.truncate(err)
+	}
+
+	j.size += int64(len(line)) + 1
+	j.sequence = entry.Sequence
+	j.lastHash = entry.Hash
+	return entry, nil
+}
+
+// truncate cuts the file back to the last complete entry after a failed append and returns
+// err, joined with the error of the truncation if that fails too.
+func (j *Journal) truncate(err error) error {
+	if truncErr := j.file.Truncate(j.size); truncErr != nil {
+		return errors.Join(err, fmt.Errorf("error truncating journal: %w", truncErr))
+	}
+	return err
+}
+
+// VerifyJournal reads journal lines from r and checks the hash chain. It returns the last entry,
+// nil for an empty journal, or a ChainError naming the first line that was edited, removed,
+// inserted or moved.
+func VerifyJournal(r io.Reader) (*JournalEntry, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var last *JournalEntry
+	previousHash := genesisHash
+	for line := 1; scanner.Scan(); line++ {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return last, &ChainError{Line: line, Reason: "entry is not valid JSON"}
+		}
+		switch {
+		case entry.Sequence != uint64(line):
+			return last, &ChainError{Line: line, Reason: "expected sequence " + strconv.Itoa(line) +
+				", found " + strconv.FormatUint(entry.Sequence, 10) + " (entry removed, inserted or moved)"}
+		case entry.PreviousHash != previousHash:
+			return last, &ChainError{Line: line, Reason: "previous hash does not match the entry before (entry removed, inserted or moved)"}
+		case entry.Hash != entry.computeHash():
+			return last, &ChainError{Line: line, Reason: "hash does not match the content (entry edited)"}
+		}
+		previousHash = entry.Hash
+		last = &entry
+	}
+	if err := scanner.Err(); err != nil {
+		return last, fmt.Errorf("error reading journal: %w", err)
+	}
+	return last, nil
+}
+
+// verifyJournalFile verifies the journal read from r against the head file of the journal at path.
+func verifyJournalFile(r io.Reader, path string) (*JournalEntry, error) {
+	last, err := VerifyJournal(r)
+	if err != nil {
+		return last, err
+	}
+	head, err := readJournalHead(path)
+	if err != nil {
+		return last, err
+	}
+	return last, head.check(last)
+}
+
+// verifyJournalCommand implements "register-api verify-journal [path]". It prints the result and
+// returns the process exit code.
+func verifyJournalCommand(args []string, stdout io.Writer) int {
+	path := "journal.jsonl"
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(stdout, err)
+		return 2
+	}
+	defer file.Close()
+
+	last, err := verifyJournalFile(file, path)
+	var chainErr *ChainError
+	switch {
+	case errors.As(err, &chainErr):
+		fmt.Fprintf(stdout, "%s: chain broken: %v\n", path, chainErr)
+		return 1
+	case err != nil:
+		fmt.Fprintln(stdout, err)
+		return 2
+	case last == nil:
+		fmt.Fprintf(stdout, "%s: journal is empty\n", path)
+	default:
+		fmt.Fprintf(stdout, "%s: %d entries, chain intact, last hash %s\n", path, last.Sequence, last.Hash)
+	}
+	return 0
+}
+
+// maxJournalPage caps the number of entries returned by one request to the journal endpoint.
+const maxJournalPage = 1000
+
+// JournalVerification is the result of verifying the journal through the API.
+type JournalVerification struct {
+	Intact   bool        `json:"intact"`
+	Entries  uint64      `json:"entries"`
+	LastHash string      `json:"lastHash"`
+	Error    *ChainError `json:"error,omitempty"`
+}
+
+// Entries returns up to limit entries following the entry with sequence number after.
+func (j *Journal) Entries(after uint64, limit int) ([]JournalEntry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	file, err := os.Open(j.file.Name())
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal: %w", err)
+	}
+	defer file.Close()
+
+	entries := []JournalEntry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := uint64(1); scanner.Scan() && len(entries) < limit; line++ {
+		if line <= after {
+			continue
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, &ChainError{Line: int(line), Reason: "entry is not valid JSON"}
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading journal: %w", err)
+	}
+	return entries, nil
+}
+
+// Verify checks the hash chain of the journal file as it is on disk now, and that the file still
+// reaches the last entry appended.
+func (j *Journal) Verify() (*JournalEntry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	file, err := os.Open(j.file.Name())
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal: %w", err)
+	}
+	defer file.Close()
+	last, err := VerifyJournal(file)
+	if err != nil {
+		return last, err
+	}
+	return last, JournalHead{Sequence: j.sequence, Hash: j.lastHash}.check(last)
+}
+
+// handleListJournal returns the journal entries after the sequence number in the "after" query
+// parameter, at most "limit" of them (100 by default).
+func (s *Server) handleListJournal(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var after uint64
+	if value := query.Get("after"); value != "" {
+		var err error
+		if after, err = strconv.ParseUint(value, 10, 64); err != nil {
+			respondWithError(w, &ValidationError{Field: "after", Reason: "must be a non-negative number"})
+			return
+		}
+	}
+	limit := 100
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > maxJournalPage {
+			respondWithError(w, &ValidationError{Field: "limit", Reason: fmt.Sprintf("must be between 1 and %d", maxJournalPage)})
+			return
+		}
+	}
+
+	entries, err := s.journal.Entries(after, limit)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, entries)
+}
+
+// handleVerifyJournal verifies the journal and reports the first broken entry, if any.
+func (s *Server) handleVerifyJournal(w http.ResponseWriter, r *http.Request) {
+	last, err := s.journal.Verify()
+	result := JournalVerification{Intact: err == nil, LastHash: genesisHash}
+	if last != nil {
+		result.Entries, result.LastHash = last.Sequence, last.Hash
+	}
+	var chainErr *ChainError
+	switch {
+	case errors.As(err, &chainErr):
+		result.Error = chainErr
+	case err != nil:
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, result)
+}
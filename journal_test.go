@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestJournal opens a journal in a temporary directory that is closed when the test ends.
+func newTestJournal(t *testing.T) *Journal {
+	t.Helper()
+	journal, err := OpenJournal(filepath.Join(t.TempDir(), "journal.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { journal.Close() })
+	return journal
+}
+
+// writeTestJournal appends three entries to a new journal file and returns its lines.
+func writeTestJournal(t *testing.T, path string) []string {
+	t.Helper()
+	journal, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []uint64{1, 2, 3} {
+		if _, err := journal.Append(CountRecord{ID: id, TotalValue: Money(id * 100)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	journal.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestJournalAppendAndReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	lines := writeTestJournal(t, path)
+	assert.Len(t, lines, 3)
+
+	journal, err := OpenJournal(path)
+	assert.NoError(t, err)
+	entry, err := journal.Append(CountRecord{ID: 4})
+	assert.NoError(t, err)
+	journal.Close()
+	assert.Equal(t, uint64(4), entry.Sequence)
+
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	last, err := VerifyJournal(file)
+	assert.NoError(t, err)
+	assert.Equal(t, entry.Hash, last.Hash)
+}
+
+func TestVerifyJournalDetectsTampering(t *testing.T) {
+	lines := writeTestJournal(t, filepath.Join(t.TempDir(), "journal.jsonl"))
+
+	tests := []struct {
+		name     string
+		lines    []string
+		wantLine int
+	}{
+		{"edited", []string{lines[0], strings.Replace(lines[1], `"totalValue":200`, `"totalValue":250`, 1), lines[2]}, 2},
+		{"removed", []string{lines[0], lines[2]}, 2},
+		{"reordered", []string{lines[0], lines[2], lines[1]}, 2},
+		{"first removed", []string{lines[1], lines[2]}, 1},
+		{"garbage", []string{lines[0], "not json\n", lines[2]}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := VerifyJournal(strings.NewReader(strings.Join(tt.lines, "")))
+			var chainErr *ChainError
+			if !errors.As(err, &chainErr) {
+				t.Fatalf("VerifyJournal() error = %v, want a ChainError", err)
+			}
+			assert.Equal(t, tt.wantLine, chainErr.Line)
+		})
+	}
+}
+
+func TestOpenJournalRefusesBrokenChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	lines := writeTestJournal(t, path)
+	assert.NoError(t, os.WriteFile(path, []byte(lines[0]+lines[2]), 0o600))
+
+	_, err := OpenJournal(path)
+	var chainErr *ChainError
+	assert.True(t, errors.As(err, &chainErr))
+}
+
+func TestOpenJournalRefusesTruncatedJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	lines := writeTestJournal(t, path)
+	assert.NoError(t, os.WriteFile(path, []byte(lines[0]+lines[1]), 0o600))
+
+	_, err := OpenJournal(path)
+	var chainErr *ChainError
+	if assert.True(t, errors.As(err, &chainErr)) {
+		assert.Equal(t, 3, chainErr.Line)
+	}
+
+	var out bytes.Buffer
+	assert.Equal(t, 1, verifyJournalCommand([]string{path}, &out))
+	assert.Contains(t, out.String(), "entries removed from the end")
+}
+
+func TestJournalTruncatesTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	journal, err := OpenJournal(path)
+	assert.NoError(t, err)
+	_, err = journal.Append(CountRecord{ID: 1})
+	assert.NoError(t, err)
+
+	_, err = journal.file.Write([]byte(`{"sequence":2,"ti`))
+	assert.NoError(t, err)
+	writeErr := errors.New("no space left on device")
+	assert.Equal(t, writeErr, journal.truncate(writeErr))
+	journal.Close()
+
+	journal, err = OpenJournal(path)
+	if assert.NoError(t, err) {
+		defer journal.Close()
+		entry, err := journal.Append(CountRecord{ID: 2})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(2), entry.Sequence)
+	}
+}
+
+func TestJournalVerifyDetectsTruncation(t *testing.T) {
+	journal := newTestJournal(t)
+	first, err := journal.Append(CountRecord{ID: 1})
+	assert.NoError(t, err)
+	_, err = journal.Append(CountRecord{ID: 2})
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(journal.path)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(journal.path, data[:bytes.IndexByte(data, '\n')+1], 0o600))
+
+	last, err := journal.Verify()
+	var chainErr *ChainError
+	if assert.True(t, errors.As(err, &chainErr)) {
+		assert.Equal(t, 2, chainErr.Line)
+	}
+	assert.Equal(t, first.Hash, last.Hash)
+}
+
+func TestVerifyJournalCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	lines := writeTestJournal(t, path)
+
+	var out bytes.Buffer
+	assert.Equal(t, 0, verifyJournalCommand([]string{path}, &out))
+	assert.Contains(t, out.String(), "3 entries, chain intact")
+
+	assert.NoError(t, os.WriteFile(path, []byte(lines[1]+lines[0]+lines[2]), 0o600))
+	out.Reset()
+	assert.Equal(t, 1, verifyJournalCommand([]string{path}, &out))
+	assert.Contains(t, out.String(), "journal line 1")
+}
+
+func TestCalculateAppendsToJournal(t *testing.T) {
+	server := newTestServer(t)
+	body := `{"requestValidation":{"targetValue":"0"},"requestValues":{"euro5":[1,0,0,0,0]}}`
+	rec := httptest.NewRecorder()
+	server.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	data, err := os.ReadFile(server.journal.file.Name())
+	assert.NoError(t, err)
+	last, err := VerifyJournal(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), last.Sequence)
+	assert.Contains(t, string(last.Payload), `"totalValue":500`)
+}
+
+func TestCalculateRollsBackWithoutJournal(t *testing.T) {
+	server := newTestServer(t)
+	assert.NoError(t, server.journal.Close())
+
+	body := `{"registerId":"R1","countKind":"closing","targetValue":"0","counts":[]}`
+	rec := httptest.NewRecorder()
+	server.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v2/calculate", strings.NewReader(body)))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	records, err := server.store.ListCounts(CountFilter{})
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+	entries, err := server.store.ListCashBook(CashBookFilter{})
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -2,75 +2,36 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
-	"strconv"
+	"os"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 )
 
-const (
-	RollsPerBoxesFive  = 5
-	RollsPerBoxesThree = 3
-
-	CoinsPerRollEuro      = 25
-	CoinsPerRollBigCent   = 40
-	CoinsPerRollSmallCent = 50
-
-	Euro2Value  = 2.0
-	Euro1Value  = 1.0
-	Cent50Value = 0.5
-	Cent20Value = 0.2
-	Cent10Value = 0.1
-	Cent5Value  = 0.05
-	Cent2Value  = 0.02
-	Cent1Value  = 0.01
-)
+// BoxValues holds the number of full coin boxes per denomination code, RollValues the number of
+// full rolls and RequestValues the loose notes and coins counted in up to five columns.
+// The codes are the ones of the request's currency in the catalog.
+type BoxValues map[string][1]int
 
-type BoxValues struct {
-	Euro2  [1]int `json:"euro2"`
-	Euro1  [1]int `json:"euro1"`
-	Cent50 [1]int `json:"cent50"`
-	Cent20 [1]int `json:"cent20"`
-	Cent10 [1]int `json:"cent10"`
-	Cent5  [1]int `json:"cent5"`
-	Cent2  [1]int `json:"cent2"`
-	Cent1  [1]int `json:"cent1"`
-}
+type RollValues map[string][2]int
 
-type RollValues struct {
-	Euro2  [2]int `json:"euro2"`
-	Euro1  [2]int `json:"euro1"`
-	Cent50 [2]int `json:"cent50"`
-	Cent20 [2]int `json:"cent20"`
-	Cent10 [2]int `json:"cent10"`
-	Cent5  [2]int `json:"cent5"`
-	Cent2  [2]int `json:"cent2"`
-	Cent1  [2]int `json:"cent1"`
-}
-
-type RequestValues struct {
-	Euro200 [5]int `json:"euro200"`
-	Euro100 [5]int `json:"euro100"`
-	Euro50  [5]int `json:"euro50"`
-	Euro20  [5]int `json:"euro20"`
-	Euro10  [5]int `json:"euro10"`
-	Euro5   [5]int `json:"euro5"`
-	Euro2   [5]int `json:"euro2"`
-	Euro1   [5]int `json:"euro1"`
-	Cent50  [5]int `json:"cent50"`
-	Cent20  [5]int `json:"cent20"`
-	Cent10  [5]int `json:"cent10"`
-	Cent5   [5]int `json:"cent5"`
-	Cent2   [5]int `json:"cent2"`
-	Cent1   [5]int `json:"cent1"`
-}
+type RequestValues map[string][5]int
 
 type RequestValidation struct {
 	TargetValue string `json:"targetValue"`
 }
 
 type RequestPayload struct {
+	RegisterID        string            `json:"registerId,omitempty"`
+	Cashier           string            `json:"cashier,omitempty"`
+	CountKind         CountKind         `json:"countKind,omitempty"`
+	Currency          string            `json:"currency,omitempty"`
 	RequestValidation RequestValidation `json:"requestValidation"`
 	RequestValues     RequestValues     `json:"requestValues"`
 	BoxValues         BoxValues         `json:"boxValues"`
@@ -79,16 +40,41 @@ type RequestPayload struct {
 }
 
 type ResponseValues struct {
-	TotalValue      string `json:"totalValue"`
-	DifferenceValue string `json:"differenceValue"`
+	TotalValue      string          `json:"totalValue"`
+	DifferenceValue string          `json:"differenceValue"`
+	Breakdown       BreakdownValues `json:"breakdown"`
 }
 
 type ResponsePayload struct {
-	ResponseValues ResponseValues `json:"responseValues"`
-	PayloadType    int            `json:"payloadType"`
+	CountID        uint64          `json:"countId,omitempty"`
+	Attempt        int             `json:"attempt,omitempty"`
+	Classification *Classification `json:"classification,omitempty"`
+	Status         ApprovalStatus  `json:"status,omitempty"`
+	ResponseValues ResponseValues  `json:"responseValues"`
+	PayloadType    int             `json:"payloadType"`
+}
+
+type ErrorValues struct {
+	Field  string `json:"field,omitempty"`
+	Reason string `json:"reason"`
+}
+
+type ErrorPayload struct {
+	Error ErrorValues `json:"error"`
+}
+
+// ValidationError reports a request field whose value cannot be used for a calculation.
+// Handlers answer it with a 422 status and an ErrorPayload naming the field and the reason.
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
 }
 
-// FormatNumber takes a float64 value `value` and formats it as a string with two decimal places.
+// FormatNumber takes a Money value `value` and formats it as a string with two decimal places.
 // The function splits the string representation of `value` into its integer and decimal parts.
 // It then checks if the integer part is negative and temporarily removes the negative sign if present.
 // The function adds thousand separators to the integer part by iterating over the characters in reverse order
@@ -97,9 +83,9 @@ type ResponsePayload struct {
 // If the original value was negative, the negative sign is prepended back to the formatted string.
 // Finally, the function returns the formatted string by combining the integer part, decimal part, and
 // a comma separator between them.
-func FormatNumber(value float64) string {
+func FormatNumber(value Money) string {
 	// convert to a string with two decimal places
-	str := fmt.Sprintf("%.2f", value)
+	str := value.String()
 	parts := strings.Split(str, ".")
 	integerPart := parts[0]
 	decimalPart := parts[1]
@@ -161,9 +147,12 @@ func HandlePayload(w http.ResponseWriter, r *http.Request) (RequestPayload, erro
 // It checks if the request method is POST and returns an error if it's not.
 // It then calls the HandlePayload function to decode the request payload.
 // If there is an error decoding the payload, handlePOSTRequest returns early.
+// Without a target value, the target imported from the POS for the register is used.
 // It then calls the calculateTotalValue function to calculate the total value based on the payload.
+// If the payload fails validation, it responds with an error payload via respondWithError.
+// The count is saved in the store and its ID is added to the response.
 // Finally, it calls the respondWithJSON function to send the response payload as a JSON response.
-func handlePOSTRequest(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handlePOSTRequest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		fmt.Println("Only POST method is accepted")
 		http.Error(w, "Only POST method is accepted", http.StatusMethodNotAllowed)
@@ -173,14 +162,42 @@ func handlePOSTRequest(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
-	responsePayload := calculateTotalValue(payload)
+	if err := payload.CountKind.Validate(); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if strings.TrimSpace(payload.RequestValidation.TargetValue) == "" {
+		payload.RequestValidation.TargetValue, err = s.importedTarget(r.Context(), payload.RegisterID, payload.Currency)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+	}
+	responsePayload, err := calculateTotalValue(&s.config.Catalog, payload)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	// the payload passed validation, so the currency and target value are known to be valid
+	currency, _ := s.config.Catalog.Currency(payload.Currency)
+	targetValue, _ := ParseMoney(payload.RequestValidation.TargetValue)
+	record := NewCountRecord(currency, payload.Counts(), targetValue)
+	record.RegisterID, record.Cashier, record.Kind = payload.RegisterID, payload.Cashier, payload.CountKind
+	if err := s.saveCount(r.Context(), &record); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	responsePayload.CountID = record.ID
+	responsePayload.Classification = record.Classification
+	responsePayload.Status = record.Status
 	respondWithJSON(w, responsePayload)
 }
 
 // respondWithJSON sets the "Content-Type" header of the HTTP response to "application/json".
 // It also writes the response payload as JSON to the response writer.
 // If there is an error encoding the response payload, it returns early without writing anything.
-func respondWithJSON(w http.ResponseWriter, responsePayload ResponsePayload) {
+func respondWithJSON(w http.ResponseWriter, responsePayload any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	err := json.NewEncoder(w).Encode(responsePayload)
@@ -189,12 +206,49 @@ func respondWithJSON(w http.ResponseWriter, responsePayload ResponsePayload) {
 	}
 }
 
+// respondWithError writes err as a JSON ErrorPayload. A ValidationError is answered with
+// 422 Unprocessable Entity and names the offending field, ErrNotFound with 404, ErrUnauthorized
+// with 401, ErrForbidden with 403, ErrConflict and ErrImmutable with 409 and any other error
+// with 500.
+func respondWithError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	errorValues := ErrorValues{Reason: "internal server error"}
+
+	var validationErr *ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		status = http.StatusUnprocessableEntity
+		errorValues = ErrorValues{Field: validationErr.Field, Reason: validationErr.Reason}
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+		errorValues = ErrorValues{Reason: err.Error()}
+	case errors.Is(err, ErrUnauthorized):
+		status = http.StatusUnauthorized
+		errorValues = ErrorValues{Reason: err.Error()}
+	case errors.Is(err, ErrForbidden):
+		status = http.StatusForbidden
+		errorValues = ErrorValues{Reason: err.Error()}
+	case errors.Is(err, ErrConflict), errors.Is(err, ErrImmutable):
+		status = http.StatusConflict
+		errorValues = ErrorValues{Reason: err.Error()}
+	default:
+		log.Println(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err = json.NewEncoder(w).Encode(ErrorPayload{Error: errorValues})
+	if err != nil {
+		return
+	}
+}
+
 // corsMiddleware is a middleware function that adds the necessary CORS headers to the HTTP response.
 func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -214,75 +268,119 @@ func SumArray(arr []int) int {
 	return sum
 }
 
-// CalculateDailyValues calculates the total value of the daily values in euros and cents
-// based on the given RequestValues struct.
+// CalculateDailyValues calculates the total value of the loose notes and coins in cents
+// based on the given RequestValues and the denominations of the currency.
 // The function uses the SumArray helper function to calculate the sum of each array and multiplies
-// it by the corresponding euro or cent value.
+// it by the face value of the denomination. Codes that are not part of the currency are skipped,
+// validateCounts reports them before any calculation.
 // The result is the sum of all the calculated values.
-func CalculateDailyValues(dailyValues RequestValues) float64 {
-	return float64(SumArray(dailyValues.Euro200[:])*200) +
-		float64(SumArray(dailyValues.Euro100[:])*100) +
-		float64(SumArray(dailyValues.Euro50[:])*50) +
-		float64(SumArray(dailyValues.Euro20[:])*20) +
-		float64(SumArray(dailyValues.Euro10[:])*10) +
-		float64(SumArray(dailyValues.Euro5[:])*5) +
-		float64(SumArray(dailyValues.Euro2[:])*2) +
-		float64(SumArray(dailyValues.Euro1[:])*1) +
-		float64(SumArray(dailyValues.Cent50[:]))*0.5 +
-		float64(SumArray(dailyValues.Cent20[:]))*0.2 +
-		float64(SumArray(dailyValues.Cent10[:]))*0.1 +
-		float64(SumArray(dailyValues.Cent5[:]))*0.05 +
-		float64(SumArray(dailyValues.Cent2[:]))*0.02 +
-		float64(SumArray(dailyValues.Cent1[:]))*0.01
+func CalculateDailyValues(currency *Currency, dailyValues RequestValues) Money {
+	var total Money
+	for _, d := range currency.Denominations {
+		columns := dailyValues[d.Code]
+		total += Money(SumArray(columns[:])) * d.Value
+	}
+	return total
 }
 
-// CalculateRollValues calculates the total value of the given RollValues struct. It
-// multiplies the sum of each array by the corresponding coin value and the number of coins
-// per roll. The calculated values are then summed up and returned as a float64 value.
-func CalculateRollValues(rollValues RollValues) float64 {
-	coinSumEuro2 := float64(SumArray(rollValues.Euro2[:])) * Euro2Value * CoinsPerRollEuro
-	coinSumEuro1 := float64(SumArray(rollValues.Euro1[:])) * Euro1Value * CoinsPerRollEuro
-	coinSumCent50 := float64(SumArray(rollValues.Cent50[:])) * Cent50Value * CoinsPerRollBigCent
-	coinSumCent20 := float64(SumArray(rollValues.Cent20[:])) * Cent20Value * CoinsPerRollBigCent
-	coinSumCent10 := float64(SumArray(rollValues.Cent10[:])) * Cent10Value * CoinsPerRollBigCent
-	coinSumCent5 := float64(SumArray(rollValues.Cent5[:])) * Cent5Value * CoinsPerRollSmallCent
-	coinSumCent2 := float64(SumArray(rollValues.Cent2[:])) * Cent2Value * CoinsPerRollSmallCent
-	coinSumCent1 := float64(SumArray(rollValues.Cent1[:])) * Cent1Value * CoinsPerRollSmallCent
-
-	return coinSumEuro2 + coinSumEuro1 + coinSumCent50 + coinSumCent20 + coinSumCent10 + coinSumCent5 + coinSumCent2 + coinSumCent1
+// CalculateRollValues calculates the total value of the given RollValues. It
+// multiplies the sum of each array by the coin value and the number of coins
+// per roll of the denomination. The calculated values are then summed up and returned as a Money value.
+func CalculateRollValues(currency *Currency, rollValues RollValues) Money {
+	var total Money
+	for _, d := range currency.Denominations {
+		rolls := rollValues[d.Code]
+		total += Money(SumArray(rolls[:])) * d.Value * Money(d.RollSize)
+	}
+	return total
 }
 
 // calculateIndividualBoxValue calculates the value of an individual box based on the provided array of integers,
 // a value multiplier, a times multiplier, and a multiplier factor.
 // It first sums up the elements in the array using the SumArray function.
 // Then, it multiplies the sum by the value, times, and multiplier constants.
-// The final result is returned as a float64 value.
-func calculateIndividualBoxValue(arr []int, value, times, multiplier float64) float64 {
-	return float64(SumArray(arr)) * value * times * multiplier
+// The final result is returned as a Money value.
+func calculateIndividualBoxValue(arr []int, value Money, times, multiplier int) Money {
+	return Money(SumArray(arr)) * value * Money(times) * Money(multiplier)
 }
 
-func CalculateBoxValues(box BoxValues) float64 {
-	return calculateIndividualBoxValue(box.Euro2[:], Euro2Value, RollsPerBoxesThree, CoinsPerRollEuro) +
-		calculateIndividualBoxValue(box.Euro1[:], Euro1Value, RollsPerBoxesThree, CoinsPerRollEuro) +
-		calculateIndividualBoxValue(box.Cent50[:], Cent50Value, RollsPerBoxesThree, CoinsPerRollBigCent) +
-		calculateIndividualBoxValue(box.Cent20[:], Cent20Value, RollsPerBoxesThree, CoinsPerRollBigCent) +
-		calculateIndividualBoxValue(box.Cent10[:], Cent10Value, RollsPerBoxesThree, CoinsPerRollBigCent) +
-		calculateIndividualBoxValue(box.Cent5[:], Cent5Value, RollsPerBoxesThree, CoinsPerRollSmallCent) +
-		calculateIndividualBoxValue(box.Cent2[:], Cent2Value, RollsPerBoxesFive, CoinsPerRollSmallCent) +
-		calculateIndividualBoxValue(box.Cent1[:], Cent1Value, RollsPerBoxesFive, CoinsPerRollSmallCent)
+// CalculateBoxValues calculates the total value of the given BoxValues. Each box holds
+// a fixed number of rolls, so the value of a box is the roll value times the rolls per box.
+func CalculateBoxValues(currency *Currency, box BoxValues) Money {
+	var total Money
+	for _, d := range currency.Denominations {
+		boxes := box[d.Code]
+		total += calculateIndividualBoxValue(boxes[:], d.Value, d.RollsPerBox, d.RollSize)
+	}
+	return total
+}
+
+// validateCounts checks that every code in the request is a denomination of the currency and
+// that rolls and boxes are only given for denominations that can be packed that way. No
+// quantity may be negative.
+func validateCounts(currency *Currency, request RequestPayload) error {
+	for _, code := range sortedKeys(request.RequestValues) {
+		if _, ok := currency.Denomination(code); !ok {
+			return &ValidationError{Field: "requestValues." + code, Reason: "unknown denomination for " + currency.Code}
+		}
+		if cells := request.RequestValues[code]; slices.Min(cells[:]) < 0 {
+			return &ValidationError{Field: "requestValues." + code, Reason: "must not be negative"}
+		}
+	}
+	for _, code := range sortedKeys(request.RollValues) {
+		d, ok := currency.Denomination(code)
+		if !ok {
+			return &ValidationError{Field: "rollValues." + code, Reason: "unknown denomination for " + currency.Code}
+		}
+		if d.RollSize == 0 {
+			return &ValidationError{Field: "rollValues." + code, Reason: "denomination is not packed in rolls"}
+		}
+		if rolls := request.RollValues[code]; slices.Min(rolls[:]) < 0 {
+			return &ValidationError{Field: "rollValues." + code, Reason: "must not be negative"}
+		}
+	}
+	for _, code := range sortedKeys(request.BoxValues) {
+		d, ok := currency.Denomination(code)
+		if !ok {
+			return &ValidationError{Field: "boxValues." + code, Reason: "unknown denomination for " + currency.Code}
+		}
+		if d.RollsPerBox == 0 {
+			return &ValidationError{Field: "boxValues." + code, Reason: "denomination is not packed in boxes"}
+		}
+		if request.BoxValues[code][0] < 0 {
+			return &ValidationError{Field: "boxValues." + code, Reason: "must not be negative"}
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of m in ascending order, so validation errors do not depend on map order.
+func sortedKeys[M ~map[string]V, V any](m M) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 // calculateTotalValue calculates the total value based on the given RequestPayload struct.
 // It calls the CalculateValuesForCashCounts function to calculate the intermediate values.
-// It converts the differenceValue and totalValue+boxValues+rollValues to strings using strconv.FormatFloat.
+// It converts the differenceValue and totalValue+boxValues+rollValues to strings using FormatNumber.
+// The three subtotals are returned as well, together with the values per column and per denomination
+// which are calculated from the request converted to counts.
 // It constructs and returns a ResponsePayload struct with the calculated values.
-func calculateTotalValue(request RequestPayload) ResponsePayload {
-	totalValue, boxValues, rollValues, differenceValue := CalculateValuesForCashCounts(request)
+// Validation errors from CalculateValuesForCashCounts are returned unchanged.
+func calculateTotalValue(catalog *Catalog, request RequestPayload) (ResponsePayload, error) {
+	totalValue, boxValues, rollValues, differenceValue, err := CalculateValuesForCashCounts(catalog, request)
+	if err != nil {
+		return ResponsePayload{}, err
+	}
+	currency, _ := catalog.Currency(request.Currency)
+	counts := request.Counts()
 
 	// convert to strings
-	// differenceValueAsStr := strconv.FormatFloat(differenceValue, 'f', 2, 64)
 	differenceValueAsStr := FormatNumber(differenceValue)
-	// valueAsStr := strconv.FormatFloat(totalValue+boxValues+rollValues, 'f', 2, 64)
 	valueAsStr := FormatNumber(totalValue + boxValues + rollValues)
 
 	// response
@@ -291,34 +389,164 @@ func calculateTotalValue(request RequestPayload) ResponsePayload {
 		ResponseValues: ResponseValues{
 			TotalValue:      valueAsStr,
 			DifferenceValue: differenceValueAsStr,
+			Breakdown: BreakdownValues{
+				LooseValue:    FormatNumber(totalValue),
+				RollValue:     FormatNumber(rollValues),
+				BoxValue:      FormatNumber(boxValues),
+				Columns:       CalculateColumnValues(currency, counts, v1Columns),
+				Denominations: CalculateDenominationValues(currency, counts),
+			},
 		},
-	}
+	}, nil
 }
 
 // CalculateValuesForCashCounts calculates the total value, box value, roll value, and difference value
-// based on the given RequestPayload struct. It uses the CalculateDailyValues, CalculateBoxValues,
+// based on the given RequestPayload struct and the currency it names in the catalog.
+// It uses the CalculateDailyValues, CalculateBoxValues,
 // and CalculateRollValues functions to calculate the intermediate values. It converts the target value
-// from string to float64 using strconv.ParseFloat. The difference value is calculated as the difference
-// between the sum of total value, box value, and roll value, and the target value as a float64.
-// The function returns the calculated total value, box value, roll value, and difference value as float64.
-func CalculateValuesForCashCounts(request RequestPayload) (float64, float64, float64, float64) {
+// from string to Money using ParseMoney. The difference value is calculated as the difference
+// between the sum of total value, box value, and roll value, and the target value.
+// The function returns the calculated total value, box value, roll value, and difference value as Money.
+// If the currency, a denomination or the target value cannot be used, it returns a ValidationError
+// naming the field.
+func CalculateValuesForCashCounts(catalog *Catalog, request RequestPayload) (Money, Money, Money, Money, error) {
+	currency, ok := catalog.Currency(request.Currency)
+	if !ok {
+		return 0, 0, 0, 0, &ValidationError{Field: "currency", Reason: "unknown currency " + request.Currency}
+	}
+	if err := validateCounts(currency, request); err != nil {
+		return 0, 0, 0, 0, err
+	}
+	targetValue, err := ParseMoney(request.RequestValidation.TargetValue)
+	if err != nil {
+		return 0, 0, 0, 0, &ValidationError{Field: "requestValidation.targetValue", Reason: err.Error()}
+	}
+
 	// calculate intermediate values
-	totalValue := CalculateDailyValues(request.RequestValues)
-	boxValues := CalculateBoxValues(request.BoxValues)
-	rollValues := CalculateRollValues(request.RollValues)
-	targetValueAsFloat, _ := strconv.ParseFloat(request.RequestValidation.TargetValue, 64)
+	totalValue := CalculateDailyValues(currency, request.RequestValues)
+	boxValues := CalculateBoxValues(currency, request.BoxValues)
+	rollValues := CalculateRollValues(currency, request.RollValues)
 
 	// calculate diff value
-	differenceValue := totalValue + boxValues + rollValues - targetValueAsFloat
-	return totalValue, boxValues, rollValues, differenceValue
+	differenceValue := totalValue + boxValues + rollValues - targetValue
+	return totalValue, boxValues, rollValues, differenceValue, nil
+}
+
+// Server holds the configuration, the store and the audit journal the HTTP handlers work with.
+type Server struct {
+	// mu guards the parts of config that can be changed at runtime, the tolerance rules
+	mu      sync.RWMutex
+	config  *Config
+	store   *Store
+	journal *Journal
+}
+
+// NewServer returns a Server for the given configuration, store and journal.
+func NewServer(config *Config, store *Store, journal *Journal) *Server {
+	return &Server{config: config, store: store, journal: journal}
 }
 
-// main starts the HTTP server and registers the handler functions.
-// It listens for requests on the "/api/v1/calculate" endpoint.
-// It uses the corsMiddleware function to add the necessary CORS headers.
-// If there is an error starting the server, it logs the error and exits.
+// routes registers the handler functions and returns the resulting handler.
+// It wraps all routes in the corsMiddleware function, so preflight requests are answered
+// before the router checks the request method, and every route in the access check of its
+// policy.
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	s.handle(mux, "/api/v1/calculate", s.handlePOSTRequest)
+	s.handle(mux, "POST /api/v2/calculate", s.handleCalculateV2)
+	s.handle(mux, "GET /api/v1/packing", s.handleGETPacking)
+	s.handle(mux, "GET /api/v1/counts", s.handleListCounts)
+	s.handle(mux, "GET /api/v1/counts/{id}", s.handleGetCount)
+	s.handle(mux, "POST /api/v1/counts/{id}/approve", s.handleApproveCount)
+	s.handle(mux, "POST /api/v1/counts/{id}/reject", s.handleRejectCount)
+	s.handle(mux, "POST /api/v1/counts/{id}/recount", s.handleRecount)
+	s.handle(mux, "GET /api/v1/registers/{registerId}/z-report", s.handleZReport)
+	s.handle(mux, "GET /api/v1/registers/{registerId}/change-order", s.handleChangeOrder)
+	s.handle(mux, "POST /api/v1/registers/{registerId}/drops", s.handleDrop)
+	s.handle(mux, "POST /api/v1/sessions", s.handleCreateSession)
+	s.handle(mux, "GET /api/v1/sessions/{id}", s.handleGetSession)
+	s.handle(mux, "PUT /api/v1/sessions/{id}", s.handleUpdateSession)
+	s.handle(mux, "DELETE /api/v1/sessions/{id}", s.handleDeleteSession)
+	s.handle(mux, "POST /api/v1/sessions/{id}/counts", s.handleSessionCount)
+	s.handle(mux, "POST /api/v1/sessions/{id}/handover", s.handleHandover)
+	s.handle(mux, "POST /api/v1/sessions/{id}/sales", s.handleSale)
+	s.handle(mux, "POST /api/v1/sessions/{id}/skims", s.handleSkim)
+	s.handle(mux, "POST /api/v1/float-plan", s.handleFloatPlan)
+	s.handle(mux, "POST /api/v1/deposit-slips", s.handleCreateDepositSlip)
+	s.handle(mux, "GET /api/v1/deposit-slips/{number}", s.handleGetDepositSlip)
+	s.handle(mux, "GET /api/v1/tolerance-rules", s.handleGetToleranceRules)
+	s.handle(mux, "PUT /api/v1/tolerance-rules", s.handlePutToleranceRules)
+	s.handle(mux, "GET /api/v1/journal", s.handleListJournal)
+	s.handle(mux, "GET /api/v1/journal/verify", s.handleVerifyJournal)
+	s.handle(mux, "POST /api/v1/stores", s.handleCreateShop)
+	s.handle(mux, "GET /api/v1/stores", s.handleListShops)
+	s.handle(mux, "GET /api/v1/stores/{storeId}", s.handleGetShop)
+	s.handle(mux, "PUT /api/v1/stores/{storeId}", s.handleUpdateShop)
+	s.handle(mux, "DELETE /api/v1/stores/{storeId}", s.handleDeleteShop)
+	s.handle(mux, "POST /api/v1/registers", s.handleCreateRegister)
+	s.handle(mux, "GET /api/v1/registers", s.handleListRegisters)
+	s.handle(mux, "GET /api/v1/registers/{registerId}", s.handleGetRegister)
+	s.handle(mux, "PUT /api/v1/registers/{registerId}", s.handleUpdateRegister)
+	s.handle(mux, "DELETE /api/v1/registers/{registerId}", s.handleDeleteRegister)
+	s.handle(mux, "GET /api/v1/sessions", s.handleListSessions)
+	s.handle(mux, "GET /api/v1/reports/summary", s.handleSummary)
+	s.handle(mux, "GET /api/v1/safes/{safeId}", s.handleSafeInventory)
+	s.handle(mux, "POST /api/v1/safes/{safeId}/counts", s.handleSafeCount)
+	s.handle(mux, "GET /api/v1/safes/{safeId}/counts", s.handleListSafeCounts)
+	s.handle(mux, "POST /api/v1/safes/{safeId}/movements", s.handleSafeMovement)
+	s.handle(mux, "GET /api/v1/safes/{safeId}/movements", s.handleListSafeMovements)
+	s.handle(mux, "POST /api/v1/imports/pos", s.handleImportPOS)
+	s.handle(mux, "GET /api/v1/imports/pos", s.handleListPOSImports)
+	s.handle(mux, "GET /api/v1/exports/counts", s.handleExportCounts)
+	s.handle(mux, "GET /api/v1/exports/datev", s.handleExportDatev)
+	s.handle(mux, "GET /api/v1/cash-book", s.handleListCashBook)
+	s.handle(mux, "GET /api/v1/cash-book/verify", s.handleVerifyCashBook)
+	s.handle(mux, "POST /api/v1/cash-book/{number}/reverse", s.handleReverseCashBookEntry)
+	return corsMiddleware(s.authenticate(mux.ServeHTTP))
+}
+
+// main loads the configuration given with -config, opens the store given with -db and the journal
+// given with -journal and starts the HTTP server.
+// It listens for requests on the "/api/v1/..." and "/api/v2/..." endpoints registered in routes.
+// "register-api verify-journal [path]" verifies the audit journal instead of starting the server.
+// If there is an error loading the configuration, opening the store or starting the server, it logs the error and exits.
 func main() {
-	http.HandleFunc("/api/v1/calculate", corsMiddleware(handlePOSTRequest))
+	if len(os.Args) > 1 && os.Args[1] == "verify-journal" {
+		os.Exit(verifyJournalCommand(os.Args[2:], os.Stdout))
+	}
+
+	configPath := flag.String("config", "", "path to the JSON configuration file")
+	dbPath := flag.String("db", "register.db", "path to the database file")
+	journalPath := flag.String("journal", "journal.jsonl", "path to the audit journal")
+	flag.Parse()
+
+	config, err := LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, err := OpenStore(*dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+	if rules, ok, err := store.ToleranceRules(); err != nil {
+		log.Fatal(err)
+	} else if ok {
+		log.Println("using the tolerance rules last changed through the api instead of the configuration")
+		config.Tolerance = rules
+	}
+	journal, err := OpenJournal(*journalPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer journal.Close()
+
+	switch {
+	case config.Auth.Disabled:
+		log.Println("warning: authentication is disabled, the api is open to everyone")
+	case !config.Auth.Enabled():
+		log.Println("warning: no api keys or jwt keys configured, every request is denied")
+	}
 	log.Println("Server starting on port 8002...")
-	log.Fatal(http.ListenAndServe(":8002", nil))
+	log.Fatal(http.ListenAndServe(":8002", NewServer(config, store, journal).routes()))
 }
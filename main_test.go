@@ -1,12 +1,25 @@
 package main
 
 import (
-	"math"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// testCatalog is the default catalog the calculator tests count against.
+var testCatalog = DefaultCatalog()
+
+// euro returns the euro of the default catalog.
+func euro() *Currency {
+	currency, _ := testCatalog.Currency("EUR")
+	return currency
+}
+
 func TestSumArray(t *testing.T) {
 	got := SumArray([]int{1, 2, 3, 4, 5})
 	want := 15
@@ -19,177 +32,172 @@ func TestCalculateDailyValues(t *testing.T) {
 	type test struct {
 		name     string
 		input    RequestValues
-		expected float64
+		expected Money
 	}
 
 	tests := []test{
 		{
 			name: "All coins and bills present",
 			input: RequestValues{
-				Euro200: [5]int{1, 2, 3, 4, 5},
-				Euro100: [5]int{1, 2, 3, 4, 5},
-				Euro50:  [5]int{1, 2, 3, 4, 5},
-				Euro20:  [5]int{1, 2, 3, 4, 5},
-				Euro10:  [5]int{1, 2, 3, 4, 5},
-				Euro5:   [5]int{1, 2, 3, 4, 5},
-				Euro2:   [5]int{1, 2, 3, 4, 5},
-				Euro1:   [5]int{1, 2, 3, 4, 5},
-				Cent50:  [5]int{1, 2, 3, 4, 5},
-				Cent20:  [5]int{1, 2, 3, 4, 5},
-				Cent10:  [5]int{1, 2, 3, 4, 5},
-				Cent5:   [5]int{1, 2, 3, 4, 5},
-				Cent2:   [5]int{1, 2, 3, 4, 5},
-				Cent1:   [5]int{1, 2, 3, 4, 5},
+				"euro200": {1, 2, 3, 4, 5},
+				"euro100": {1, 2, 3, 4, 5},
+				"euro50":  {1, 2, 3, 4, 5},
+				"euro20":  {1, 2, 3, 4, 5},
+				"euro10":  {1, 2, 3, 4, 5},
+				"euro5":   {1, 2, 3, 4, 5},
+				"euro2":   {1, 2, 3, 4, 5},
+				"euro1":   {1, 2, 3, 4, 5},
+				"cent50":  {1, 2, 3, 4, 5},
+				"cent20":  {1, 2, 3, 4, 5},
+				"cent10":  {1, 2, 3, 4, 5},
+				"cent5":   {1, 2, 3, 4, 5},
+				"cent2":   {1, 2, 3, 4, 5},
+				"cent1":   {1, 2, 3, 4, 5},
 			},
-			expected: 5833.2,
+			expected: 583320,
 		},
 		{
 			name: "No coins or bills present",
 			input: RequestValues{
-				Euro200: [5]int{0, 0, 0, 0, 0},
-				Euro100: [5]int{0, 0, 0, 0, 0},
-				Euro50:  [5]int{0, 0, 0, 0, 0},
-				Euro20:  [5]int{0, 0, 0, 0, 0},
-				Euro10:  [5]int{0, 0, 0, 0, 0},
-				Euro5:   [5]int{0, 0, 0, 0, 0},
-				Euro2:   [5]int{0, 0, 0, 0, 0},
-				Euro1:   [5]int{0, 0, 0, 0, 0},
-				Cent50:  [5]int{0, 0, 0, 0, 0},
-				Cent20:  [5]int{0, 0, 0, 0, 0},
-				Cent10:  [5]int{0, 0, 0, 0, 0},
-				Cent5:   [5]int{0, 0, 0, 0, 0},
-				Cent2:   [5]int{0, 0, 0, 0, 0},
-				Cent1:   [5]int{0, 0, 0, 0, 0},
+				"euro200": {0, 0, 0, 0, 0},
+				"euro100": {0, 0, 0, 0, 0},
+				"euro50":  {0, 0, 0, 0, 0},
+				"euro20":  {0, 0, 0, 0, 0},
+				"euro10":  {0, 0, 0, 0, 0},
+				"euro5":   {0, 0, 0, 0, 0},
+				"euro2":   {0, 0, 0, 0, 0},
+				"euro1":   {0, 0, 0, 0, 0},
+				"cent50":  {0, 0, 0, 0, 0},
+				"cent20":  {0, 0, 0, 0, 0},
+				"cent10":  {0, 0, 0, 0, 0},
+				"cent5":   {0, 0, 0, 0, 0},
+				"cent2":   {0, 0, 0, 0, 0},
+				"cent1":   {0, 0, 0, 0, 0},
 			},
-			expected: 0.0,
+			expected: 0,
 		},
 		{
 			name: "Only cents",
 			input: RequestValues{
-				Euro200: [5]int{0, 0, 0, 0, 0},
-				Euro100: [5]int{0, 0, 0, 0, 0},
-				Euro50:  [5]int{0, 0, 0, 0, 0},
-				Euro20:  [5]int{0, 0, 0, 0, 0},
-				Euro10:  [5]int{0, 0, 0, 0, 0},
-				Euro5:   [5]int{0, 0, 0, 0, 0},
-				Euro2:   [5]int{0, 0, 0, 0, 0},
-				Euro1:   [5]int{0, 0, 0, 0, 0},
-				Cent50:  [5]int{1, 2, 3, 4, 5},
-				Cent20:  [5]int{1, 2, 3, 4, 5},
-				Cent10:  [5]int{1, 2, 3, 4, 5},
-				Cent5:   [5]int{1, 2, 3, 4, 5},
-				Cent2:   [5]int{1, 2, 3, 4, 5},
-				Cent1:   [5]int{1, 2, 3, 4, 5},
+				"euro200": {0, 0, 0, 0, 0},
+				"euro100": {0, 0, 0, 0, 0},
+				"euro50":  {0, 0, 0, 0, 0},
+				"euro20":  {0, 0, 0, 0, 0},
+				"euro10":  {0, 0, 0, 0, 0},
+				"euro5":   {0, 0, 0, 0, 0},
+				"euro2":   {0, 0, 0, 0, 0},
+				"euro1":   {0, 0, 0, 0, 0},
+				"cent50":  {1, 2, 3, 4, 5},
+				"cent20":  {1, 2, 3, 4, 5},
+				"cent10":  {1, 2, 3, 4, 5},
+				"cent5":   {1, 2, 3, 4, 5},
+				"cent2":   {1, 2, 3, 4, 5},
+				"cent1":   {1, 2, 3, 4, 5},
 			},
-			expected: 13.2,
+			expected: 1320,
 		},
 		{
 			name: "Only 50 cents",
 			input: RequestValues{
-				Euro200: [5]int{0, 0, 0, 0, 0},
-				Euro100: [5]int{0, 0, 0, 0, 0},
-				Euro50:  [5]int{0, 0, 0, 0, 0},
-				Euro20:  [5]int{0, 0, 0, 0, 0},
-				Euro10:  [5]int{0, 0, 0, 0, 0},
-				Euro5:   [5]int{0, 0, 0, 0, 0},
-				Euro2:   [5]int{0, 0, 0, 0, 0},
-				Euro1:   [5]int{0, 0, 0, 0, 0},
-				Cent50:  [5]int{1, 2, 3, 4, 5},
-				Cent20:  [5]int{0, 0, 0, 0, 0},
-				Cent10:  [5]int{0, 0, 0, 0, 0},
-				Cent5:   [5]int{0, 0, 0, 0, 0},
-				Cent2:   [5]int{0, 0, 0, 0, 0},
-				Cent1:   [5]int{0, 0, 0, 0, 0},
+				"euro200": {0, 0, 0, 0, 0},
+				"euro100": {0, 0, 0, 0, 0},
+				"euro50":  {0, 0, 0, 0, 0},
+				"euro20":  {0, 0, 0, 0, 0},
+				"euro10":  {0, 0, 0, 0, 0},
+				"euro5":   {0, 0, 0, 0, 0},
+				"euro2":   {0, 0, 0, 0, 0},
+				"euro1":   {0, 0, 0, 0, 0},
+				"cent50":  {1, 2, 3, 4, 5},
+				"cent20":  {0, 0, 0, 0, 0},
+				"cent10":  {0, 0, 0, 0, 0},
+				"cent5":   {0, 0, 0, 0, 0},
+				"cent2":   {0, 0, 0, 0, 0},
+				"cent1":   {0, 0, 0, 0, 0},
 			},
-			expected: 7.5,
+			expected: 750,
 		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			if result := CalculateDailyValues(tc.input); !almostEqual(result, tc.expected) {
+			if result := CalculateDailyValues(euro(), tc.input); result != tc.expected {
 				t.Fatalf("CalculateDailyValues() returned %v, want %v", result, tc.expected)
 			}
 		})
 	}
 }
 
-// Comparison of two floats is not straightforward so we're using a tolerance based comparison
-func almostEqual(a, b float64) bool {
-	return math.Abs(a-b) <= 1e-6
-}
-
 func TestCalculateRollValues(t *testing.T) {
 	type test struct {
 		name     string
 		input    RollValues
-		expected float64
+		expected Money
 	}
 
 	tests := []test{
 		{
 			name: "All zero values",
 			input: RollValues{
-				Euro2:  [2]int{0, 0},
-				Euro1:  [2]int{0, 0},
-				Cent50: [2]int{0, 0},
-				Cent20: [2]int{0, 0},
-				Cent10: [2]int{0, 0},
-				Cent5:  [2]int{0, 0},
-				Cent2:  [2]int{0, 0},
-				Cent1:  [2]int{0, 0},
+				"euro2":  {0, 0},
+				"euro1":  {0, 0},
+				"cent50": {0, 0},
+				"cent20": {0, 0},
+				"cent10": {0, 0},
+				"cent5":  {0, 0},
+				"cent2":  {0, 0},
+				"cent1":  {0, 0},
 			},
-			expected: 0.0,
+			expected: 0,
 		},
 		{
 			name: "Only one roll per value",
 			input: RollValues{
-				Euro2:  [2]int{1, 0},
-				Euro1:  [2]int{1, 0},
-				Cent50: [2]int{1, 0},
-				Cent20: [2]int{1, 0},
-				Cent10: [2]int{1, 0},
-				Cent5:  [2]int{1, 0},
-				Cent2:  [2]int{1, 0},
-				Cent1:  [2]int{1, 0},
+				"euro2":  {1, 0},
+				"euro1":  {1, 0},
+				"cent50": {1, 0},
+				"cent20": {1, 0},
+				"cent10": {1, 0},
+				"cent5":  {1, 0},
+				"cent2":  {1, 0},
+				"cent1":  {1, 0},
 			},
-			expected: 111.0,
+			expected: 11100,
 		},
 		{
 			name: "All one values",
 			input: RollValues{
-				Euro2:  [2]int{1, 1},
-				Euro1:  [2]int{1, 1},
-				Cent50: [2]int{1, 1},
-				Cent20: [2]int{1, 1},
-				Cent10: [2]int{1, 1},
-				Cent5:  [2]int{1, 1},
-				Cent2:  [2]int{1, 1},
-				Cent1:  [2]int{1, 1},
+				"euro2":  {1, 1},
+				"euro1":  {1, 1},
+				"cent50": {1, 1},
+				"cent20": {1, 1},
+				"cent10": {1, 1},
+				"cent5":  {1, 1},
+				"cent2":  {1, 1},
+				"cent1":  {1, 1},
 			},
-			expected: 222.0,
+			expected: 22200,
 		},
 		{
 			name: "Random values",
 			input: RollValues{
-				Euro2:  [2]int{3, 2},
-				Euro1:  [2]int{1, 4},
-				Cent50: [2]int{5, 6},
-				Cent20: [2]int{7, 8},
-				Cent10: [2]int{9, 10},
-				Cent5:  [2]int{10, 9},
-				Cent2:  [2]int{8, 7},
-				Cent1:  [2]int{6, 5},
+				"euro2":  {3, 2},
+				"euro1":  {1, 4},
+				"cent50": {5, 6},
+				"cent20": {7, 8},
+				"cent10": {9, 10},
+				"cent5":  {10, 9},
+				"cent2":  {8, 7},
+				"cent1":  {6, 5},
 			},
-			expected: 859.00,
+			expected: 85900,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := CalculateRollValues(tt.input)
+			result := CalculateRollValues(euro(), tt.input)
 
 			if result != tt.expected {
-				t.Errorf("Expected %f, got %f", tt.expected, result)
+				t.Errorf("Expected %v, got %v", tt.expected, result)
 			}
 		})
 	}
@@ -198,22 +206,22 @@ func TestCalculateBoxValues(t *testing.T) {
 	cases := []struct {
 		name     string
 		box      BoxValues
-		expected float64
+		expected Money
 	}{
-		{"All boxes are empty", BoxValues{Euro2: [1]int{0}, Euro1: [1]int{0}, Cent50: [1]int{0}, Cent20: [1]int{0}, Cent10: [1]int{0}, Cent2: [1]int{0}, Cent1: [1]int{0}}, 0},
-		{"All boxes are full", BoxValues{Euro2: [1]int{1}, Euro1: [1]int{1}, Cent50: [1]int{1}, Cent20: [1]int{1},
-			Cent10: [1]int{1}, Cent5: [1]int{1}, Cent2: [1]int{1}, Cent1: [1]int{1}}, 336.0},
-		{"Only Euro2 boxes are full", BoxValues{Euro2: [1]int{1}, Euro1: [1]int{0}, Cent50: [1]int{0},
-			Cent20: [1]int{0}, Cent10: [1]int{0}, Cent2: [1]int{0}, Cent1: [1]int{0}}, 150},
-		{"Only Euro1 boxes are full", BoxValues{Euro2: [1]int{0}, Euro1: [1]int{1}, Cent50: [1]int{0},
-			Cent20: [1]int{0}, Cent10: [1]int{0}, Cent2: [1]int{0}, Cent1: [1]int{0}}, 75},
+		{"All boxes are empty", BoxValues{"euro2": {0}, "euro1": {0}, "cent50": {0}, "cent20": {0}, "cent10": {0}, "cent2": {0}, "cent1": {0}}, 0},
+		{"All boxes are full", BoxValues{"euro2": {1}, "euro1": {1}, "cent50": {1}, "cent20": {1},
+			"cent10": {1}, "cent5": {1}, "cent2": {1}, "cent1": {1}}, 33600},
+		{"Only Euro2 boxes are full", BoxValues{"euro2": {1}, "euro1": {0}, "cent50": {0},
+			"cent20": {0}, "cent10": {0}, "cent2": {0}, "cent1": {0}}, 15000},
+		{"Only Euro1 boxes are full", BoxValues{"euro2": {0}, "euro1": {1}, "cent50": {0},
+			"cent20": {0}, "cent10": {0}, "cent2": {0}, "cent1": {0}}, 7500},
 	}
 
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
-			result := CalculateBoxValues(c.box)
+			result := CalculateBoxValues(euro(), c.box)
 			if result != c.expected {
-				t.Errorf("Expected %f but got %f", c.expected, result)
+				t.Errorf("Expected %v but got %v", c.expected, result)
 			}
 		})
 	}
@@ -221,40 +229,51 @@ func TestCalculateBoxValues(t *testing.T) {
 func TestCalculateValuesForCashCounts(t *testing.T) {
 	var tests = []struct {
 		input          RequestPayload
-		wantTotalValue float64
-		wantBoxValue   float64
-		wantRollValue  float64
-		wantDiffValue  float64
+		wantTotalValue Money
+		wantBoxValue   Money
+		wantRollValue  Money
+		wantDiffValue  Money
 	}{
-		{RequestPayload{}, 0, 0, 0, 0},
+		{RequestPayload{RequestValidation: RequestValidation{TargetValue: "0"}}, 0, 0, 0, 0},
 		{RequestPayload{
-			RequestValidation: RequestValidation{},
+			RequestValidation: RequestValidation{TargetValue: "0,00"},
 			RequestValues:     RequestValues{},
 			BoxValues: BoxValues{
-				Euro2: [1]int{1},
+				"euro2": {1},
 			},
 			RollValues:  RollValues{},
 			PayloadType: 1,
-		}, 0, 150, 0, 150},
+		}, 0, 15000, 0, 15000},
 		{RequestPayload{
 			RequestValidation: RequestValidation{
 				TargetValue: "253.00",
 			},
 			RequestValues: RequestValues{},
 			BoxValues: BoxValues{
-				Euro2: [1]int{1},
+				"euro2": {1},
 			},
 			RollValues: RollValues{
-				Euro2: [2]int{1, 1},
+				"euro2": {1, 1},
 			},
 			PayloadType: 1,
-		}, 0, 150, 100, -3},
+		}, 0, 15000, 10000, -300},
+		{RequestPayload{
+			RequestValidation: RequestValidation{
+				TargetValue: "1.253,00",
+			},
+			BoxValues: BoxValues{
+				"euro2": {1},
+			},
+		}, 0, 15000, 0, -110300},
 	}
 	for _, tt := range tests {
 		tt := tt
 		t.Run("", func(t *testing.T) {
 			t.Parallel()
-			got1, got2, got3, got4 := CalculateValuesForCashCounts(tt.input)
+			got1, got2, got3, got4, err := CalculateValuesForCashCounts(&testCatalog, tt.input)
+			if err != nil {
+				t.Fatalf("CalculateValuesForCashCounts() error = %v", err)
+			}
 			if got1 != tt.wantTotalValue {
 				t.Errorf("CalculateValuesForCashCounts() = %v, want %v", got1, tt.wantTotalValue)
 			}
@@ -271,6 +290,21 @@ func TestCalculateValuesForCashCounts(t *testing.T) {
 	}
 }
 
+func TestCalculateValuesForCashCountsInvalidTarget(t *testing.T) {
+	for _, target := range []string{"", "abc", "12.345", "1.23.4,5"} {
+		t.Run(target, func(t *testing.T) {
+			request := RequestPayload{RequestValidation: RequestValidation{TargetValue: target}}
+			_, _, _, _, err := CalculateValuesForCashCounts(&testCatalog, request)
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("CalculateValuesForCashCounts() error = %v, want a ValidationError", err)
+			}
+			assert.Equal(t, "requestValidation.targetValue", validationErr.Field)
+		})
+	}
+}
+
 func TestCalculateTotalValue(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -280,7 +314,7 @@ func TestCalculateTotalValue(t *testing.T) {
 		{
 			name: "Test with default values",
 			input: RequestPayload{
-				RequestValidation: RequestValidation{},
+				RequestValidation: RequestValidation{TargetValue: "0"},
 				RequestValues:     RequestValues{},
 				BoxValues:         BoxValues{},
 				RollValues:        RollValues{},
@@ -290,6 +324,13 @@ func TestCalculateTotalValue(t *testing.T) {
 				ResponseValues: ResponseValues{
 					TotalValue:      "0,00",
 					DifferenceValue: "0,00",
+					Breakdown: BreakdownValues{
+						LooseValue:    "0,00",
+						RollValue:     "0,00",
+						BoxValue:      "0,00",
+						Columns:       zeroColumns(),
+						Denominations: zeroDenominations(),
+					},
 				},
 				PayloadType: 2,
 			},
@@ -301,20 +342,20 @@ func TestCalculateTotalValue(t *testing.T) {
 					TargetValue: "50.00",
 				},
 				RequestValues: RequestValues{
-					Euro200: [5]int{},
-					Euro100: [5]int{},
-					Euro50:  [5]int{},
-					Euro20:  [5]int{},
-					Euro10:  [5]int{10, 0, 0, 0, 0},
-					Euro5:   [5]int{},
-					Euro2:   [5]int{},
-					Euro1:   [5]int{},
-					Cent50:  [5]int{},
-					Cent20:  [5]int{},
-					Cent10:  [5]int{0, 0, 0, 0, 0},
-					Cent5:   [5]int{},
-					Cent2:   [5]int{},
-					Cent1:   [5]int{},
+					"euro200": {},
+					"euro100": {},
+					"euro50":  {},
+					"euro20":  {},
+					"euro10":  {10, 0, 0, 0, 0},
+					"euro5":   {},
+					"euro2":   {},
+					"euro1":   {},
+					"cent50":  {},
+					"cent20":  {},
+					"cent10":  {0, 0, 0, 0, 0},
+					"cent5":   {},
+					"cent2":   {},
+					"cent1":   {},
 				},
 				BoxValues:   BoxValues{},
 				RollValues:  RollValues{},
@@ -324,6 +365,22 @@ func TestCalculateTotalValue(t *testing.T) {
 				ResponseValues: ResponseValues{
 					TotalValue:      "100,00",
 					DifferenceValue: "50,00",
+					Breakdown: BreakdownValues{
+						LooseValue: "100,00",
+						RollValue:  "0,00",
+						BoxValue:   "0,00",
+						Columns: func() []ColumnValues {
+							columns := zeroColumns()
+							columns[0].Value = "100,00"
+							return columns
+						}(),
+						Denominations: func() []DenominationValues {
+							denominations := zeroDenominations()
+							denominations[4].LooseValue = "100,00"
+							denominations[4].TotalValue = "100,00"
+							return denominations
+						}(),
+					},
 				},
 				PayloadType: 2,
 			},
@@ -333,7 +390,8 @@ func TestCalculateTotalValue(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			actual := calculateTotalValue(tt.input)
+			actual, err := calculateTotalValue(&testCatalog, tt.input)
+			assert.NoError(t, err)
 			assert.Equal(t, tt.expected, actual)
 		})
 	}
@@ -341,7 +399,7 @@ func TestCalculateTotalValue(t *testing.T) {
 func TestFormatNumber(t *testing.T) {
 	tests := []struct {
 		name  string
-		input float64
+		input Money
 		want  string
 	}{
 		{
@@ -351,32 +409,32 @@ func TestFormatNumber(t *testing.T) {
 		},
 		{
 			name:  "Twenty",
-			input: 20,
+			input: 2000,
 			want:  "20,00",
 		},
 		{
 			name:  "PositiveWithoutDigits",
-			input: 123456,
+			input: 12345600,
 			want:  "123.456,00",
 		},
 		{
 			name:  "PositiveWithDigits",
-			input: 123456.78,
+			input: 12345678,
 			want:  "123.456,78",
 		},
 		{
 			name:  "NegativeWithoutDigits",
-			input: -123456,
+			input: -12345600,
 			want:  "-123.456,00",
 		},
 		{
 			name:  "NegativeWithDigits",
-			input: -123456.78,
+			input: -12345678,
 			want:  "-123.456,78",
 		},
 		{
 			name:  "UnderOne",
-			input: 0.78,
+			input: 78,
 			want:  "0,78",
 		},
 	}
@@ -389,3 +447,45 @@ func TestFormatNumber(t *testing.T) {
 		})
 	}
 }
+
+func TestHandlePOSTRequestInvalidTarget(t *testing.T) {
+	body := `{"requestValidation":{"targetValue":"abc"},"payloadType":1}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	newTestServer(t).routes().ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"error":{"field":"requestValidation.targetValue","reason":"\"abc\" is not a number"}}`, rec.Body.String())
+}
+
+func TestHandlePOSTRequestNegativeCounts(t *testing.T) {
+	handler := newTestServer(t).routes()
+	for field, values := range map[string]string{
+		"requestValues.euro50": `"requestValues":{"euro50":[2,-1,0,0,0]}`,
+		"rollValues.euro2":     `"rollValues":{"euro2":[-1,0]}`,
+		"boxValues.euro2":      `"boxValues":{"euro2":[-1]}`,
+	} {
+		body := `{"requestValidation":{"targetValue":"0"},` + values + `,"payloadType":1}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, field)
+		assert.JSONEq(t, `{"error":{"field":"`+field+`","reason":"must not be negative"}}`, rec.Body.String(), field)
+	}
+}
+
+func TestHandlePOSTRequestGermanTarget(t *testing.T) {
+	body := `{"requestValidation":{"targetValue":"1.000,50"},"requestValues":{"euro200":[5,0,0,0,0]},"payloadType":1}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	newTestServer(t).routes().ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var response ResponsePayload
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Equal(t, "1.000,00", response.ResponseValues.TotalValue)
+	assert.Equal(t, "-0,50", response.ResponseValues.DifferenceValue)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in cents. Working with whole cents instead of float64 keeps every sum
+// and difference exact, so no tolerance is needed when comparing two amounts.
+type Money int64
+
+// String returns the amount with two decimal places and a dot as decimal separator, e.g. "-1234.56".
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+		m = -m
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, m/100, m%100)
+}
+
+// ParseMoney converts a decimal string to Money. It accepts the machine-readable format
+// ("1234.56") as well as the German format shown by the frontend ("1.234,56").
+//
+// If both '.' and ',' occur, the last one is the decimal separator and the other one groups
+// thousands. A single separator is always read as the decimal separator, repeated separators
+// as thousand separators. At most two decimal places are accepted, so the conversion never
+// has to round. The returned errors describe the reason only and are meant to be shown to users.
+func ParseMoney(s string) (Money, error) {
+	str := strings.TrimSpace(s)
+	if str == "" {
+		return 0, errors.New("value is empty")
+	}
+	negative := strings.HasPrefix(str, "-")
+	str = strings.TrimPrefix(str, "-")
+
+	integerPart, decimalPart, err := splitDecimal(str)
+	if err != nil {
+		return 0, err
+	}
+	if !isDigits(integerPart) || (decimalPart != "" && !isDigits(decimalPart)) {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	if len(decimalPart) > 2 {
+		return 0, errors.New("more than two decimal places")
+	}
+	for len(decimalPart) < 2 {
+		decimalPart += "0"
+	}
+
+	units, err := strconv.ParseInt(integerPart, 10, 64)
+	if err != nil || units > (1<<63-1)/100-1 {
+		return 0, errors.New("value is too large")
+	}
+	cents, _ := strconv.ParseInt(decimalPart, 10, 64)
+
+	value := Money(units*100 + cents)
+	if negative {
+		value = -value
+	}
+	return value, nil
+}
+
+// splitDecimal splits str into its integer and decimal part and removes any thousand separators
+// from the integer part. See ParseMoney for the rules that decide which separator is which.
+func splitDecimal(str string) (string, string, error) {
+	dots := strings.Count(str, ".")
+	commas := strings.Count(str, ",")
+
+	var decimalSep, groupSep string
+	switch {
+	case dots > 0 && commas > 0:
+		decimalSep, groupSep = ".", ","
+		if strings.LastIndex(str, ",") > strings.LastIndex(str, ".") {
+			decimalSep, groupSep = ",", "."
+		}
+		if strings.Count(str, decimalSep) > 1 {
+			return "", "", errors.New("more than one decimal separator")
+		}
+	case dots == 1:
+		decimalSep = "."
+	case commas == 1:
+		decimalSep = ","
+	case dots > 1:
+		groupSep = "."
+	case commas > 1:
+		groupSep = ","
+	}
+
+	integerPart, decimalPart := str, ""
+	if decimalSep != "" {
+		integerPart, decimalPart, _ = strings.Cut(str, decimalSep)
+		if decimalPart == "" {
+			return "", "", errors.New("missing digits after the decimal separator")
+		}
+	}
+	if groupSep == "" {
+		return integerPart, decimalPart, nil
+	}
+
+	groups := strings.Split(integerPart, groupSep)
+	if len(groups[0]) < 1 || len(groups[0]) > 3 {
+		return "", "", errors.New("invalid thousand separators")
+	}
+	for _, group := range groups[1:] {
+		if len(group) != 3 {
+			return "", "", errors.New("invalid thousand separators")
+		}
+	}
+	return strings.Join(groups, ""), decimalPart, nil
+}
+
+// isDigits reports whether s is non-empty and consists of ASCII digits only.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
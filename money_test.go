@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		input Money
+		want  string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{123456, "1234.56"},
+		{-78, "-0.78"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.input.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", int64(tt.input), got, tt.want)
+		}
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Money
+		wantErr bool
+	}{
+		{input: "253.00", want: 25300},
+		{input: "253", want: 25300},
+		{input: "0.1", want: 10},
+		{input: "-12.34", want: -1234},
+		{input: " 7.05 ", want: 705},
+		{input: "1234,56", want: 123456},
+		{input: "1.234,56", want: 123456},
+		{input: "-1.234,5", want: -123450},
+		{input: "1,234.56", want: 123456},
+		{input: "1.234.567", want: 123456700},
+		{input: "", wantErr: true},
+		{input: "abc", wantErr: true},
+		{input: "1.234", wantErr: true},
+		{input: "12.34.56,7", wantErr: true},
+		{input: "1.23,4.5", wantErr: true},
+		{input: "1234.", wantErr: true},
+		{input: "--5", wantErr: true},
+		{input: "5.-1", wantErr: true},
+		{input: "1e3", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseMoney(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMoney(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseMoney(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCentsDoNotDrift(t *testing.T) {
+	// 0.1 + 0.2 is not 0.3 in float64, but three 10 cent coins are exactly 30 cents
+	values := RequestValues{"cent10": {1, 1, 1, 0, 0}}
+	target, _ := ParseMoney("0.30")
+
+	if got := CalculateDailyValues(euro(), values) - target; got != 0 {
+		t.Errorf("difference = %v, want 0", got)
+	}
+}
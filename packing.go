@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// PackingRule says how many coins make up a roll and how many rolls make up a box.
+// A RollSize of zero means the denomination is only ever counted loose,
+// a RollsPerBox of zero that its rolls are never boxed.
+type PackingRule struct {
+	RollSize    int `json:"rollSize,omitempty"`
+	RollsPerBox int `json:"rollsPerBox,omitempty"`
+}
+
+// PackingRules overrides the packing of single denominations, keyed by currency code
+// and denomination code. It lets a bank's packing differ from the catalog without
+// repeating the whole catalog in the configuration file.
+type PackingRules map[string]map[string]PackingRule
+
+type PackingRuleValues struct {
+	Denomination string `json:"denomination"`
+	Kind         string `json:"kind"`
+	RollSize     int    `json:"rollSize"`
+	RollsPerBox  int    `json:"rollsPerBox"`
+	RollValue    string `json:"rollValue"`
+	BoxValue     string `json:"boxValue"`
+}
+
+type PackingPayload struct {
+	Currency string              `json:"currency"`
+	Rules    []PackingRuleValues `json:"rules"`
+}
+
+// ApplyPacking replaces the packing of every denomination named in rules.
+// It fails if a rule names a currency or denomination that is not in the catalog.
+func (c *Catalog) ApplyPacking(rules PackingRules) error {
+	for _, currencyCode := range sortedKeys(rules) {
+		currency, ok := c.Currency(currencyCode)
+		if !ok || currencyCode == "" {
+			return fmt.Errorf("packing for unknown currency %q", currencyCode)
+		}
+		for _, code := range sortedKeys(rules[currencyCode]) {
+			d, ok := currency.Denomination(code)
+			if !ok {
+				return fmt.Errorf("packing for unknown denomination %s of %s", code, currencyCode)
+			}
+			d.PackingRule = rules[currencyCode][code]
+		}
+	}
+	return nil
+}
+
+// PackingRulesFor lists the packing rules in effect for every rollable denomination of the
+// currency, in catalog order. Loose-only denominations are left out.
+func PackingRulesFor(currency *Currency) PackingPayload {
+	payload := PackingPayload{Currency: currency.Code, Rules: []PackingRuleValues{}}
+	for _, d := range currency.Denominations {
+		if d.RollSize == 0 {
+			continue
+		}
+		payload.Rules = append(payload.Rules, PackingRuleValues{
+			Denomination: d.Code,
+			Kind:         string(d.Kind),
+			RollSize:     d.RollSize,
+			RollsPerBox:  d.RollsPerBox,
+			RollValue:    FormatNumber(d.RollValue()),
+			BoxValue:     FormatNumber(d.BoxValue()),
+		})
+	}
+	return payload
+}
+
+// handleGETPacking returns the packing rules of the currency named in the "currency" query
+// parameter, or of the default currency if the parameter is missing.
+func (s *Server) handleGETPacking(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("currency")
+	currency, ok := s.config.Catalog.Currency(code)
+	if !ok {
+		respondWithError(w, &ValidationError{Field: "currency", Reason: "unknown currency " + code})
+		return
+	}
+	respondWithJSON(w, PackingRulesFor(currency))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfigPacking(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, `{
+		"packing": {
+			"EUR": {
+				"euro2": {"rollSize": 50, "rollsPerBox": 3},
+				"cent1": {"rollSize": 50, "rollsPerBox": 10}
+			}
+		}
+	}`))
+	assert.NoError(t, err)
+
+	currency, _ := config.Catalog.Currency("EUR")
+	assert.Equal(t, Money(20000), CalculateRollValues(currency, RollValues{"euro2": {1, 1}}))
+	assert.Equal(t, Money(30000), CalculateBoxValues(currency, BoxValues{"euro2": {1}}))
+	assert.Equal(t, Money(500), CalculateBoxValues(currency, BoxValues{"cent1": {1}}))
+	assert.Equal(t, Money(7500), CalculateBoxValues(currency, BoxValues{"euro1": {1}}))
+}
+
+func TestLoadConfigInvalidPacking(t *testing.T) {
+	tests := map[string]string{
+		"unknown currency":     `{"packing": {"USD": {"cent1": {"rollSize": 50}}}}`,
+		"unknown denomination": `{"packing": {"EUR": {"cent3": {"rollSize": 50}}}}`,
+		"rolled note":          `{"packing": {"EUR": {"euro5": {"rollSize": 100}}}}`,
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfig(t, content))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestHandleGETPacking(t *testing.T) {
+	server := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/packing", nil)
+	rec := httptest.NewRecorder()
+	server.routes().ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var payload PackingPayload
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&payload))
+	assert.Equal(t, "EUR", payload.Currency)
+	assert.Len(t, payload.Rules, 8)
+	assert.Equal(t, PackingRuleValues{
+		Denomination: "euro2",
+		Kind:         "coin",
+		RollSize:     25,
+		RollsPerBox:  3,
+		RollValue:    "50,00",
+		BoxValue:     "150,00",
+	}, payload.Rules[0])
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/packing?currency=USD", nil)
+	rec = httptest.NewRecorder()
+	server.routes().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A4 page size in PDF points.
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+)
+
+// pdfText is a line of text on a PDF page. X and Y are in points from the bottom left corner;
+// with Right set, X is where the text ends instead of where it starts.
+type pdfText struct {
+	X, Y  float64
+	Size  float64
+	Bold  bool
+	Right bool
+	Text  string
+}
+
+// pdfRule is a horizontal line from X1 to X2 at height Y.
+type pdfRule struct {
+	X1, X2, Y float64
+}
+
+// pdfPage is the content of a single A4 page.
+type pdfPage struct {
+	Texts []pdfText
+	Rules []pdfRule
+}
+
+// renderPDF writes page as a one-page PDF. It only uses the standard Helvetica fonts every PDF
+// reader has built in, so nothing is embedded and no PDF library is needed.
+func renderPDF(w io.Writer, page pdfPage) error {
+	var content bytes.Buffer
+	for _, rule := range page.Rules {
+		fmt.Fprintf(&content, "0.5 w %.2f %.2f m %.2f %.2f l S\n", rule.X1, rule.Y, rule.X2, rule.Y)
+	}
+	for _, text := range page.Texts {
+		font := "F1"
+		if text.Bold {
+			font = "F2"
+		}
+		x := text.X
+		if text.Right {
+			x -= helveticaWidth(text.Text) * text.Size / 1000
+		}
+		fmt.Fprintf(&content, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, text.Size, x, text.Y, pdfString(text.Text))
+	}
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] "+
+			"/Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents 6 0 R >>", pdfPageWidth, pdfPageHeight),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// pdfString encodes s in WinAnsiEncoding, which is Windows-1252, and escapes it for a PDF
+// string literal. Characters the encoding does not have are replaced with "?".
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, c := range encodeWindows1252(s) {
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c >= 0x20 && c < 0x7f:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, `\%03o`, c)
+		}
+	}
+	return b.String()
+}
+
+// helveticaWidth returns the width of s in thousandths of the font size. It knows the widths
+// of the characters in amounts and numbers and assumes the width of a digit for all others,
+// which is close enough for right-aligning columns.
+func helveticaWidth(s string) float64 {
+	var width float64
+	for _, r := range s {
+		switch r {
+		case ' ', ',', '.', '/':
+			width += 278
+		case '-':
+			width += 333
+		default:
+			width += 556
+		}
+	}
+	return width
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderPDFCrossReference(t *testing.T) {
+	var out bytes.Buffer
+	err := renderPDF(&out, pdfPage{
+		Texts: []pdfText{{X: 56, Y: 780, Size: 12, Text: "Münzen (lose)"}},
+		Rules: []pdfRule{{X1: 56, X2: 539, Y: 770}},
+	})
+	assert.NoError(t, err)
+	data := out.Bytes()
+
+	// every xref entry points at the start of its object
+	matches := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(data, -1)
+	assert.Len(t, matches, 6)
+	for i, match := range matches {
+		offset, _ := strconv.Atoi(string(match[1]))
+		assert.True(t, bytes.HasPrefix(data[offset:], []byte(strconv.Itoa(i+1)+" 0 obj")), "object %d", i+1)
+	}
+
+	startxref := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(data)
+	offset, _ := strconv.Atoi(string(startxref[1]))
+	assert.True(t, bytes.HasPrefix(data[offset:], []byte("xref\n")))
+}
+
+func TestPDFString(t *testing.T) {
+	assert.Equal(t, `M\374nzen \(lose\) \200 \\ ?`, pdfString("Münzen (lose) € \\ ✓"))
+	assert.Equal(t, `\204Kasse\223 \226 \231`, pdfString("„Kasse“ – ™"))
+}
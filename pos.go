@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// POSTotals are the cash totals of one register and business day as exported by the POS.
+// ExpectedValue is the cash the drawer should hold: if the totals were booked into the open
+// session of the register, the expected value of the session, otherwise the float plus the
+// cash sales minus the refunds and payouts. Booked lists the entries the import added to the
+// session.
+type POSTotals struct {
+	ID            uint64         `json:"id"`
+	ImportedAt    time.Time      `json:"importedAt"`
+	By            string         `json:"by,omitempty"`
+	RegisterID    string         `json:"registerId"`
+	StoreID       string         `json:"storeId,omitempty"`
+	Date          string         `json:"date"`
+	Currency      string         `json:"currency"`
+	Float         Money          `json:"float"`
+	CashSales     Money          `json:"cashSales"`
+	Refunds       Money          `json:"refunds"`
+	Payouts       Money          `json:"payouts"`
+	ExpectedValue Money          `json:"expectedValue"`
+	SessionID     uint64         `json:"sessionId,omitempty"`
+	Booked        []SessionEntry `json:"booked,omitempty"`
+}
+
+// POSLine is one register of a POS export. Amounts accept the same formats as a target value;
+// refunds and payouts are given as positive amounts.
+type POSLine struct {
+	RegisterID string `json:"registerId"`
+	Date       string `json:"date,omitempty"`
+	Currency   string `json:"currency,omitempty"`
+	Float      string `json:"float,omitempty"`
+	CashSales  string `json:"cashSales,omitempty"`
+	Refunds    string `json:"refunds,omitempty"`
+	Payouts    string `json:"payouts,omitempty"`
+}
+
+// POSImport is the JSON form of a POS export. Date and Currency apply to the totals that do not
+// name their own.
+type POSImport struct {
+	Date     string    `json:"date,omitempty"`
+	Currency string    `json:"currency,omitempty"`
+	Totals   []POSLine `json:"totals"`
+}
+
+// posColumns maps the lowercased CSV header names to the fields of a POSLine.
+var posColumns = map[string]func(*POSLine) *string{
+	"registerid": func(l *POSLine) *string { return &l.RegisterID },
+	"register":   func(l *POSLine) *string { return &l.RegisterID },
+	"date":       func(l *POSLine) *string { return &l.Date },
+	"currency":   func(l *POSLine) *string { return &l.Currency },
+	"float":      func(l *POSLine) *string { return &l.Float },
+	"cashsales":  func(l *POSLine) *string { return &l.CashSales },
+	"sales":      func(l *POSLine) *string { return &l.CashSales },
+	"refunds":    func(l *POSLine) *string { return &l.Refunds },
+	"payouts":    func(l *POSLine) *string { return &l.Payouts },
+}
+
+// parsePOSCSV reads a CSV export with a header row. The columns are found by their names, so
+// their order does not matter and columns the import does not know are skipped. The delimiter
+// is a semicolon if the header contains one, a comma otherwise.
+func parsePOSCSV(body io.Reader) ([]POSLine, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	header, _, _ := strings.Cut(string(data), "\n")
+	reader := csv.NewReader(strings.NewReader(strings.TrimPrefix(string(data), "\ufeff")))
+	if strings.Contains(header, ";") {
+		reader.Comma = ';'
+	}
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, &ValidationError{Field: "body", Reason: err.Error()}
+	}
+	if len(rows) == 0 {
+		return nil, &ValidationError{Field: "body", Reason: "the export is empty"}
+	}
+
+	fields := make([]func(*POSLine) *string, len(rows[0]))
+	hasRegister := false
+	for i, name := range rows[0] {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		fields[i] = posColumns[name]
+		hasRegister = hasRegister || name == "registerid" || name == "register"
+	}
+	if !hasRegister {
+		return nil, &ValidationError{Field: "registerId", Reason: "the export has no registerId column"}
+	}
+	lines := make([]POSLine, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		var line POSLine
+		for i, value := range row {
+			if fields[i] != nil {
+				*fields[i](&line) = strings.TrimSpace(value)
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// posTotals validates the lines of an import and converts them to totals. Nothing is saved
+// unless every line is valid, and every register and day may occur only once.
+func (s *Server) posTotals(ctx context.Context, lines []POSLine) ([]POSTotals, error) {
+	if len(lines) == 0 {
+		return nil, &ValidationError{Field: "totals", Reason: "the export has no totals"}
+	}
+	identity, bound := identityFrom(ctx)
+	now := time.Now().UTC()
+	seen := make(map[string]bool)
+	totals := make([]POSTotals, 0, len(lines))
+	for i, line := range lines {
+		field := fmt.Sprintf("totals[%d]", i)
+		if line.RegisterID == "" {
+			return nil, &ValidationError{Field: field + ".registerId", Reason: "value is empty"}
+		}
+		if bound && identity.RegisterID != "" && identity.RegisterID != line.RegisterID {
+			return nil, &ValidationError{Field: field + ".registerId", Reason: fmt.Sprintf("the credentials are bound to %q", identity.RegisterID)}
+		}
+		storeID, err := s.store.RegisterStore(line.RegisterID, s.config.Tenancy)
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			return nil, &ValidationError{Field: field + ".registerId", Reason: validationErr.Reason}
+		} else if err != nil {
+			return nil, err
+		}
+		date := now.In(time.Local).Format(time.DateOnly)
+		if line.Date != "" {
+			if _, err := time.Parse(time.DateOnly, line.Date); err != nil {
+				return nil, &ValidationError{Field: field + ".date", Reason: "must be a date (YYYY-MM-DD)"}
+			}
+			date = line.Date
+		}
+		currency, ok := s.config.Catalog.Currency(line.Currency)
+		if !ok {
+			return nil, &ValidationError{Field: field + ".currency", Reason: "unknown currency " + line.Currency}
+		}
+		key := line.RegisterID + " " + date
+		if seen[key] {
+			return nil, &ValidationError{Field: field, Reason: fmt.Sprintf("register %s is imported twice for %s", line.RegisterID, date)}
+		}
+		seen[key] = true
+
+		t := POSTotals{ImportedAt: now, RegisterID: line.RegisterID, StoreID: storeID, Date: date, Currency: currency.Code}
+		if bound {
+			t.By = identity.User()
+		}
+		for _, amount := range []struct {
+			name   string
+			value  string
+			target *Money
+		}{
+			{"float", line.Float, &t.Float},
+			{"cashSales", line.CashSales, &t.CashSales},
+			{"refunds", line.Refunds, &t.Refunds},
+			{"payouts", line.Payouts, &t.Payouts},
+		} {
+			if amount.value == "" {
+				continue
+			}
+			value, err := ParseMoney(amount.value)
+			if err != nil {
+				return nil, &ValidationError{Field: field + "." + amount.name, Reason: err.Error()}
+			}
+			if value < 0 {
+				return nil, &ValidationError{Field: field + "." + amount.name, Reason: "must not be negative"}
+			}
+			*amount.target = value
+		}
+		totals = append(totals, t)
+	}
+	return totals, nil
+}
+
+// LatestPOSTotals returns the last totals imported for the register and business day.
+func (s *Store) LatestPOSTotals(registerID, date string) (POSTotals, bool, error) {
+	var totals POSTotals
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		totals, found, err = latestPOSTotals(tx, registerID, date)
+		return err
+	})
+	return totals, found, err
+}
+
+// latestPOSTotals is LatestPOSTotals within the transaction tx.
+func latestPOSTotals(tx *bolt.Tx, registerID, date string) (POSTotals, bool, error) {
+	list, err := listPOSTotals(tx, registerID, date, 1)
+	if err != nil || len(list) == 0 {
+		return POSTotals{}, false, err
+	}
+	return list[0], true, nil
+}
+
+// SavePOSImport books the totals of one import into the sessions of their registers and stores
+// them under the next free IDs, all in one transaction. The functions in then run last in the
+// transaction; if booking, storing or one of them fails, nothing of the import is stored.
+func (s *Store) SavePOSImport(totals []POSTotals, then ...func(*bolt.Tx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for i := range totals {
+			if err := bookPOSTotals(tx, &totals[i]); err != nil {
+				return err
+			}
+			bucket := tx.Bucket(posTotalsBucket)
+			id, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			totals[i].ID = id
+			if err := putJSON(bucket, itob(id), totals[i]); err != nil {
+				return err
+			}
+		}
+		return runAll(tx, then)
+	})
+}
+
+// ListPOSTotals returns the imported totals, newest first. Empty arguments do not filter.
+func (s *Store) ListPOSTotals(registerID, date string) ([]POSTotals, error) {
+	var list []POSTotals
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		list, err = listPOSTotals(tx, registerID, date, 0)
+		return err
+	})
+	return list, err
+}
+
+// listPOSTotals is ListPOSTotals within the transaction tx, returning at most limit totals
+// unless limit is 0.
+func listPOSTotals(tx *bolt.Tx, registerID, date string, limit int) ([]POSTotals, error) {
+	list := []POSTotals{}
+	cursor := tx.Bucket(posTotalsBucket).Cursor()
+	for key, data := cursor.Last(); key != nil && (limit == 0 || len(list) < limit); key, data = cursor.Prev() {
+		var totals POSTotals
+		if err := json.Unmarshal(data, &totals); err != nil {
+			return nil, fmt.Errorf("pos totals %d: %w", btoi(key), err)
+		}
+		if (registerID == "" || totals.RegisterID == registerID) && (date == "" || totals.Date == date) {
+			list = append(list, totals)
+		}
+	}
+	return list, nil
+}
+
+// bookPOSTotals books the totals into the open session of their register within the
+// transaction tx and sets the expected value. Totals are only booked into a session in their
+// currency that was opened on their business day; otherwise they are stored without booking.
+// The totals of a POS are running totals, so only what changed since the last import into the
+// same session is booked: the cash sales as a sale, refunds and payouts as negative sales.
+func bookPOSTotals(tx *bolt.Tx, totals *POSTotals) error {
+	totals.ExpectedValue = totals.Float + totals.CashSales - totals.Refunds - totals.Payouts
+	session, found, err := openSession(tx, totals.RegisterID)
+	if err != nil || !found || session.Currency != totals.Currency || session.businessDay() != totals.Date {
+		return err
+	}
+
+	var booked POSTotals
+	previous, found, err := latestPOSTotals(tx, totals.RegisterID, totals.Date)
+	if err != nil {
+		return err
+	}
+	if found && previous.SessionID == session.ID {
+		booked = previous
+	}
+	totals.SessionID = session.ID
+	for _, change := range []struct {
+		amount Money
+		what   string
+	}{
+		{totals.CashSales - booked.CashSales, "cash sales"},
+		{booked.Refunds - totals.Refunds, "refunds"},
+		{booked.Payouts - totals.Payouts, "payouts"},
+	} {
+		if change.amount != 0 {
+			totals.Booked = append(totals.Booked, SessionEntry{Type: EntrySale, Amount: change.amount,
+				Reference: "POS " + totals.Date + " " + change.what, By: totals.By, At: totals.ImportedAt})
+		}
+	}
+	session, err = updateSession(tx, session.ID, func(session *Session) error {
+		for _, entry := range totals.Booked {
+			if err := session.book(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	totals.ExpectedValue = session.ExpectedValue
+	return err
+}
+
+// importedTarget returns the expected value imported from the POS for the register today, as a
+// target value, or "" if nothing was imported in the currency. Totals booked into a session
+// give the current expected value of the session, which also accounts for later drops. A
+// register counting blind gets no target at all: its cashier counts through the session, so
+// the attempt is recorded and the expected value stays hidden.
+func (s *Server) importedTarget(ctx context.Context, registerID, code string) (string, error) {
+	if identity, ok := identityFrom(ctx); ok && registerID == "" {
+		registerID = identity.RegisterID
+	}
+	currency, ok := s.config.Catalog.Currency(code)
+	if registerID == "" || !ok {
+		return "", nil
+	}
+	if session, err := s.store.OpenSession(registerID); err == nil && session.Blind {
+		return "", errBlindTarget(&session)
+	} else if err != nil && !errors.Is(err, ErrNotFound) {
+		return "", err
+	}
+	totals, found, err := s.store.LatestPOSTotals(registerID, time.Now().Format(time.DateOnly))
+	if err != nil || !found || totals.Currency != currency.Code {
+		return "", err
+	}
+	if totals.SessionID == 0 {
+		return totals.ExpectedValue.String(), nil
+	}
+	session, err := s.store.Session(totals.SessionID)
+	if err != nil {
+		return "", err
+	}
+	if session.Blind {
+		return "", errBlindTarget(&session)
+	}
+	return session.ExpectedValue.String(), nil
+}
+
+// errBlindTarget returns the ValidationError for a count that would take its target from the
+// blind session.
+func errBlindTarget(session *Session) error {
+	return &ValidationError{Field: "targetValue", Reason: fmt.Sprintf("register %s counts blind, count through /api/v1/sessions/%d/counts", session.RegisterID, session.ID)}
+}
+
+// handleImportPOS imports a POS export with the cash totals per register, as CSV if the content
+// type is text/csv and as a POSImport otherwise. Every line is validated first; then all totals
+// are booked into the open sessions of their registers, stored and appended to the audit
+// journal in one transaction. The response lists the saved totals.
+func (s *Server) handleImportPOS(w http.ResponseWriter, r *http.Request) {
+	var lines []POSLine
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "text/csv" {
+		var err error
+		if lines, err = parsePOSCSV(r.Body); err != nil {
+			respondWithError(w, err)
+			return
+		}
+	} else {
+		var request POSImport
+		if err := decodeStrict(r, &request); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		for _, line := range request.Totals {
+			if line.Date == "" {
+				line.Date = request.Date
+			}
+			if line.Currency == "" {
+				line.Currency = request.Currency
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	totals, err := s.posTotals(r.Context(), lines)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	journal := func(*bolt.Tx) error {
+		for _, t := range totals {
+			if _, err := s.journal.Append(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := s.store.SavePOSImport(totals, journal); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, totals)
+}
+
+// handleListPOSImports returns the imported totals, newest first. The query parameters
+// registerId and date narrow the list; callers bound to a register only see the totals of that
+// register.
+func (s *Server) handleListPOSImports(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	registerID, err := boundRegister(r.Context(), query.Get("registerId"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	date := query.Get("date")
+	if date != "" {
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			respondWithError(w, &ValidationError{Field: "date", Reason: "must be a date (YYYY-MM-DD)"})
+			return
+		}
+	}
+	totals, err := s.store.ListPOSTotals(registerID, date)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, totals)
+}
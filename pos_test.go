@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveCSV posts body to target as text/csv.
+func serveCSV(handler http.Handler, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv; charset=utf-8")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// differenceValue returns the difference of a calculate response.
+func differenceValue(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var response ResponsePayload
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+	return response.ResponseValues.DifferenceValue
+}
+
+func TestImportPOSWithoutSession(t *testing.T) {
+	handler := newTestServer(t).routes()
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/imports/pos",
+		`{"totals":[{"registerId":"R1","float":"150,00","cashSales":"1.234,50","refunds":"12,00","payouts":"30,00"}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var totals []POSTotals
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&totals))
+	assert.Len(t, totals, 1)
+	assert.Equal(t, time.Now().Format(time.DateOnly), totals[0].Date)
+	assert.Equal(t, "EUR", totals[0].Currency)
+	assert.Equal(t, Money(134250), totals[0].ExpectedValue)
+	assert.Zero(t, totals[0].SessionID)
+
+	// without a target value the imported one is used
+	rec = serveJSON(handler, http.MethodPost, "/api/v2/calculate",
+		`{"registerId":"R1","counts":[{"denomination":"euro50","form":"loose","quantity":20}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "-342,50", differenceValue(t, rec))
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/calculate",
+		`{"registerId":"R1","requestValues":{"euro200":[5,1,0,0,0]},"payloadType":1}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "-142,50", differenceValue(t, rec))
+
+	// a given target value wins, and registers without an import still need one
+	rec = serveJSON(handler, http.MethodPost, "/api/v2/calculate",
+		`{"registerId":"R1","targetValue":"1000,00","counts":[{"denomination":"euro50","form":"loose","quantity":20}]}`)
+	assert.Equal(t, "0,00", differenceValue(t, rec))
+	rec = serveJSON(handler, http.MethodPost, "/api/v2/calculate",
+		`{"registerId":"R2","counts":[{"denomination":"euro50","form":"loose","quantity":20}]}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"field":"targetValue"`)
+}
+
+func TestImportPOSBooksIntoSession(t *testing.T) {
+	handler := newTestServer(t).routes()
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","expectedValue":"100,00"}`)
+	today := time.Now().Format(time.DateOnly)
+
+	rec := serveCSV(handler, "/api/v1/imports/pos",
+		"registerId;date;cashSales;refunds;payouts;tips\nR1;"+today+";500,00;20,00;;5,00\n")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var totals []POSTotals
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&totals))
+	assert.Equal(t, uint64(1), totals[0].SessionID)
+	assert.Equal(t, Money(58000), totals[0].ExpectedValue)
+
+	// the totals of the POS are running totals, only the change is booked again
+	rec = serveCSV(handler, "/api/v1/imports/pos",
+		"Register,Sales,Refunds,Payouts\nR1,\"700,00\",\"20,00\",\"50,00\"\n")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	session := decodeSession(t, serveJSON(handler, http.MethodGet, "/api/v1/sessions/1", ""))
+	assert.Equal(t, Money(73000), *session.ExpectedValue)
+	assert.Equal(t, Money(63000), *session.Sales)
+	assert.Len(t, session.Entries, 4)
+	assert.Equal(t, "POS "+today+" payouts", session.Entries[3].Reference)
+	assert.Equal(t, Money(-5000), session.Entries[3].Amount)
+
+	// the target follows the session, drops included
+	serveJSON(handler, http.MethodPost, "/api/v1/registers/R1/drops", `{"counts":[{"denomination":"euro100","form":"loose","quantity":1}]}`)
+	rec = serveJSON(handler, http.MethodPost, "/api/v2/calculate",
+		`{"registerId":"R1","counts":[{"denomination":"euro200","form":"loose","quantity":3}]}`)
+	assert.Equal(t, "-30,00", differenceValue(t, rec))
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/imports/pos?registerId=R1&date="+today, "")
+	totals = nil
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&totals))
+	assert.Len(t, totals, 2)
+	assert.Equal(t, Money(70000), totals[0].CashSales)
+}
+
+func TestImportPOSErrors(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.routes()
+	for body, field := range map[string]string{
+		`{"totals":[]}`:                                                            "totals",
+		`{"totals":[{"cashSales":"1,00"}]}`:                                        "totals[0].registerId",
+		`{"totals":[{"registerId":"R1","date":"17.10.2026"}]}`:                     "totals[0].date",
+		`{"totals":[{"registerId":"R1","refunds":"-5,00"}]}`:                       "totals[0].refunds",
+		`{"totals":[{"registerId":"R1","cashSales":"abc"}]}`:                       "totals[0].cashSales",
+		`{"currency":"XXX","totals":[{"registerId":"R1"}]}`:                        "totals[0].currency",
+		`{"date":"2026-10-17","totals":[{"registerId":"R1"},{"registerId":"R1"}]}`: "totals[1]",
+	} {
+		rec := serveJSON(handler, http.MethodPost, "/api/v1/imports/pos", body)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, body)
+		assert.Contains(t, rec.Body.String(), `"field":"`+field+`"`, body)
+	}
+	rec := serveCSV(handler, "/api/v1/imports/pos", "kasse;umsatz\nR1;5,00\n")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"field":"registerId"`)
+
+	serveJSON(handler, http.MethodPost, "/api/v1/stores", `{"id":"berlin-1","name":"Berlin"}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/registers", `{"id":"R1","storeId":"berlin-1"}`)
+	server.config.Tenancy.Enabled = true
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/imports/pos", `{"totals":[{"registerId":"R1"},{"registerId":"R9"}]}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"field":"totals[1].registerId"`)
+
+	// nothing of a rejected import is saved
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/imports/pos", "")
+	assert.JSONEq(t, `[]`, rec.Body.String())
+}
+
+func TestImportPOSMatchesBusinessDay(t *testing.T) {
+	handler := newTestServer(t).routes()
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","expectedValue":"100,00"}`)
+	yesterday := time.Now().AddDate(0, 0, -1).Format(time.DateOnly)
+
+	// the export of another day is stored, but not booked into today's session
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/imports/pos", `{"date":"`+yesterday+`","totals":[{"registerId":"R1","cashSales":"500,00"}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var totals []POSTotals
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&totals))
+	assert.Zero(t, totals[0].SessionID)
+	assert.Empty(t, totals[0].Booked)
+	assert.Equal(t, Money(50000), totals[0].ExpectedValue)
+	session := decodeSession(t, serveJSON(handler, http.MethodGet, "/api/v1/sessions/1", ""))
+	assert.Equal(t, Money(10000), *session.ExpectedValue)
+	assert.Empty(t, session.Entries)
+}
+
+func TestImportPOSRollsBack(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.routes()
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","expectedValue":"100,00"}`)
+	assert.NoError(t, server.journal.Close())
+
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/imports/pos",
+		`{"totals":[{"registerId":"R1","cashSales":"500,00"},{"registerId":"R2","cashSales":"20,00"}]}`)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	totals, err := server.store.ListPOSTotals("", "")
+	assert.NoError(t, err)
+	assert.Empty(t, totals)
+	session, err := server.store.Session(1)
+	assert.NoError(t, err)
+	assert.Equal(t, Money(10000), session.ExpectedValue)
+	assert.Empty(t, session.Entries)
+}
+
+func TestImportPOSGivesNoBlindTarget(t *testing.T) {
+	handler := newTestServer(t).routes()
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","blind":true,"expectedValue":"100,00"}`)
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/imports/pos", `{"totals":[{"registerId":"R1","cashSales":"500,00"}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// the cashier counts through the session, which records the attempt
+	rec = serveJSON(handler, http.MethodPost, "/api/v2/calculate",
+		`{"registerId":"R1","counts":[{"denomination":"euro200","form":"loose","quantity":3}]}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"field":"targetValue"`)
+	assert.NotContains(t, rec.Body.String(), "600")
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/counts", `{"counts":[{"denomination":"euro200","form":"loose","quantity":3}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "0,00", differenceValue(t, rec))
+}
+
+func TestPOSImportsOfOtherRegister(t *testing.T) {
+	handler := newRoleServer(t).routes()
+	rec := serveAuth(handler, http.MethodPost, "/api/v1/imports/pos",
+		`{"totals":[{"registerId":"R1","cashSales":"5,00"},{"registerId":"R2","cashSales":"7,00"}]}`, "X-API-Key", roleKeys["manager"])
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serveAuth(handler, http.MethodGet, "/api/v1/imports/pos", "", "X-API-Key", roleKeys["lead"])
+	var totals []POSTotals
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&totals))
+	if assert.Len(t, totals, 1) {
+		assert.Equal(t, "R1", totals[0].RegisterID)
+	}
+	rec = serveAuth(handler, http.MethodGet, "/api/v1/imports/pos?registerId=R2", "", "X-API-Key", roleKeys["lead"])
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestRegisterWithPOSImportStays(t *testing.T) {
+	handler := newTestServer(t).routes()
+	serveJSON(handler, http.MethodPost, "/api/v1/stores", `{"id":"berlin-1","name":"Berlin"}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/registers", `{"id":"R1","storeId":"berlin-1"}`)
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/imports/pos", `{"totals":[{"registerId":"R1","cashSales":"5,00"}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serveJSON(handler, http.MethodDelete, "/api/v1/registers/R1", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), "has pos imports")
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+// Role is what a user may do, given by the API key or the "roles" claim of a token.
+type Role string
+
+const (
+	// RoleCashier submits counts.
+	RoleCashier Role = "cashier"
+	// RoleShiftLead also opens sessions, approves and rejects counts and reads the reports.
+	RoleShiftLead Role = "shiftLead"
+	// RoleManager may do everything, including changing the tolerance rules.
+	RoleManager Role = "manager"
+	// RoleAuditor reads everything, including the audit journal, and changes nothing.
+	RoleAuditor Role = "auditor"
+)
+
+// Validate returns an error if r is not one of the known roles.
+func (r Role) Validate() error {
+	switch r {
+	case RoleCashier, RoleShiftLead, RoleManager, RoleAuditor:
+		return nil
+	}
+	return fmt.Errorf("unknown role %q", r)
+}
+
+var (
+	counting = []Role{RoleCashier, RoleShiftLead, RoleManager}
+	leading  = []Role{RoleShiftLead, RoleManager}
+	reading  = []Role{RoleShiftLead, RoleManager, RoleAuditor}
+	everyone = []Role{RoleCashier, RoleShiftLead, RoleManager, RoleAuditor}
+	auditing = []Role{RoleManager, RoleAuditor}
+)
+
+// policies maps every route, as registered with the router, to the roles allowed to use it.
+// A route without an entry cannot be registered, and a user without one of the roles is denied.
+var policies = map[string][]Role{
+	"/api/v1/calculate":                               counting,
+	"POST /api/v2/calculate":                          counting,
+	"GET /api/v1/packing":                             everyone,
+	"GET /api/v1/counts":                              reading,
+	"GET /api/v1/counts/{id}":                         reading,
+	"POST /api/v1/counts/{id}/approve":                leading,
+	"POST /api/v1/counts/{id}/reject":                 leading,
+	"POST /api/v1/counts/{id}/recount":                counting,
+	"GET /api/v1/registers/{registerId}/z-report":     reading,
+	"GET /api/v1/registers/{registerId}/change-order": reading,
+	"POST /api/v1/registers/{registerId}/drops":       counting,
+	"POST /api/v1/sessions":                           leading,
+	"GET /api/v1/sessions/{id}":                       everyone,
+	"PUT /api/v1/sessions/{id}":                       leading,
+	"DELETE /api/v1/sessions/{id}":                    leading,
+	"POST /api/v1/sessions/{id}/counts":               counting,
+	"POST /api/v1/sessions/{id}/handover":             counting,
+	"POST /api/v1/sessions/{id}/sales":                leading,
+	"POST /api/v1/sessions/{id}/skims":                counting,
+	"POST /api/v1/float-plan":                         counting,
+	"POST /api/v1/deposit-slips":                      counting,
+	"GET /api/v1/deposit-slips/{number}":              everyone,
+	"GET /api/v1/tolerance-rules":                     reading,
+	"PUT /api/v1/tolerance-rules":                     {RoleManager},
+	"GET /api/v1/journal":                             auditing,
+	"GET /api/v1/journal/verify":                      auditing,
+	"POST /api/v1/stores":                             {RoleManager},
+	"GET /api/v1/stores":                              reading,
+	"GET /api/v1/stores/{storeId}":                    reading,
+	"PUT /api/v1/stores/{storeId}":                    {RoleManager},
+	"DELETE /api/v1/stores/{storeId}":                 {RoleManager},
+	"POST /api/v1/registers":                          {RoleManager},
+	"GET /api/v1/registers":                           reading,
+	"GET /api/v1/registers/{registerId}":              everyone,
+	"PUT /api/v1/registers/{registerId}":              {RoleManager},
+	"DELETE /api/v1/registers/{registerId}":           {RoleManager},
+	"GET /api/v1/sessions":                            reading,
+	"GET /api/v1/reports/summary":                     auditing,
+	"GET /api/v1/safes/{safeId}":                      reading,
+	"POST /api/v1/safes/{safeId}/counts":              leading,
+	"GET /api/v1/safes/{safeId}/counts":               reading,
+	"POST /api/v1/safes/{safeId}/movements":           leading,
+	"GET /api/v1/safes/{safeId}/movements":            reading,
+	"POST /api/v1/imports/pos":                        leading,
+	"GET /api/v1/imports/pos":                         reading,
+	"GET /api/v1/exports/counts":                      auditing,
+	"GET /api/v1/exports/datev":                       auditing,
+	"GET /api/v1/cash-book":                           reading,
+	"GET /api/v1/cash-book/verify":                    auditing,
+	"POST /api/v1/cash-book/{number}/reverse":         {RoleManager},
+}
+
+// handle registers handler for pattern behind the access check of its policy. It panics if the
+// pattern has no policy, so a new route cannot be left open by mistake.
+func (s *Server) handle(mux *http.ServeMux, pattern string, handler http.HandlerFunc) {
+	roles, ok := policies[pattern]
+	if !ok {
+		panic("no access policy for route " + pattern)
+	}
+	mux.HandleFunc(pattern, s.authorize(roles, handler))
+}
+
+// authorize lets a request through if the caller has one of the roles. Callers bound to a
+// register are also limited to the paths of that register. A request without a caller is only
+// let through if authentication is disabled in the configuration; otherwise access is denied.
+func (s *Server) authorize(roles []Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := identityFrom(r.Context())
+		if !ok {
+			if !s.config.Auth.Disabled {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="register-api"`)
+				respondWithError(w, fmt.Errorf("%w: no credentials are configured", ErrUnauthorized))
+				return
+			}
+			next(w, r)
+			return
+		}
+		if !slices.ContainsFunc(identity.Roles, func(role Role) bool { return slices.Contains(roles, role) }) {
+			respondWithError(w, fmt.Errorf("%w: %s may not %s %s", ErrForbidden, identity.Name, r.Method, r.URL.Path))
+			return
+		}
+		if register := r.PathValue("registerId"); register != "" {
+			if err := checkRegister(r.Context(), register); err != nil {
+				respondWithError(w, err)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// checkRegister returns ErrForbidden if the caller is bound to a register other than
+// registerID.
+func checkRegister(ctx context.Context, registerID string) error {
+	identity, ok := identityFrom(ctx)
+	if ok && identity.RegisterID != "" && registerID != identity.RegisterID {
+		return fmt.Errorf("%w: %s is bound to register %s", ErrForbidden, identity.Name, identity.RegisterID)
+	}
+	return nil
+}
+
+// boundRegister returns the register a list is limited to: registerID, or the register the
+// caller is bound to if registerID is empty. Asking for another register is forbidden.
+func boundRegister(ctx context.Context, registerID string) (string, error) {
+	if registerID != "" {
+		return registerID, checkRegister(ctx, registerID)
+	}
+	if identity, ok := identityFrom(ctx); ok {
+		return identity.RegisterID, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// roleKeys are the API keys of newRoleServer by name.
+var roleKeys = map[string]string{
+	"cashier": "cashier-key-0000000",
+	"lead":    "lead-key-00000000000",
+	"manager": "manager-key-0000000",
+	"auditor": "auditor-key-0000000",
+	"nobody":  "nobody-key-00000000",
+}
+
+// newRoleServer returns a test server with an API key per role, named after the role. The
+// shift lead is bound to register R1 and nobody has no role at all.
+func newRoleServer(t *testing.T) *Server {
+	t.Helper()
+	server := newTestServer(t)
+	server.config.Auth = AuthConfig{APIKeys: []APIKey{
+		{Name: "cashier", Key: roleKeys["cashier"], Roles: []Role{RoleCashier}},
+		{Name: "lead", Key: roleKeys["lead"], RegisterID: "R1", Roles: []Role{RoleShiftLead}},
+		{Name: "manager", Key: roleKeys["manager"], Roles: []Role{RoleManager}},
+		{Name: "auditor", Key: roleKeys["auditor"], Roles: []Role{RoleAuditor}},
+		{Name: "nobody", Key: roleKeys["nobody"]},
+	}}
+	assert.NoError(t, server.config.Auth.Validate())
+	return server
+}
+
+func TestPoliciesGiveAuditorsReadOnlyAccess(t *testing.T) {
+	for pattern, roles := range policies {
+		read := strings.HasPrefix(pattern, http.MethodGet+" ")
+		assert.Equal(t, read, slices.Contains(roles, RoleAuditor), pattern)
+	}
+}
+
+func TestRouteWithoutPolicyPanics(t *testing.T) {
+	server := newTestServer(t)
+	assert.Panics(t, func() {
+		server.handle(http.NewServeMux(), "DELETE /api/v1/counts/{id}", server.handleGetCount)
+	})
+}
+
+func TestRoleAccess(t *testing.T) {
+	handler := newRoleServer(t).routes()
+	count := `{"targetValue":"0","counts":[]}`
+
+	tests := []struct {
+		key    string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{"cashier", http.MethodPost, "/api/v2/calculate", count, http.StatusOK},
+		{"cashier", http.MethodGet, "/api/v1/counts", "", http.StatusForbidden},
+		{"cashier", http.MethodPost, "/api/v1/counts/1/approve", `{}`, http.StatusForbidden},
+		{"nobody", http.MethodGet, "/api/v1/packing", "", http.StatusForbidden},
+		{"lead", http.MethodGet, "/api/v1/counts", "", http.StatusOK},
+		{"lead", http.MethodGet, "/api/v1/registers/R2/z-report", "", http.StatusForbidden},
+		{"lead", http.MethodGet, "/api/v1/registers/R1/z-report", "", http.StatusNotFound},
+		{"lead", http.MethodPut, "/api/v1/tolerance-rules", `[]`, http.StatusForbidden},
+		{"lead", http.MethodGet, "/api/v1/journal", "", http.StatusForbidden},
+		{"manager", http.MethodGet, "/api/v1/registers/R2/z-report", "", http.StatusNotFound},
+		{"manager", http.MethodGet, "/api/v1/tolerance-rules", "", http.StatusOK},
+		{"auditor", http.MethodGet, "/api/v1/counts", "", http.StatusOK},
+		{"auditor", http.MethodGet, "/api/v1/journal", "", http.StatusOK},
+		{"auditor", http.MethodGet, "/api/v1/journal/verify", "", http.StatusOK},
+		{"auditor", http.MethodPost, "/api/v2/calculate", count, http.StatusForbidden},
+		{"auditor", http.MethodPost, "/api/v1/calculate", `{}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		rec := serveAuth(handler, tt.method, tt.target, tt.body, "X-API-Key", roleKeys[tt.key])
+		assert.Equal(t, tt.status, rec.Code, "%s %s %s", tt.key, tt.method, tt.target)
+	}
+}
+
+func TestDeniedWithoutCredentials(t *testing.T) {
+	server := newTestServer(t)
+	server.config.Auth = AuthConfig{}
+	rec := serveJSON(server.routes(), http.MethodGet, "/api/v1/packing", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	server.config.Auth = AuthConfig{Disabled: true, APIKeys: []APIKey{{Name: "kiosk", Key: roleKeys["cashier"]}}}
+	assert.Error(t, server.config.Auth.Validate())
+}
+
+func TestSessionOfOtherRegister(t *testing.T) {
+	handler := newRoleServer(t).routes()
+	for _, register := range []string{"R1", "R2"} {
+		rec := serveAuth(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"`+register+`","expectedValue":"0"}`, "X-API-Key", roleKeys["manager"])
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	rec := serveAuth(handler, http.MethodGet, "/api/v1/sessions/1", "", "X-API-Key", roleKeys["lead"])
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serveAuth(handler, http.MethodGet, "/api/v1/sessions/2", "", "X-API-Key", roleKeys["lead"])
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = serveAuth(handler, http.MethodPut, "/api/v1/sessions/2", `{"expectedValue":"1"}`, "X-API-Key", roleKeys["lead"])
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = serveAuth(handler, http.MethodDelete, "/api/v1/sessions/2", "", "X-API-Key", roleKeys["lead"])
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = serveAuth(handler, http.MethodPost, "/api/v1/sessions/1/sales", `{"amount":"1"}`, "X-API-Key", roleKeys["cashier"])
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = serveAuth(handler, http.MethodPost, "/api/v1/sessions/1/sales", `{"amount":"1"}`, "X-API-Key", roleKeys["lead"])
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serveAuth(handler, http.MethodPost, "/api/v1/safes/main/movements",
+		`{"type":"toRegister","registerId":"R2","counts":[{"denomination":"euro50","form":"loose","quantity":1}]}`, "X-API-Key", roleKeys["lead"])
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = serveAuth(handler, http.MethodGet, "/api/v1/sessions", "", "X-API-Key", roleKeys["lead"])
+	var sessions []SessionPayload
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&sessions))
+	if assert.Len(t, sessions, 1) {
+		assert.Equal(t, "R1", sessions[0].RegisterID)
+	}
+	rec = serveAuth(handler, http.MethodGet, "/api/v1/sessions?registerId=R2", "", "X-API-Key", roleKeys["lead"])
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestCountsOfOtherRegister(t *testing.T) {
+	handler := newRoleServer(t).routes()
+	for _, register := range []string{"R1", "R2"} {
+		rec := serveAuth(handler, http.MethodPost, "/api/v2/calculate",
+			`{"registerId":"`+register+`","targetValue":"10,00","counts":[{"denomination":"euro10","form":"loose","quantity":1}]}`,
+			"X-API-Key", roleKeys["manager"])
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	rec := serveAuth(handler, http.MethodPost, "/api/v1/deposit-slips", `{"countId":2,"branch":"Mitte"}`, "X-API-Key", roleKeys["manager"])
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serveAuth(handler, http.MethodGet, "/api/v1/counts", "", "X-API-Key", roleKeys["lead"])
+	var records []CountRecord
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&records))
+	assert.Equal(t, []uint64{1}, recordIDs(records))
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+	}{
+		{http.MethodGet, "/api/v1/counts?registerId=R2", ""},
+		{http.MethodGet, "/api/v1/counts/2", ""},
+		{http.MethodPost, "/api/v1/counts/2/approve", `{}`},
+		{http.MethodPost, "/api/v1/counts/2/reject", `{}`},
+		{http.MethodPost, "/api/v1/deposit-slips", `{"countId":2,"branch":"Mitte"}`},
+		{http.MethodGet, "/api/v1/deposit-slips/1", ""},
+	}
+	for _, tt := range tests {
+		rec = serveAuth(handler, tt.method, tt.target, tt.body, "X-API-Key", roleKeys["lead"])
+		assert.Equal(t, http.StatusForbidden, rec.Code, "%s %s", tt.method, tt.target)
+	}
+	rec = serveAuth(handler, http.MethodGet, "/api/v1/counts/1", "", "X-API-Key", roleKeys["lead"])
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestPutToleranceRules(t *testing.T) {
+	server := newRoleServer(t)
+	handler := server.routes()
+
+	rec := serveAuth(handler, http.MethodPut, "/api/v1/tolerance-rules",
+		`[{"name":"exact","severity":"ok","maxAbsolute":0},{"name":"rest","severity":"warning"}]`, "X-API-Key", roleKeys["manager"])
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serveAuth(handler, http.MethodPost, "/api/v2/calculate", `{"targetValue":"0,01","counts":[]}`, "X-API-Key", roleKeys["cashier"])
+	assert.JSONEq(t, `{"severity":"warning","rule":"rest"}`, jsonField(t, rec.Body.Bytes(), "classification"))
+
+	rules, ok, err := server.store.ToleranceRules()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Len(t, rules, 2)
+
+	entries, err := server.journal.Entries(0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.JSONEq(t, `"manager"`, jsonField(t, entries[0].Payload, "by"))
+
+	rec = serveAuth(handler, http.MethodPut, "/api/v1/tolerance-rules", `[{"name":"x","severity":"bad"}]`, "X-API-Key", roleKeys["manager"])
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Len(t, server.toleranceRules(), 2)
+
+	// rules that cannot be journalled are not stored
+	assert.NoError(t, server.journal.Close())
+	rec = serveAuth(handler, http.MethodPut, "/api/v1/tolerance-rules", `[]`, "X-API-Key", roleKeys["manager"])
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	rules, _, err = server.store.ToleranceRules()
+	assert.NoError(t, err)
+	assert.Len(t, rules, 2)
+	assert.Len(t, server.toleranceRules(), 2)
+}
+
+func TestJournalEndpoints(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.routes()
+	for range 3 {
+		serveJSON(handler, http.MethodPost, "/api/v2/calculate", `{"targetValue":"0","counts":[]}`)
+	}
+
+	rec := serveJSON(handler, http.MethodGet, "/api/v1/journal?after=1&limit=1", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var entries []JournalEntry
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&entries))
+	assert.Len(t, entries, 1)
+	assert.Equal(t, uint64(2), entries[0].Sequence)
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/journal?limit=0", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/journal/verify", "")
+	var result JournalVerification
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+	assert.True(t, result.Intact)
+	assert.Equal(t, uint64(3), result.Entries)
+	assert.Nil(t, result.Error)
+}
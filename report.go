@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ReportLine is the closing count of one denomination, split by form.
+type ReportLine struct {
+	Denomination  string           `json:"denomination"`
+	Kind          DenominationKind `json:"kind"`
+	LooseQuantity int              `json:"looseQuantity"`
+	RollQuantity  int              `json:"rollQuantity"`
+	BoxQuantity   int              `json:"boxQuantity"`
+	TotalValue    Money            `json:"totalValue"`
+}
+
+// ZReport is the end-of-day closing report of a register. All amounts are in cents.
+// Closed is false while the register has no closing count for the day; the final
+// count, target, difference and denomination lines are empty then. Drops are the cash taken
+// out of the drawer during the day, DropValue their sum.
+type ZReport struct {
+	RegisterID      string        `json:"registerId"`
+	Date            string        `json:"date"`
+	Currency        string        `json:"currency"`
+	Closed          bool          `json:"closed"`
+	OpeningFloat    Money         `json:"openingFloat"`
+	OpeningCount    *CountRecord  `json:"openingCount"`
+	InterimCounts   []CountRecord `json:"interimCounts"`
+	Drops           []CountRecord `json:"drops"`
+	DropValue       Money         `json:"dropValue"`
+	FinalCount      *CountRecord  `json:"finalCount"`
+	TargetValue     Money         `json:"targetValue"`
+	DifferenceValue Money         `json:"differenceValue"`
+	Denominations   []ReportLine  `json:"denominations"`
+}
+
+// BuildZReport builds the closing report from the counts of a register on one day, given in
+// the order they were saved. The latest opening and closing counts are used, so a recount
+// replaces the earlier count of the same kind; a replaced closing count is listed with the
+// interim counts, as are rejected and superseded ones. The difference adds back the drops the
+// target of the final count does not account for: those outside the session of the final count,
+// whose expected value already has its drops subtracted. It returns ErrNotFound if there are no
+// counts.
+func BuildZReport(catalog *Catalog, registerID string, date time.Time, records []CountRecord) (ZReport, error) {
+	report := ZReport{
+		RegisterID:    registerID,
+		Date:          date.Format(time.DateOnly),
+		InterimCounts: []CountRecord{},
+		Drops:         []CountRecord{},
+		Denominations: []ReportLine{},
+	}
+	if len(records) == 0 {
+		return report, fmt.Errorf("counts of register %s on %s: %w", registerID, report.Date, ErrNotFound)
+	}
+
+	for i := range records {
+		record := &records[i]
+		switch {
+		case record.Kind == CountOpening:
+			report.OpeningCount = record
+			report.OpeningFloat = record.TotalValue
+		case record.Kind == CountClosing && record.Status != StatusRejected && record.Status != StatusSuperseded:
+			if report.FinalCount != nil {
+				report.InterimCounts = append(report.InterimCounts, *report.FinalCount)
+			}
+			report.FinalCount = record
+		case record.Kind == CountDrop:
+			report.Drops = append(report.Drops, *record)
+			report.DropValue += record.TotalValue
+		default:
+			report.InterimCounts = append(report.InterimCounts, *record)
+		}
+		report.Currency = record.Currency
+	}
+
+	if report.FinalCount == nil {
+		return report, nil
+	}
+	report.Closed = true
+	report.Currency = report.FinalCount.Currency
+	report.TargetValue = report.FinalCount.TargetValue
+	report.DifferenceValue = report.FinalCount.DifferenceValue
+	for _, drop := range report.Drops {
+		if report.FinalCount.SessionID == 0 || drop.SessionID != report.FinalCount.SessionID {
+			report.DifferenceValue += drop.TotalValue
+		}
+	}
+
+	currency, ok := catalog.Currency(report.Currency)
+	if !ok {
+		return report, fmt.Errorf("count %d uses currency %s which is no longer configured", report.FinalCount.ID, report.Currency)
+	}
+	report.Denominations = reportLines(currency, report.FinalCount.Counts)
+	return report, nil
+}
+
+// reportLines sums counts per denomination and form. Denominations without any count are left out.
+func reportLines(currency *Currency, counts []Count) []ReportLine {
+	lines := []ReportLine{}
+	for _, d := range currency.Denominations {
+		line := ReportLine{Denomination: d.Code, Kind: d.Kind}
+		for _, c := range counts {
+			if c.Denomination != d.Code {
+				continue
+			}
+			switch c.Form {
+			case FormRoll:
+				line.RollQuantity += c.Quantity
+			case FormBox:
+				line.BoxQuantity += c.Quantity
+			default:
+				line.LooseQuantity += c.Quantity
+			}
+			line.TotalValue += CountValue(&d, c.Form, c.Quantity)
+		}
+		if line.LooseQuantity+line.RollQuantity+line.BoxQuantity > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// handleZReport returns the closing report of the register in the path for the day given in the
+// "date" query parameter, today if it is missing. With "format=html" the report is returned as a
+// printable HTML page instead of JSON.
+func (s *Server) handleZReport(w http.ResponseWriter, r *http.Request) {
+	registerID := r.PathValue("registerId")
+	query := r.URL.Query()
+
+	date := time.Now()
+	if value := query.Get("date"); value != "" {
+		var err error
+		date, err = time.ParseInLocation(time.DateOnly, value, time.Local)
+		if err != nil {
+			respondWithError(w, &ValidationError{Field: "date", Reason: "must be a date (YYYY-MM-DD)"})
+			return
+		}
+	}
+	from := startOfDay(date)
+
+	records, err := s.store.ListCounts(CountFilter{RegisterID: registerID, From: from, To: from.AddDate(0, 0, 1)})
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	reverseRecords(records)
+
+	report, err := BuildZReport(&s.config.Catalog, registerID, from, records)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	switch format := query.Get("format"); format {
+	case "", "json":
+		respondWithJSON(w, report)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := zReportTemplate.Execute(w, report); err != nil {
+			log.Println(err)
+		}
+	default:
+		respondWithError(w, &ValidationError{Field: "format", Reason: fmt.Sprintf("unknown format %q", format)})
+	}
+}
+
+// reverseRecords reverses records in place, turning the newest-first order of ListCounts into
+// the order the counts were saved.
+func reverseRecords(records []CountRecord) {
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+}
+
+var zReportTemplate = template.Must(template.New("z-report").Funcs(template.FuncMap{
+	"money": FormatNumber,
+	"clock": func(t time.Time) string { return t.In(time.Local).Format("15:04") },
+}).Parse(`<!DOCTYPE html>
+<html lang="de">
+<head>
+<meta charset="utf-8">
+<title>Z-Bericht {{.RegisterID}} {{.Date}}</title>
+<style>
+body { font-family: sans-serif; font-size: 12pt; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #999; padding: 0.2em 0.6em; }
+td.number { text-align: right; }
+@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<h1>Z-Bericht Kasse {{.RegisterID}}</h1>
+<p>Datum: {{.Date}}, Währung: {{.Currency}}{{if not .Closed}} – <strong>Kasse noch nicht abgeschlossen</strong>{{end}}</p>
+
+<h2>Zählungen</h2>
+<table>
+<tr><th>Uhrzeit</th><th>Art</th><th>Kassierer</th><th>Gezählt</th><th>Soll</th><th>Differenz</th></tr>
+{{with .OpeningCount}}<tr><td>{{clock .CreatedAt}}</td><td>Anfangsbestand</td><td>{{.Cashier}}</td><td class="number">{{money .TotalValue}}</td><td></td><td></td></tr>
+{{end}}{{range .InterimCounts}}<tr><td>{{clock .CreatedAt}}</td><td>Zwischenzählung</td><td>{{.Cashier}}</td><td class="number">{{money .TotalValue}}</td><td class="number">{{money .TargetValue}}</td><td class="number">{{money .DifferenceValue}}</td></tr>
+{{end}}{{range .Drops}}<tr><td>{{clock .CreatedAt}}</td><td>Abschöpfung</td><td>{{.Cashier}}</td><td class="number">{{money .TotalValue}}</td><td></td><td></td></tr>
+{{end}}{{with .FinalCount}}<tr><td>{{clock .CreatedAt}}</td><td>Endzählung</td><td>{{.Cashier}}</td><td class="number">{{money .TotalValue}}</td><td class="number">{{money .TargetValue}}</td><td class="number">{{money .DifferenceValue}}</td></tr>
+{{end}}</table>
+
+{{if .Closed}}<h2>Abschluss</h2>
+<table>
+<tr><th>Anfangsbestand</th><td class="number">{{money .OpeningFloat}}</td></tr>
+<tr><th>Abschöpfungen</th><td class="number">{{money .DropValue}}</td></tr>
+<tr><th>Endbestand</th><td class="number">{{money .FinalCount.TotalValue}}</td></tr>
+<tr><th>Soll</th><td class="number">{{money .TargetValue}}</td></tr>
+<tr><th>Differenz</th><td class="number">{{money .DifferenceValue}}</td></tr>
+</table>
+
+<h2>Stückelung</h2>
+<table>
+<tr><th>Stückelung</th><th>Lose</th><th>Rollen</th><th>Kartons</th><th>Betrag</th></tr>
+{{range .Denominations}}<tr><td>{{.Denomination}}</td><td class="number">{{.LooseQuantity}}</td><td class="number">{{.RollQuantity}}</td><td class="number">{{.BoxQuantity}}</td><td class="number">{{money .TotalValue}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
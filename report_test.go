@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// saveTestCounts stores a day of counts for register R1: an opening float, an interim count,
+// a closing count and a closing recount. The counts of register R2 and of the next day must
+// not show up in the report of R1.
+func saveTestCounts(t *testing.T, store *Store, day time.Time) {
+	t.Helper()
+	eur := euro()
+	records := []CountRecord{
+		NewCountRecord(eur, []Count{{Denomination: "euro50", Form: FormLoose, Quantity: 3}}, 0),
+		NewCountRecord(eur, []Count{{Denomination: "euro50", Form: FormLoose, Quantity: 5}}, 25000),
+		NewCountRecord(eur, []Count{{Denomination: "euro50", Form: FormLoose, Quantity: 7}}, 40000),
+		NewCountRecord(eur, []Count{
+			{Denomination: "euro50", Form: FormLoose, Quantity: 7},
+			{Denomination: "euro2", Form: FormRoll, Quantity: 1},
+			{Denomination: "euro2", Form: FormLoose, Quantity: 3},
+			{Denomination: "cent1", Form: FormBox, Quantity: 1},
+		}, 40000),
+		NewCountRecord(eur, []Count{{Denomination: "euro10", Form: FormLoose, Quantity: 1}}, 0),
+		NewCountRecord(eur, []Count{{Denomination: "euro10", Form: FormLoose, Quantity: 1}}, 0),
+	}
+	kinds := []CountKind{CountOpening, "", CountClosing, CountClosing, CountClosing, CountOpening}
+	registers := []string{"R1", "R1", "R1", "R1", "R2", "R1"}
+	hours := []int{8, 12, 20, 21, 20, 32}
+
+	for i := range records {
+		records[i].RegisterID = registers[i]
+		records[i].Cashier = "anna"
+		records[i].Kind = kinds[i]
+		records[i].CreatedAt = day.Add(time.Duration(hours[i]) * time.Hour)
+		if err := store.SaveCount(&records[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBuildZReport(t *testing.T) {
+	store := newTestStore(t)
+	day := time.Date(2026, 10, 16, 0, 0, 0, 0, time.Local)
+	saveTestCounts(t, store, day)
+
+	records, err := store.ListCounts(CountFilter{RegisterID: "R1", From: day, To: day.AddDate(0, 0, 1)})
+	assert.NoError(t, err)
+	reverseRecords(records)
+
+	report, err := BuildZReport(&testCatalog, "R1", day, records)
+	assert.NoError(t, err)
+	assert.Equal(t, "2026-10-16", report.Date)
+	assert.Equal(t, "EUR", report.Currency)
+	assert.True(t, report.Closed)
+	assert.Equal(t, Money(15000), report.OpeningFloat)
+	assert.Equal(t, []uint64{2, 3}, recordIDs(report.InterimCounts))
+	assert.Equal(t, uint64(4), report.FinalCount.ID)
+	assert.Equal(t, Money(40000), report.TargetValue)
+	assert.Equal(t, Money(35000+5600+250-40000), report.DifferenceValue)
+	assert.Equal(t, []ReportLine{
+		{Denomination: "euro50", Kind: KindNote, LooseQuantity: 7, TotalValue: 35000},
+		{Denomination: "euro2", Kind: KindCoin, LooseQuantity: 3, RollQuantity: 1, TotalValue: 5600},
+		{Denomination: "cent1", Kind: KindCoin, BoxQuantity: 1, TotalValue: 250},
+	}, report.Denominations)
+
+	open, err := BuildZReport(&testCatalog, "R1", day, records[:2])
+	assert.NoError(t, err)
+	assert.False(t, open.Closed)
+	assert.Nil(t, open.FinalCount)
+
+	_, err = BuildZReport(&testCatalog, "R1", day, nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestBuildZReportSkipsRejectedClosingCounts(t *testing.T) {
+	eur := euro()
+	opening := NewCountRecord(eur, []Count{{Denomination: "euro50", Form: FormLoose, Quantity: 3}}, 0)
+	closing := NewCountRecord(eur, []Count{{Denomination: "euro50", Form: FormLoose, Quantity: 5}}, 40000)
+	opening.ID, opening.Kind, opening.Status = 1, CountOpening, StatusFinal
+	closing.ID, closing.Kind, closing.Status = 2, CountClosing, StatusRejected
+
+	report, err := BuildZReport(&testCatalog, "R1", time.Now(), []CountRecord{opening, closing})
+	assert.NoError(t, err)
+	assert.False(t, report.Closed)
+	assert.Nil(t, report.FinalCount)
+	assert.Equal(t, []uint64{2}, recordIDs(report.InterimCounts))
+
+	closing.Status = StatusSuperseded
+	recount := closing
+	recount.ID, recount.Status, recount.RecountOf = 3, StatusPending, 2
+	report, err = BuildZReport(&testCatalog, "R1", time.Now(), []CountRecord{opening, closing, recount})
+	assert.NoError(t, err)
+	assert.True(t, report.Closed)
+	assert.Equal(t, uint64(3), report.FinalCount.ID)
+	assert.Equal(t, []uint64{2}, recordIDs(report.InterimCounts))
+}
+
+func TestHandleZReport(t *testing.T) {
+	server := newTestServer(t)
+	saveTestCounts(t, server.store, time.Date(2026, 10, 16, 0, 0, 0, 0, time.Local))
+	handler := server.routes()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/registers/R1/z-report?date=2026-10-16", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var report ZReport
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+	assert.Equal(t, uint64(4), report.FinalCount.ID)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/registers/R1/z-report?date=2026-10-16&format=html", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	body := rec.Body.String()
+	assert.Contains(t, body, "Z-Bericht Kasse R1")
+	assert.Contains(t, body, "<td class=\"number\">8,50</td>")
+	assert.True(t, strings.Contains(body, "Endzählung"))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/registers/R3/z-report?date=2026-10-16", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/registers/R1/z-report?date=16.10.2026", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
@@ -0,0 +1,582 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// MovementType is where cash moved into or out of the safe comes from or goes to.
+type MovementType string
+
+const (
+	MovementFromRegister MovementType = "fromRegister"
+	MovementToRegister   MovementType = "toRegister"
+	MovementFromBank     MovementType = "fromBank"
+	MovementToBank       MovementType = "toBank"
+)
+
+// sign returns 1 for movements into the safe and -1 for movements out of it, or 0 if t is not
+// one of the known types.
+func (t MovementType) sign() int {
+	switch t {
+	case MovementFromRegister, MovementFromBank:
+		return 1
+	case MovementToRegister, MovementToBank:
+		return -1
+	}
+	return 0
+}
+
+// SafeMovement is cash moved into or out of a safe: a transfer from or to a register, a deposit
+// to the bank or a delivery from it, such as a change order. Value is always positive.
+type SafeMovement struct {
+	ID         uint64       `json:"id"`
+	SafeID     string       `json:"safeId"`
+	Type       MovementType `json:"type"`
+	RegisterID string       `json:"registerId,omitempty"`
+	Currency   string       `json:"currency"`
+	Counts     []Count      `json:"counts"`
+	Value      Money        `json:"value"`
+	By         string       `json:"by,omitempty"`
+	Reference  string       `json:"reference,omitempty"`
+	At         time.Time    `json:"at"`
+}
+
+// SafeLine is the quantity of one denomination in one form held by a safe. In a difference
+// the quantity and value can be negative.
+type SafeLine struct {
+	Denomination string    `json:"denomination"`
+	Form         CountForm `json:"form"`
+	Quantity     int       `json:"quantity"`
+	Value        Money     `json:"value"`
+}
+
+// SafeCount is a count of the contents of a safe. ExpectedValue is what the safe should have
+// held at the time, the previous count plus the movements since; Differences lists the
+// denominations that were counted more or less than expected. LastMovementID is the ID of the
+// last movement recorded before the count; the movements after it are applied on top of it.
+type SafeCount struct {
+	ID              uint64     `json:"id"`
+	SafeID          string     `json:"safeId"`
+	LastMovementID  uint64     `json:"lastMovementId"`
+	Currency        string     `json:"currency"`
+	Counts          []Count    `json:"counts"`
+	TotalValue      Money      `json:"totalValue"`
+	ExpectedValue   Money      `json:"expectedValue"`
+	DifferenceValue Money      `json:"differenceValue"`
+	Differences     []SafeLine `json:"differences"`
+	By              string     `json:"by,omitempty"`
+	At              time.Time  `json:"at"`
+}
+
+// SafeInventory is what a safe holds in one currency. Counted is the content at the last count,
+// Theoretical that content plus the movements since; Differences lists what the theoretical
+// content holds more or less than the counted one.
+type SafeInventory struct {
+	SafeID           string         `json:"safeId"`
+	Currency         string         `json:"currency"`
+	LastCount        *SafeCount     `json:"lastCount"`
+	Counted          []SafeLine     `json:"counted"`
+	CountedValue     Money          `json:"countedValue"`
+	Movements        []SafeMovement `json:"movements"`
+	Theoretical      []SafeLine     `json:"theoretical"`
+	TheoreticalValue Money          `json:"theoreticalValue"`
+	Differences      []SafeLine     `json:"differences"`
+	DifferenceValue  Money          `json:"differenceValue"`
+}
+
+// SafeCountRequest counts the safe.
+type SafeCountRequest struct {
+	By       string `json:"by,omitempty"`
+	Currency string `json:"currency,omitempty"`
+	CashValues
+}
+
+// SafeMovementRequest records cash moved into or out of the safe.
+type SafeMovementRequest struct {
+	Type       MovementType `json:"type"`
+	RegisterID string       `json:"registerId,omitempty"`
+	By         string       `json:"by,omitempty"`
+	Reference  string       `json:"reference,omitempty"`
+	Currency   string       `json:"currency,omitempty"`
+	CashValues
+}
+
+// safeStock holds quantities per denomination and form.
+type safeStock map[countKey]int
+
+// add adds the counts to the stock, or subtracts them if sign is negative.
+func (stock safeStock) add(counts []Count, sign int) {
+	for _, c := range counts {
+		stock[countKey{c.Denomination, c.Form}] += sign * c.Quantity
+	}
+}
+
+// lines returns the stock as lines in the order of the currency's denominations and their
+// total value. Denominations and forms without quantity are left out.
+func (stock safeStock) lines(currency *Currency) ([]SafeLine, Money) {
+	lines := []SafeLine{}
+	var total Money
+	for _, d := range currency.Denominations {
+		for _, form := range []CountForm{FormLoose, FormRoll, FormBox} {
+			quantity := stock[countKey{d.Code, form}]
+			if quantity == 0 {
+				continue
+			}
+			value := CountValue(&d, form, quantity)
+			lines = append(lines, SafeLine{Denomination: d.Code, Form: form, Quantity: quantity, Value: value})
+			total += value
+		}
+	}
+	return lines, total
+}
+
+// safeState returns the last count of the safe in the currency, if any, and the movements
+// recorded after it. Both are ordered by their IDs, which follow the order of the transactions
+// that stored them, not by their timestamps.
+func safeState(tx *bolt.Tx, safeID, currency string) (*SafeCount, []SafeMovement, error) {
+	var last *SafeCount
+	cursor := tx.Bucket(safeCountsBucket).Cursor()
+	for key, data := cursor.Last(); key != nil; key, data = cursor.Prev() {
+		var count SafeCount
+		if err := json.Unmarshal(data, &count); err != nil {
+			return nil, nil, fmt.Errorf("safe count %d: %w", btoi(key), err)
+		}
+		if count.SafeID == safeID && count.Currency == currency {
+			last = &count
+			break
+		}
+	}
+
+	movements := []SafeMovement{}
+	err := tx.Bucket(safeMovementsBucket).ForEach(func(key, data []byte) error {
+		var movement SafeMovement
+		if err := json.Unmarshal(data, &movement); err != nil {
+			return fmt.Errorf("safe movement %d: %w", btoi(key), err)
+		}
+		if movement.SafeID == safeID && movement.Currency == currency && (last == nil || movement.ID > last.LastMovementID) {
+			movements = append(movements, movement)
+		}
+		return nil
+	})
+	return last, movements, err
+}
+
+// theoretical returns the stock of the last count plus the movements since.
+func theoretical(last *SafeCount, movements []SafeMovement) safeStock {
+	stock := make(safeStock)
+	if last != nil {
+		stock.add(last.Counts, 1)
+	}
+	for _, movement := range movements {
+		stock.add(movement.Counts, movement.Type.sign())
+	}
+	return stock
+}
+
+// SaveSafeCount compares count with the theoretical content of its safe, sets the expected
+// value and the differences and stores it under the next free ID. The functions in then run
+// last in the transaction; if one fails, nothing is stored.
+func (s *Store) SaveSafeCount(currency *Currency, count *SafeCount, then ...func(*bolt.Tx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		last, movements, err := safeState(tx, count.SafeID, count.Currency)
+		if err != nil {
+			return err
+		}
+		expected := theoretical(last, movements)
+		_, count.ExpectedValue = expected.lines(currency)
+		difference := make(safeStock)
+		difference.add(count.Counts, 1)
+		for key, quantity := range expected {
+			difference[key] -= quantity
+		}
+		count.Differences, count.DifferenceValue = difference.lines(currency)
+		count.LastMovementID = tx.Bucket(safeMovementsBucket).Sequence()
+
+		bucket := tx.Bucket(safeCountsBucket)
+		if count.ID, err = bucket.NextSequence(); err != nil {
+			return err
+		}
+		if err := putJSON(bucket, itob(count.ID), count); err != nil {
+			return err
+		}
+		return runAll(tx, then)
+	})
+}
+
+// SaveSafeMovement stores movement under the next free ID. The functions in then run last in
+// the transaction; if one fails, nothing is stored.
+func (s *Store) SaveSafeMovement(movement *SafeMovement, then ...func(*bolt.Tx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := insertSafeMovement(tx, movement); err != nil {
+			return err
+		}
+		return runAll(tx, then)
+	})
+}
+
+// insertSafeMovement stores movement under the next free ID within the transaction tx. A
+// movement out of the safe that takes more of a denomination than the safe holds in theory is
+// refused with ErrConflict.
+func insertSafeMovement(tx *bolt.Tx, movement *SafeMovement) error {
+	if movement.Type.sign() < 0 {
+		last, movements, err := safeState(tx, movement.SafeID, movement.Currency)
+		if err != nil {
+			return err
+		}
+		stock := theoretical(last, movements)
+		stock.add(movement.Counts, -1)
+		for _, c := range movement.Counts {
+			if stock[countKey{c.Denomination, c.Form}] < 0 {
+				return fmt.Errorf("safe %s holds too little %s (%s) for the movement: %w", movement.SafeID, c.Denomination, c.Form, ErrConflict)
+			}
+		}
+	}
+	bucket := tx.Bucket(safeMovementsBucket)
+	id, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	movement.ID = id
+	return putJSON(bucket, itob(id), movement)
+}
+
+// SafeInventory returns the counted and the theoretical content of the safe in the currency.
+func (s *Store) SafeInventory(currency *Currency, safeID string) (SafeInventory, error) {
+	inventory := SafeInventory{SafeID: safeID, Currency: currency.Code}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		inventory.LastCount, inventory.Movements, err = safeState(tx, safeID, currency.Code)
+		return err
+	})
+	if err != nil {
+		return inventory, err
+	}
+
+	counted := make(safeStock)
+	if inventory.LastCount != nil {
+		counted.add(inventory.LastCount.Counts, 1)
+	}
+	stock := theoretical(inventory.LastCount, inventory.Movements)
+	inventory.Counted, inventory.CountedValue = counted.lines(currency)
+	inventory.Theoretical, inventory.TheoreticalValue = stock.lines(currency)
+	for key, quantity := range counted {
+		stock[key] -= quantity
+	}
+	inventory.Differences, inventory.DifferenceValue = stock.lines(currency)
+	return inventory, nil
+}
+
+// ListSafeCounts returns the counts of the safe, newest first.
+func (s *Store) ListSafeCounts(safeID string) ([]SafeCount, error) {
+	counts := []SafeCount{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(safeCountsBucket).Cursor()
+		for key, data := cursor.Last(); key != nil; key, data = cursor.Prev() {
+			var count SafeCount
+			if err := json.Unmarshal(data, &count); err != nil {
+				return fmt.Errorf("safe count %d: %w", btoi(key), err)
+			}
+			if count.SafeID == safeID {
+				counts = append(counts, count)
+			}
+		}
+		return nil
+	})
+	return counts, err
+}
+
+// ListSafeMovements returns the movements of the safe, newest first. From is inclusive, To
+// exclusive; zero times do not filter.
+func (s *Store) ListSafeMovements(safeID string, from, to time.Time) ([]SafeMovement, error) {
+	movements := []SafeMovement{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(safeMovementsBucket).Cursor()
+		for key, data := cursor.Last(); key != nil; key, data = cursor.Prev() {
+			var movement SafeMovement
+			if err := json.Unmarshal(data, &movement); err != nil {
+				return fmt.Errorf("safe movement %d: %w", btoi(key), err)
+			}
+			if movement.SafeID != safeID ||
+				!from.IsZero() && movement.At.Before(from) || !to.IsZero() && !movement.At.Before(to) {
+				continue
+			}
+			movements = append(movements, movement)
+		}
+		return nil
+	})
+	return movements, err
+}
+
+// checkSafe validates the ID of a safe. Every store has one safe with the ID of the store, so
+// once tenancy is enabled, a safe other than theirs is not found.
+func (s *Store) checkSafe(safeID string, tenancy TenancyConfig) error {
+	if err := validateResourceID("safeId", safeID); err != nil {
+		return err
+	}
+	if !tenancy.Enabled {
+		return nil
+	}
+	return s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(shopsBucket).Get([]byte(safeID)) == nil {
+			return fmt.Errorf("safe %s: %w", safeID, ErrNotFound)
+		}
+		return nil
+	})
+}
+
+// recordMovement stores movement and appends it to the audit journal in one transaction, after
+// the functions in then, so a movement that cannot be journalled is not stored.
+func (s *Server) recordMovement(movement *SafeMovement, then ...func(*bolt.Tx) error) error {
+	journal := func(*bolt.Tx) error {
+		_, err := s.journal.Append(movement)
+		return err
+	}
+	return s.store.SaveSafeMovement(movement, append(then, journal)...)
+}
+
+// safeRequest validates the safe in the path and the cash of a request to it and returns the
+// currency and the counts. by is set to the user of the credentials.
+func (s *Server) safeRequest(r *http.Request, code string, values CashValues, by *string) (*Currency, []Count, error) {
+	if err := s.store.checkSafe(r.PathValue("safeId"), s.config.Tenancy); err != nil {
+		return nil, nil, err
+	}
+	if identity, ok := identityFrom(r.Context()); ok {
+		var err error
+		if *by, err = identityValue("by", *by, identity.User()); err != nil {
+			return nil, nil, err
+		}
+	}
+	currency, ok := s.config.Catalog.Currency(code)
+	if !ok {
+		return nil, nil, &ValidationError{Field: "currency", Reason: "unknown currency " + code}
+	}
+	counts, err := values.counts(currency)
+	return currency, counts, err
+}
+
+// handleSafeCount saves a count of the safe in the path and responds with its difference to the
+// theoretical content.
+func (s *Server) handleSafeCount(w http.ResponseWriter, r *http.Request) {
+	var request SafeCountRequest
+	if err := decodeStrict(r, &request); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	currency, counts, err := s.safeRequest(r, request.Currency, request.CashValues, &request.By)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	loose, rolls, boxes := SumCounts(currency, counts)
+	count := SafeCount{
+		SafeID:     r.PathValue("safeId"),
+		Currency:   currency.Code,
+		Counts:     emptyIfNil(counts),
+		TotalValue: loose + rolls + boxes,
+		By:         request.By,
+		At:         time.Now().UTC(),
+	}
+	journal := func(*bolt.Tx) error {
+		_, err := s.journal.Append(count)
+		return err
+	}
+	if err := s.store.SaveSafeCount(currency, &count, journal); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, count)
+}
+
+// handleSafeMovement records cash moved into or out of the safe in the path. A transfer from or
+// to a register is booked into the open session of the register in the same transaction: as a
+// skim when the cash comes from the drawer, as a refill when it goes into it. Cash the safe does
+// not hold cannot be moved out of it.
+func (s *Server) handleSafeMovement(w http.ResponseWriter, r *http.Request) {
+	var request SafeMovementRequest
+	if err := decodeStrict(r, &request); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if request.Type.sign() == 0 {
+		respondWithError(w, &ValidationError{Field: "type", Reason: fmt.Sprintf("unknown movement type %q", request.Type)})
+		return
+	}
+	register := request.Type == MovementFromRegister || request.Type == MovementToRegister
+	if register != (request.RegisterID != "") {
+		respondWithError(w, &ValidationError{Field: "registerId", Reason: "is required for transfers from and to registers only"})
+		return
+	}
+	currency, counts, err := s.safeRequest(r, request.Currency, request.CashValues, &request.By)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	loose, rolls, boxes := SumCounts(currency, counts)
+	if loose+rolls+boxes <= 0 {
+		respondWithError(w, &ValidationError{Field: "counts", Reason: "the movement is empty"})
+		return
+	}
+	movement := SafeMovement{
+		SafeID:     r.PathValue("safeId"),
+		Type:       request.Type,
+		RegisterID: request.RegisterID,
+		Currency:   currency.Code,
+		Counts:     counts,
+		Value:      loose + rolls + boxes,
+		By:         request.By,
+		Reference:  request.Reference,
+		At:         time.Now().UTC(),
+	}
+	var then []func(*bolt.Tx) error
+	if register {
+		book, err := s.registerTransfer(r, &movement)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		then = append(then, book)
+	}
+	if err := s.recordMovement(&movement, then...); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, movement)
+}
+
+// registerTransfer checks a transfer between a safe and a register: the register has to be
+// known once tenancy is enabled, belong to the store of the safe, be one the caller may use and
+// have an open session in the currency of the movement. It returns the function that books the
+// movement, once stored, into that session.
+func (s *Server) registerTransfer(r *http.Request, movement *SafeMovement) (func(*bolt.Tx) error, error) {
+	registerID := movement.RegisterID
+	storeID, err := s.store.RegisterStore(registerID, s.config.Tenancy)
+	if err != nil {
+		return nil, err
+	}
+	if storeID != "" && storeID != movement.SafeID {
+		return nil, &ValidationError{Field: "registerId", Reason: fmt.Sprintf("register %s belongs to store %s, not to %s", registerID, storeID, movement.SafeID)}
+	}
+	if err := checkRegister(r.Context(), registerID); err != nil {
+		return nil, err
+	}
+	session, err := s.store.OpenSession(registerID)
+	if err != nil {
+		return nil, err
+	}
+	if session.Currency != movement.Currency {
+		return nil, &ValidationError{Field: "currency", Reason: fmt.Sprintf("session %d of register %s counts %s", session.ID, registerID, session.Currency)}
+	}
+
+	entryType := EntrySkim
+	if movement.Type == MovementToRegister {
+		entryType = EntryRefill
+	}
+	return func(tx *bolt.Tx) error {
+		entry := SessionEntry{
+			Type:      entryType,
+			Amount:    movement.Value,
+			Reference: fmt.Sprintf("safe movement %d", movement.ID),
+			By:        movement.By,
+			At:        movement.At,
+		}
+		_, err := updateSession(tx, session.ID, func(session *Session) error { return session.book(entry) })
+		return err
+	}, nil
+}
+
+// handleSafeInventory returns the counted and theoretical content of the safe in the path, in
+// the currency given with the "currency" query parameter or the default currency.
+func (s *Server) handleSafeInventory(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.checkSafe(r.PathValue("safeId"), s.config.Tenancy); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	code := r.URL.Query().Get("currency")
+	currency, ok := s.config.Catalog.Currency(code)
+	if !ok {
+		respondWithError(w, &ValidationError{Field: "currency", Reason: "unknown currency " + code})
+		return
+	}
+	inventory, err := s.store.SafeInventory(currency, r.PathValue("safeId"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, inventory)
+}
+
+// handleListSafeCounts returns the counts of the safe in the path, newest first.
+func (s *Server) handleListSafeCounts(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.checkSafe(r.PathValue("safeId"), s.config.Tenancy); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	counts, err := s.store.ListSafeCounts(r.PathValue("safeId"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, counts)
+}
+
+// handleListSafeMovements returns the movements of the safe in the path, newest first, narrowed
+// by the query parameters from and to.
+func (s *Server) handleListSafeMovements(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.checkSafe(r.PathValue("safeId"), s.config.Tenancy); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	query := r.URL.Query()
+	from, err := parseTimeParam(query.Get("from"), "from")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	to, err := parseTimeParam(query.Get("to"), "to")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	movements, err := s.store.ListSafeMovements(r.PathValue("safeId"), from, to)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, movements)
+}
+
+// dropMovement returns the transfer into the safe of the store for a drop from one of its
+// registers.
+func dropMovement(record *CountRecord) SafeMovement {
+	return SafeMovement{
+		SafeID:     record.StoreID,
+		Type:       MovementFromRegister,
+		RegisterID: record.RegisterID,
+		Currency:   record.Currency,
+		Counts:     record.Counts,
+		Value:      record.TotalValue,
+		By:         record.Cashier,
+		Reference:  fmt.Sprintf("drop %d", record.ID),
+		At:         record.CreatedAt,
+	}
+}
+
+// slipMovement returns the deposit of a slip to the bank, out of the safe of the store the
+// deposited count belongs to.
+func slipMovement(slip *DepositSlip, storeID string) SafeMovement {
+	return SafeMovement{
+		SafeID:    storeID,
+		Type:      MovementToBank,
+		Currency:  slip.Currency,
+		Counts:    slip.Counts(),
+		Value:     slip.TotalValue,
+		Reference: fmt.Sprintf("deposit slip %d", slip.Number),
+		At:        slip.CreatedAt,
+	}
+}
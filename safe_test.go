@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// decodeInventory returns the inventory of the safe.
+func decodeInventory(t *testing.T, handler http.Handler, safeID string) SafeInventory {
+	t.Helper()
+	rec := serveJSON(handler, http.MethodGet, "/api/v1/safes/"+safeID, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var inventory SafeInventory
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&inventory))
+	return inventory
+}
+
+func TestSafeInventory(t *testing.T) {
+	handler := newTestServer(t).routes()
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","expectedValue":"500,00"}`)
+
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/safes/main/counts",
+		`{"by":"max","requestValues":{"euro50":[4,0,0,0,0]},"rollValues":{"euro2":[2,0]}}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var count SafeCount
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&count))
+	assert.Equal(t, Money(30000), count.TotalValue)
+	assert.Equal(t, Money(30000), count.DifferenceValue)
+
+	for _, body := range []string{
+		`{"type":"fromRegister","registerId":"R1","counts":[{"denomination":"euro50","form":"loose","quantity":2}]}`,
+		`{"type":"toBank","reference":"slip 7","requestValues":{"euro50":[3,0,0,0,0]}}`,
+		`{"type":"toRegister","registerId":"R1","rollValues":{"euro2":[1,0]}}`,
+	} {
+		rec = serveJSON(handler, http.MethodPost, "/api/v1/safes/main/movements", body)
+		assert.Equal(t, http.StatusOK, rec.Code, body)
+	}
+
+	inventory := decodeInventory(t, handler, "main")
+	assert.Equal(t, Money(30000), inventory.CountedValue)
+	assert.Len(t, inventory.Movements, 3)
+	assert.Equal(t, []SafeLine{
+		{Denomination: "euro50", Form: FormLoose, Quantity: 3, Value: 15000},
+		{Denomination: "euro2", Form: FormRoll, Quantity: 1, Value: 5000},
+	}, inventory.Theoretical)
+	assert.Equal(t, Money(20000), inventory.TheoreticalValue)
+	assert.Equal(t, Money(-10000), inventory.DifferenceValue)
+
+	// the transfers from and to the register are booked into its session
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/sessions/1", "")
+	session := decodeSession(t, rec)
+	assert.Equal(t, Money(45000), *session.ExpectedValue)
+	if assert.Len(t, session.Entries, 2) {
+		assert.Equal(t, SessionEntry{Type: EntrySkim, Amount: 10000, Reference: "safe movement 1", At: session.Entries[0].At}, session.Entries[0])
+		assert.Equal(t, EntryRefill, session.Entries[1].Type)
+		assert.Equal(t, Money(5000), session.Entries[1].Amount)
+	}
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/cash-book", "")
+	entries := decodeCashBook(t, rec)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, []CashBookKind{BookSkim, BookRefill}, []CashBookKind{entries[0].Kind, entries[1].Kind})
+		assert.Equal(t, []Money{-10000, 5000}, []Money{entries[0].Amount, entries[1].Amount})
+	}
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/safes/main/counts",
+		`{"counts":[{"denomination":"euro50","form":"loose","quantity":2},{"denomination":"euro2","form":"roll","quantity":1}]}`)
+	count = SafeCount{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&count))
+	assert.Equal(t, Money(20000), count.ExpectedValue)
+	assert.Equal(t, Money(-5000), count.DifferenceValue)
+	assert.Equal(t, []SafeLine{{Denomination: "euro50", Form: FormLoose, Quantity: -1, Value: -5000}}, count.Differences)
+
+	inventory = decodeInventory(t, handler, "main")
+	assert.Empty(t, inventory.Movements)
+	assert.Equal(t, inventory.Counted, inventory.Theoretical)
+	assert.Equal(t, Money(0), inventory.DifferenceValue)
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/safes/main/counts", "")
+	var counts []SafeCount
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&counts))
+	assert.Equal(t, []uint64{2, 1}, []uint64{counts[0].ID, counts[1].ID})
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/safes/main/movements?from=2020-01-01", "")
+	var movements []SafeMovement
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&movements))
+	assert.Len(t, movements, 3)
+}
+
+func TestSafeMovementErrors(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.routes()
+	fifty := `"counts":[{"denomination":"euro50","form":"loose","quantity":1}]`
+
+	for body, field := range map[string]string{
+		`{"type":"lost",` + fifty + `}`:                     "type",
+		`{"type":"fromRegister",` + fifty + `}`:             "registerId",
+		`{"type":"toBank","registerId":"R1",` + fifty + `}`: "registerId",
+		`{"type":"toBank","counts":[]}`:                     "counts",
+		`{"type":"toBank","currency":"XXX",` + fifty + `}`:  "currency",
+		`{"type":"fromBank","boxValues":{"euro50":[1]}}`:    "boxValues.euro50",
+	} {
+		rec := serveJSON(handler, http.MethodPost, "/api/v1/safes/main/movements", body)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, body)
+		assert.Contains(t, rec.Body.String(), `"field":"`+field+`"`, body)
+	}
+
+	// register transfers need an open session of a register of the store
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/safes/main/movements", `{"type":"toRegister","registerId":"R1",`+fifty+`}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","expectedValue":"0"}`)
+
+	// cash the safe does not hold cannot leave it
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/safes/main/movements", `{"type":"toBank",`+fifty+`}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/safes/main/movements", `{"type":"toRegister","registerId":"R1",`+fifty+`}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	inventory := decodeInventory(t, handler, "main")
+	assert.Empty(t, inventory.Movements)
+
+	// with tenancy, every store has its safe
+	serveJSON(handler, http.MethodPost, "/api/v1/stores", `{"id":"berlin-1","name":"Berlin"}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/registers", `{"id":"R2","storeId":"berlin-1"}`)
+	server.config.Tenancy.Enabled = true
+	for _, target := range []string{"/api/v1/safes/main", "/api/v1/safes/main/counts", "/api/v1/safes/main/movements"} {
+		rec = serveJSON(handler, http.MethodGet, target, "")
+		assert.Equal(t, http.StatusNotFound, rec.Code, target)
+	}
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/safes/main/movements", `{"type":"toBank",`+fifty+`}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	for _, body := range []string{
+		`{"type":"fromRegister","registerId":"R9",` + fifty + `}`,
+		`{"type":"fromRegister","registerId":"R1",` + fifty + `}`,
+	} {
+		rec = serveJSON(handler, http.MethodPost, "/api/v1/safes/berlin-1/movements", body)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, body)
+		assert.Contains(t, rec.Body.String(), `"field":"registerId"`, body)
+	}
+}
+
+func TestSafeStateFollowsIDs(t *testing.T) {
+	server := newTestServer(t)
+	currency, _ := server.config.Catalog.Currency("")
+	count := SafeCount{SafeID: "main", Currency: "EUR", Counts: []Count{}, At: time.Now().UTC()}
+	assert.NoError(t, server.store.SaveSafeCount(currency, &count))
+
+	// a movement stored after the count is applied on top of it, whatever its timestamp
+	movement := SafeMovement{SafeID: "main", Type: MovementFromBank, Currency: "EUR", Value: 5000,
+		Counts: []Count{{Denomination: "euro50", Form: FormLoose, Quantity: 1}}, At: count.At.Add(-time.Hour)}
+	assert.NoError(t, server.store.SaveSafeMovement(&movement))
+	inventory, err := server.store.SafeInventory(currency, "main")
+	assert.NoError(t, err)
+	assert.Len(t, inventory.Movements, 1)
+	assert.Equal(t, Money(5000), inventory.TheoreticalValue)
+}
+
+func TestSafeCountRollsBackWithoutJournal(t *testing.T) {
+	server := newTestServer(t)
+	assert.NoError(t, server.journal.Close())
+
+	rec := serveJSON(server.routes(), http.MethodPost, "/api/v1/safes/main/counts", `{"requestValues":{"euro50":[4,0,0,0,0]}}`)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	counts, err := server.store.ListSafeCounts("main")
+	assert.NoError(t, err)
+	assert.Empty(t, counts)
+}
+
+func TestDropMovesIntoSafe(t *testing.T) {
+	handler := newTestServer(t).routes()
+	serveJSON(handler, http.MethodPost, "/api/v1/stores", `{"id":"berlin-1","name":"Berlin"}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/registers", `{"id":"R1","storeId":"berlin-1"}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","expectedValue":"500,00"}`)
+
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/registers/R1/drops",
+		`{"cashier":"anna","counts":[{"denomination":"euro200","form":"loose","quantity":1}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	inventory := decodeInventory(t, handler, "berlin-1")
+	assert.Equal(t, Money(20000), inventory.TheoreticalValue)
+	assert.Len(t, inventory.Movements, 1)
+	assert.Equal(t, MovementFromRegister, inventory.Movements[0].Type)
+	assert.Equal(t, "R1", inventory.Movements[0].RegisterID)
+	assert.Equal(t, "drop 1", inventory.Movements[0].Reference)
+}
+
+func TestDepositSlipLeavesSafe(t *testing.T) {
+	handler := newTestServer(t).routes()
+	serveJSON(handler, http.MethodPost, "/api/v1/stores", `{"id":"berlin-1","name":"Berlin"}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/registers", `{"id":"R1","storeId":"berlin-1"}`)
+	rec := serveJSON(handler, http.MethodPost, "/api/v2/calculate",
+		`{"registerId":"R1","targetValue":"100,00","counts":[{"denomination":"euro50","form":"loose","quantity":2}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// the counted cash is still in the drawer, not in the safe
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/deposit-slips", `{"countId":1,"branch":"Mitte"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/safes/berlin-1/movements",
+		`{"type":"fromBank","counts":[{"denomination":"euro50","form":"loose","quantity":3}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/deposit-slips", `{"countId":1,"branch":"Mitte"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	inventory := decodeInventory(t, handler, "berlin-1")
+	assert.Equal(t, Money(5000), inventory.TheoreticalValue)
+	if assert.Len(t, inventory.Movements, 2) {
+		assert.Equal(t, MovementToBank, inventory.Movements[1].Type)
+		assert.Equal(t, Money(10000), inventory.Movements[1].Value)
+		assert.Equal(t, "deposit slip 1", inventory.Movements[1].Reference)
+	}
+}
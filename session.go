@@ -0,0 +1,877 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// SessionState is where a session stands in the shift of its register.
+type SessionState string
+
+const (
+	// SessionOpen sessions take counts, sales and skims.
+	SessionOpen SessionState = "open"
+	// SessionHandover sessions wait for the incoming cashier to count the drawer.
+	SessionHandover SessionState = "handover"
+	// SessionClosed sessions have a closing count and only take recounts.
+	SessionClosed SessionState = "closed"
+)
+
+// EntryType tells whether a session entry adds to the expected cash or takes from it.
+type EntryType string
+
+const (
+	EntrySale   EntryType = "sale"
+	EntrySkim   EntryType = "skim"
+	EntryRefill EntryType = "refill"
+)
+
+// SessionEntry is a sale booked into a session, cash skimmed off its drawer or cash put into it
+// from the safe. Sales may be negative for refunds; skims are always positive and subtracted,
+// refills always positive and added. Skims recorded as drops refer to the count of the drop.
+type SessionEntry struct {
+	Type      EntryType `json:"type"`
+	Amount    Money     `json:"amount"`
+	Reference string    `json:"reference,omitempty"`
+	By        string    `json:"by,omitempty"`
+	At        time.Time `json:"at"`
+	CountID   uint64    `json:"countId,omitempty"`
+}
+
+// SessionEvent is the journal entry of a sale or skim booked into a session.
+type SessionEvent struct {
+	SessionID uint64 `json:"sessionId"`
+	SessionEntry
+}
+
+// Handover is the change of cashiers during a session. Both cashiers count the drawer;
+// DifferenceValue is what the incoming cashier counted more than the outgoing one. The
+// incoming fields stay empty while the handover is in progress.
+type Handover struct {
+	From            string     `json:"from"`
+	To              string     `json:"to,omitempty"`
+	OutgoingCountID uint64     `json:"outgoingCountId"`
+	IncomingCountID uint64     `json:"incomingCountId,omitempty"`
+	OutgoingValue   Money      `json:"outgoingValue"`
+	IncomingValue   Money      `json:"incomingValue"`
+	DifferenceValue Money      `json:"differenceValue"`
+	StartedAt       time.Time  `json:"startedAt"`
+	CompletedAt     *time.Time `json:"completedAt,omitempty"`
+}
+
+// Session is the shift of a cashier on a register. It opens with a counted float, and the
+// server keeps the expected cash: the opening float plus the sales minus the skims. In a blind
+// session the expected value is only revealed once a count has been committed. Attempts holds
+// the IDs of the counts submitted to the session, every mid-shift and closing count and every
+// recount; the opening and handover counts are kept apart.
+type Session struct {
+	ID             uint64         `json:"id"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	RegisterID     string         `json:"registerId"`
+	StoreID        string         `json:"storeId,omitempty"`
+	Cashier        string         `json:"cashier,omitempty"`
+	Currency       string         `json:"currency,omitempty"`
+	State          SessionState   `json:"state"`
+	Blind          bool           `json:"blind"`
+	OpeningCountID uint64         `json:"openingCountId,omitempty"`
+	OpeningFloat   Money          `json:"openingFloat"`
+	Sales          Money          `json:"sales"`
+	Skims          Money          `json:"skims"`
+	ExpectedValue  Money          `json:"expectedValue"`
+	Entries        []SessionEntry `json:"entries"`
+	Handovers      []Handover     `json:"handovers"`
+	Attempts       []uint64       `json:"attempts"`
+	ClosedAt       *time.Time     `json:"closedAt,omitempty"`
+}
+
+// SessionRequest opens a session. Counts is the counted opening float; ExpectedValue is the
+// float the drawer should have been filled with, and is taken as the opening float if the
+// float is not counted. Both accept the same formats as a target value.
+type SessionRequest struct {
+	RegisterID    string  `json:"registerId"`
+	Cashier       string  `json:"cashier,omitempty"`
+	Currency      string  `json:"currency,omitempty"`
+	Blind         bool    `json:"blind"`
+	ExpectedValue string  `json:"expectedValue,omitempty"`
+	Counts        []Count `json:"counts,omitempty"`
+}
+
+// SessionCountRequest submits a count to a session. It has no target value, the session's
+// expected value is used instead.
+type SessionCountRequest struct {
+	Cashier   string    `json:"cashier,omitempty"`
+	CountKind CountKind `json:"countKind,omitempty"`
+	Currency  string    `json:"currency,omitempty"`
+	Counts    []Count   `json:"counts"`
+}
+
+// HandoverRequest is the count of the drawer by the outgoing or, once the handover has started,
+// the incoming cashier.
+type HandoverRequest struct {
+	Cashier  string  `json:"cashier,omitempty"`
+	Currency string  `json:"currency,omitempty"`
+	Counts   []Count `json:"counts"`
+}
+
+// SessionEntryRequest books a sale or a skim. Amount accepts the same formats as a target value.
+type SessionEntryRequest struct {
+	Amount    string `json:"amount"`
+	Reference string `json:"reference,omitempty"`
+}
+
+// SessionPayload is a session as shown to clients. While the expected value is hidden, so are
+// the sales it could be worked out from.
+type SessionPayload struct {
+	ID             uint64         `json:"id"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	RegisterID     string         `json:"registerId"`
+	StoreID        string         `json:"storeId,omitempty"`
+	Cashier        string         `json:"cashier,omitempty"`
+	Currency       string         `json:"currency,omitempty"`
+	State          SessionState   `json:"state"`
+	Blind          bool           `json:"blind"`
+	OpeningCountID uint64         `json:"openingCountId,omitempty"`
+	OpeningFloat   Money          `json:"openingFloat"`
+	Sales          *Money         `json:"sales"`
+	Skims          Money          `json:"skims"`
+	ExpectedValue  *Money         `json:"expectedValue"`
+	Entries        []SessionEntry `json:"entries"`
+	Handovers      []Handover     `json:"handovers"`
+	Attempts       []uint64       `json:"attempts"`
+	ClosedAt       *time.Time     `json:"closedAt,omitempty"`
+}
+
+// Payload returns the session as shown to clients, hiding the expected value and the sales of
+// a blind session that has no committed count yet.
+func (session Session) Payload() SessionPayload {
+	payload := SessionPayload{
+		ID:             session.ID,
+		CreatedAt:      session.CreatedAt,
+		RegisterID:     session.RegisterID,
+		StoreID:        session.StoreID,
+		Cashier:        session.Cashier,
+		Currency:       session.Currency,
+		State:          session.State,
+		Blind:          session.Blind,
+		OpeningCountID: session.OpeningCountID,
+		OpeningFloat:   session.OpeningFloat,
+		Skims:          session.Skims,
+		Entries:        emptyIfNil(session.Entries),
+		Handovers:      emptyIfNil(session.Handovers),
+		Attempts:       emptyIfNil(session.Attempts),
+		ClosedAt:       session.ClosedAt,
+	}
+	if !session.Blind || len(session.Attempts) > 0 {
+		expected, sales := session.ExpectedValue, session.Sales
+		payload.ExpectedValue, payload.Sales = &expected, &sales
+		return payload
+	}
+	payload.Entries = []SessionEntry{}
+	for _, entry := range session.Entries {
+		if entry.Type != EntrySale {
+			payload.Entries = append(payload.Entries, entry)
+		}
+	}
+	return payload
+}
+
+// isOpen reports whether the session takes counts. Sessions saved before sessions had a state
+// are open.
+func (session *Session) isOpen() bool {
+	return session.State == SessionOpen || session.State == ""
+}
+
+// businessDay returns the local date the session was opened on.
+func (session *Session) businessDay() string {
+	return session.CreatedAt.In(time.Local).Format(time.DateOnly)
+}
+
+// errState returns the ValidationError for something the session does not take in its
+// current state.
+func (session *Session) errState(what string) error {
+	return &ValidationError{Field: "session", Reason: fmt.Sprintf("session %d is %s and takes no %s", session.ID, session.State, what)}
+}
+
+// checkUnused returns a ValidationError once something was counted or booked in the session.
+// From then on the session belongs to the books and can neither be changed nor deleted.
+func (session *Session) checkUnused() error {
+	if session.OpeningCountID != 0 || len(session.Entries) > 0 || len(session.Attempts) > 0 || len(session.Handovers) > 0 {
+		return &ValidationError{Field: "session", Reason: fmt.Sprintf("session %d has counts or bookings and cannot be changed", session.ID)}
+	}
+	return nil
+}
+
+// accept checks that the session takes the count in its current state and numbers the count
+// if it is an attempt. Recounts are always taken, they replace a count the session already has.
+func (session *Session) accept(record *CountRecord) error {
+	switch record.Kind {
+	case CountOpening:
+		if session.OpeningCountID != 0 && record.RecountOf != session.OpeningCountID {
+			return &ValidationError{Field: "countKind", Reason: fmt.Sprintf("session %d already has an opening count", session.ID)}
+		}
+		return nil
+	case CountHandover:
+		switch {
+		case record.RecountOf != 0:
+			return nil
+		case session.State == SessionClosed:
+			return session.errState("handover")
+		case record.Cashier == "":
+			return &ValidationError{Field: "cashier", Reason: "value is empty"}
+		case session.State == SessionHandover && record.Cashier == session.Handovers[len(session.Handovers)-1].From:
+			return &ValidationError{Field: "cashier", Reason: "must not be the cashier handing over"}
+		}
+		return nil
+	case CountDrop:
+		if record.RecountOf == 0 && !session.isOpen() {
+			return session.errState("drops")
+		}
+		return nil
+	}
+	if record.RecountOf == 0 && !session.isOpen() {
+		return session.errState("counts")
+	}
+	record.Attempt = len(session.Attempts) + 1
+	return nil
+}
+
+// targetsExpected reports whether the target of a session count is the expected value of the
+// session: that of every count but the opening count, drops and recounts, which keep the target
+// of the count they replace.
+func (record *CountRecord) targetsExpected() bool {
+	return record.RecountOf == 0 && record.Kind != CountOpening && record.Kind != CountDrop
+}
+
+// attach adds a saved count to the session. The opening count sets the opening float, handover
+// counts start and complete a handover, drops are booked as skims, a closing count closes the
+// session and every other count is an attempt.
+func (session *Session) attach(record *CountRecord) {
+	switch record.Kind {
+	case CountOpening:
+		session.ExpectedValue += record.TotalValue - session.OpeningFloat
+		session.OpeningFloat, session.OpeningCountID = record.TotalValue, record.ID
+	case CountHandover:
+		session.attachHandover(record)
+	case CountDrop:
+		session.attachDrop(record)
+	default:
+		session.Attempts = append(session.Attempts, record.ID)
+		if record.Kind == CountClosing && session.State != SessionClosed {
+			closedAt := record.CreatedAt
+			session.State, session.ClosedAt = SessionClosed, &closedAt
+		}
+	}
+}
+
+// attachHandover starts a handover with the count of the outgoing cashier or completes it with
+// the count of the incoming one. A recount replaces the count it recounts.
+func (session *Session) attachHandover(record *CountRecord) {
+	if record.RecountOf != 0 {
+		for i := range session.Handovers {
+			handover := &session.Handovers[i]
+			switch record.RecountOf {
+			case handover.OutgoingCountID:
+				handover.OutgoingCountID, handover.OutgoingValue = record.ID, record.TotalValue
+			case handover.IncomingCountID:
+				handover.IncomingCountID, handover.IncomingValue = record.ID, record.TotalValue
+			default:
+				continue
+			}
+			if handover.CompletedAt != nil {
+				handover.DifferenceValue = handover.IncomingValue - handover.OutgoingValue
+			}
+		}
+		return
+	}
+	if session.State != SessionHandover {
+		session.Handovers = append(session.Handovers, Handover{
+			From:            record.Cashier,
+			OutgoingCountID: record.ID,
+			OutgoingValue:   record.TotalValue,
+			StartedAt:       record.CreatedAt,
+		})
+		session.State = SessionHandover
+		return
+	}
+	handover := &session.Handovers[len(session.Handovers)-1]
+	completedAt := record.CreatedAt
+	handover.To, handover.IncomingCountID, handover.IncomingValue = record.Cashier, record.ID, record.TotalValue
+	handover.DifferenceValue = handover.IncomingValue - handover.OutgoingValue
+	handover.CompletedAt = &completedAt
+	session.State, session.Cashier = SessionOpen, record.Cashier
+}
+
+// attachDrop books a drop as a skim of its value. A recount of a drop replaces the skim.
+func (session *Session) attachDrop(record *CountRecord) {
+	entry := SessionEntry{Type: EntrySkim, Amount: record.TotalValue, By: record.Cashier, At: record.CreatedAt, CountID: record.ID}
+	index := -1
+	if record.RecountOf != 0 {
+		index = slices.IndexFunc(session.Entries, func(e SessionEntry) bool { return e.CountID == record.RecountOf })
+	}
+	if index < 0 {
+		session.Entries = append(session.Entries, entry)
+	} else {
+		session.Skims -= session.Entries[index].Amount
+		session.ExpectedValue += session.Entries[index].Amount
+		session.Entries[index] = entry
+	}
+	session.Skims += entry.Amount
+	session.ExpectedValue -= entry.Amount
+}
+
+// book adds a sale, skim or refill to the session and updates the expected value.
+func (session *Session) book(entry SessionEntry) error {
+	if session.State == SessionClosed {
+		return session.errState(string(entry.Type) + "s")
+	}
+	switch entry.Type {
+	case EntrySale:
+		session.Sales += entry.Amount
+		session.ExpectedValue += entry.Amount
+	case EntrySkim:
+		if entry.Amount <= 0 {
+			return &ValidationError{Field: "amount", Reason: "must be positive"}
+		}
+		session.Skims += entry.Amount
+		session.ExpectedValue -= entry.Amount
+	case EntryRefill:
+		if entry.Amount <= 0 {
+			return &ValidationError{Field: "amount", Reason: "must be positive"}
+		}
+		session.ExpectedValue += entry.Amount
+	default:
+		return fmt.Errorf("unknown session entry type %q", entry.Type)
+	}
+	session.Entries = append(session.Entries, entry)
+	return nil
+}
+
+// CreateSession stores session under the next free ID and sets session.ID. If opening is not
+// nil, it is stored as the opening count of the session. The functions in then run last in the
+// transaction; if one fails, nothing is stored. A register has one session at a time: while
+// another session of the register is not closed, ErrConflict is returned.
+func (s *Store) CreateSession(session *Session, opening *CountRecord, then ...func(*bolt.Tx) error) error {
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = time.Now().UTC()
+	}
+	if session.Attempts == nil {
+		session.Attempts = []uint64{}
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sessionsBucket)
+		err := bucket.ForEach(func(key, data []byte) error {
+			var other Session
+			if err := json.Unmarshal(data, &other); err != nil {
+				return fmt.Errorf("session %d: %w", btoi(key), err)
+			}
+			if other.RegisterID == session.RegisterID && other.State != SessionClosed {
+				return fmt.Errorf("register %s has session %d %s: %w", other.RegisterID, other.ID, other.State, ErrConflict)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		session.ID = id
+		if err := putJSON(bucket, itob(id), session); err != nil {
+			return err
+		}
+		if opening != nil {
+			opening.SessionID = id
+			if err := saveSessionCount(tx, opening); err != nil {
+				return err
+			}
+		}
+		return runAll(tx, then)
+	})
+}
+
+// Session returns the session with the given ID or ErrNotFound.
+func (s *Store) Session(id uint64) (Session, error) {
+	var session Session
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(sessionsBucket), id, "session", &session)
+	})
+	return session, err
+}
+
+// UpdateSession applies update to the session with the given ID and stores the result in one
+// transaction, booking the entries update adds into the cash book, then runs the functions in
+// then in the same transaction. Nothing is stored if update or one of them returns an error.
+func (s *Store) UpdateSession(id uint64, update func(*Session) error, then ...func(*bolt.Tx) error) (Session, error) {
+	var session Session
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if session, err = updateSession(tx, id, update); err != nil {
+			return err
+		}
+		return runAll(tx, then)
+	})
+	return session, err
+}
+
+// updateSession is UpdateSession within the transaction tx.
+func updateSession(tx *bolt.Tx, id uint64, update func(*Session) error) (Session, error) {
+	bucket := tx.Bucket(sessionsBucket)
+	var session Session
+	if err := getJSON(bucket, id, "session", &session); err != nil {
+		return session, err
+	}
+	booked := len(session.Entries)
+	if err := update(&session); err != nil {
+		return session, err
+	}
+	if err := putJSON(bucket, itob(id), session); err != nil {
+		return session, err
+	}
+	return session, appendCashBook(tx, session.cashBookEntries(booked)...)
+}
+
+// DeleteSession removes the session with the given ID, as long as nothing was counted or booked in
+// it. check is called with the session first; if it returns an error, the session stays.
+func (s *Store) DeleteSession(id uint64, check func(*Session) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sessionsBucket)
+		var session Session
+		if err := getJSON(bucket, id, "session", &session); err != nil {
+			return err
+		}
+		if err := check(&session); err != nil {
+			return err
+		}
+		if err := session.checkUnused(); err != nil {
+			return err
+		}
+		return bucket.Delete(itob(id))
+	})
+}
+
+// ListSessions returns the sessions of the register, or of all registers if registerID is
+// empty, newest first.
+func (s *Store) ListSessions(registerID string) ([]Session, error) {
+	sessions := []Session{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(sessionsBucket).Cursor()
+		for key, data := cursor.Last(); key != nil; key, data = cursor.Prev() {
+			var session Session
+			if err := json.Unmarshal(data, &session); err != nil {
+				return fmt.Errorf("session %d: %w", btoi(key), err)
+			}
+			if registerID == "" || session.RegisterID == registerID {
+				sessions = append(sessions, session)
+			}
+		}
+		return nil
+	})
+	return sessions, err
+}
+
+// errExpectedChanged is returned by SaveSessionCount when the expected value of the session is
+// no longer the target of the count.
+var errExpectedChanged = errors.New("expected value of the session changed")
+
+// SaveSessionCount stores a count of the session record.SessionID and attaches it to the
+// session in the same transaction, or returns errExpectedChanged if the count was calculated
+// against an expected value the session no longer has. record.Attempt is set to the attempt
+// number. A drop is booked into the cash book as a skim. The functions in then run last in the
+// transaction; if one fails, nothing is stored.
+func (s *Store) SaveSessionCount(record *CountRecord, then ...func(*bolt.Tx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := saveSessionCount(tx, record); err != nil {
+			return err
+		}
+		return runAll(tx, then)
+	})
+}
+
+// saveSessionCount is SaveSessionCount within the transaction tx.
+func saveSessionCount(tx *bolt.Tx, record *CountRecord) error {
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now().UTC()
+	}
+	bucket := tx.Bucket(sessionsBucket)
+	var session Session
+	if err := getJSON(bucket, record.SessionID, "session", &session); err != nil {
+		return err
+	}
+	if err := session.accept(record); err != nil {
+		return err
+	}
+	if record.targetsExpected() && record.TargetValue != session.ExpectedValue {
+		return errExpectedChanged
+	}
+	if err := insertCount(tx, record); err != nil {
+		return err
+	}
+	booked := len(session.Entries)
+	session.attach(record)
+	if err := putJSON(bucket, itob(session.ID), session); err != nil {
+		return err
+	}
+	return appendCashBook(tx, session.cashBookEntries(booked)...)
+}
+
+// handleCreateSession opens a session for a register. The opening float is counted with the
+// request, or given as expected value if it is not counted. Once tenancy is enabled, the
+// register has to be a known one.
+func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
+	var request SessionRequest
+	if err := decodeStrict(r, &request); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	// the session is bound to the caller like the counts taken in it
+	owner := CountRecord{RegisterID: request.RegisterID, Cashier: request.Cashier}
+	if err := bindIdentity(r.Context(), &owner); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if owner.RegisterID == "" {
+		respondWithError(w, &ValidationError{Field: "registerId", Reason: "value is empty"})
+		return
+	}
+	var planned Money
+	if request.ExpectedValue != "" || request.Counts == nil {
+		var err error
+		if planned, err = ParseMoney(request.ExpectedValue); err != nil {
+			respondWithError(w, &ValidationError{Field: "expectedValue", Reason: err.Error()})
+			return
+		}
+	}
+	currency, ok := s.config.Catalog.Currency(request.Currency)
+	if !ok {
+		respondWithError(w, &ValidationError{Field: "currency", Reason: "unknown currency " + request.Currency})
+		return
+	}
+	if err := validateCountList(currency, request.Counts); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	storeID, err := s.store.RegisterStore(owner.RegisterID, s.config.Tenancy)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	session := Session{
+		RegisterID: owner.RegisterID,
+		StoreID:    storeID,
+		Cashier:    owner.Cashier,
+		Currency:   currency.Code,
+		State:      SessionOpen,
+		Blind:      request.Blind,
+	}
+	if request.Counts == nil {
+		session.OpeningFloat, session.ExpectedValue = planned, planned
+		if err := s.store.CreateSession(&session, nil); err != nil {
+			respondWithError(w, err)
+			return
+		}
+		respondWithJSON(w, session.Payload())
+		return
+	}
+
+	// an opening float counted without a planned float has nothing to differ from
+	if request.ExpectedValue == "" {
+		loose, rolls, boxes := SumCounts(currency, request.Counts)
+		planned = loose + rolls + boxes
+	}
+	record := NewCountRecord(currency, request.Counts, planned)
+	record.RegisterID, record.Cashier, record.Kind = session.RegisterID, session.Cashier, CountOpening
+	if err := s.prepareCount(r.Context(), &record); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := s.store.CreateSession(&session, &record, s.journalCount(&record)); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if session, err = s.store.Session(session.ID); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, session.Payload())
+}
+
+// handleListSessions returns the sessions, newest first, narrowed to one register by the
+// registerId query parameter. Hidden expected values stay hidden. Callers bound to a register
+// only see the sessions of that register.
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	registerID, err := boundRegister(r.Context(), r.URL.Query().Get("registerId"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	sessions, err := s.store.ListSessions(registerID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	payloads := make([]SessionPayload, len(sessions))
+	for i, session := range sessions {
+		payloads[i] = session.Payload()
+	}
+	respondWithJSON(w, payloads)
+}
+
+// handleGetSession returns the session with the ID given in the path.
+func (s *Server) handleGetSession(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r, "id")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	session, err := s.store.Session(id)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := checkRegister(r.Context(), session.RegisterID); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, session.Payload())
+}
+
+// handleUpdateSession changes the cashier, the currency, blind mode and the planned opening float
+// of the session in the path, as long as nothing was counted or booked in it. The register
+// cannot be changed, and the opening float is counted through /sessions/{id}/counts.
+func (s *Server) handleUpdateSession(w http.ResponseWriter, r *http.Request) {
+	var request SessionRequest
+	if err := decodeStrict(r, &request); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	id, err := parseID(r, "id")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if request.Counts != nil {
+		respondWithError(w, &ValidationError{Field: "counts", Reason: "the opening float is counted through /sessions/{id}/counts"})
+		return
+	}
+	planned, err := ParseMoney(request.ExpectedValue)
+	if err != nil {
+		respondWithError(w, &ValidationError{Field: "expectedValue", Reason: err.Error()})
+		return
+	}
+	currency, ok := s.config.Catalog.Currency(request.Currency)
+	if !ok {
+		respondWithError(w, &ValidationError{Field: "currency", Reason: "unknown currency " + request.Currency})
+		return
+	}
+
+	session, err := s.store.UpdateSession(id, func(session *Session) error {
+		if err := checkRegister(r.Context(), session.RegisterID); err != nil {
+			return err
+		}
+		if request.RegisterID != "" && request.RegisterID != session.RegisterID {
+			return &ValidationError{Field: "registerId", Reason: fmt.Sprintf("session %d belongs to register %s", session.ID, session.RegisterID)}
+		}
+		if err := session.checkUnused(); err != nil {
+			return err
+		}
+		session.Cashier, session.Currency, session.Blind = request.Cashier, currency.Code, request.Blind
+		session.OpeningFloat, session.ExpectedValue = planned, planned
+		return nil
+	})
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, session.Payload())
+}
+
+// handleDeleteSession removes the session in the path if nothing was counted or booked in it.
+func (s *Server) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r, "id")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	err = s.store.DeleteSession(id, func(session *Session) error {
+		return checkRegister(r.Context(), session.RegisterID)
+	})
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSessionCount commits a mid-shift or closing count to the session in the path and only
+// then responds with the difference to the expected value. Every further count is saved as a
+// new attempt; a closing count closes the session.
+func (s *Server) handleSessionCount(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r, "id")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	var request SessionCountRequest
+	if err := decodeStrict(r, &request); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if err := request.CountKind.Validate(); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if request.CountKind == CountOpening || request.CountKind == CountHandover || request.CountKind == CountDrop {
+		respondWithError(w, &ValidationError{Field: "countKind", Reason: fmt.Sprintf("%s counts have their own endpoint", request.CountKind)})
+		return
+	}
+	s.countSession(w, r, id, request)
+}
+
+// handleHandover takes the count of the outgoing cashier, which starts a handover, or of the
+// incoming cashier, which completes it. The response carries the difference of the count to
+// the expected value; the session lists the difference between the two counts.
+func (s *Server) handleHandover(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r, "id")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	var request HandoverRequest
+	if err := decodeStrict(r, &request); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	s.countSession(w, r, id, SessionCountRequest{
+		Cashier:   request.Cashier,
+		CountKind: CountHandover,
+		Currency:  request.Currency,
+		Counts:    request.Counts,
+	})
+}
+
+// countSession calculates a count of the session id against its expected value, saves it and
+// responds with the calculated values. If the expected value changes between reading the session
+// and saving the count, by a sale or skim booked in between, the count is calculated again. A
+// handover count of a blind session is no attempt, so its response does not give away the
+// expected value.
+func (s *Server) countSession(w http.ResponseWriter, r *http.Request, id uint64, request SessionCountRequest) {
+	for try := 1; ; try++ {
+		session, err := s.store.Session(id)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		if request.Currency == "" {
+			request.Currency = session.Currency
+		}
+
+		responsePayload, err := calculateCounts(&s.config.Catalog, CountRequest{
+			Currency:    request.Currency,
+			TargetValue: session.ExpectedValue.String(),
+			Counts:      request.Counts,
+		})
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+
+		currency, _ := s.config.Catalog.Currency(request.Currency)
+		record := NewCountRecord(currency, request.Counts, session.ExpectedValue)
+		record.RegisterID, record.Cashier, record.Kind = session.RegisterID, request.Cashier, request.CountKind
+		record.SessionID = session.ID
+		err = s.saveCount(r.Context(), &record)
+		if errors.Is(err, errExpectedChanged) && try < 3 {
+			continue
+		}
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		responsePayload.CountID = record.ID
+		responsePayload.Attempt = record.Attempt
+		responsePayload.Classification = record.Classification
+		responsePayload.Status = record.Status
+		if session.Blind && len(session.Attempts) == 0 && record.Kind == CountHandover {
+			responsePayload.ResponseValues.DifferenceValue = ""
+			responsePayload.Classification, responsePayload.Status = nil, ""
+		}
+		respondWithJSON(w, responsePayload)
+		return
+	}
+}
+
+// handleSale books a sale into the session in the path. Refunds are booked as negative sales.
+func (s *Server) handleSale(w http.ResponseWriter, r *http.Request) {
+	s.bookEntry(w, r, EntrySale)
+}
+
+// handleSkim books cash taken out of the drawer of the session in the path.
+func (s *Server) handleSkim(w http.ResponseWriter, r *http.Request) {
+	s.bookEntry(w, r, EntrySkim)
+}
+
+// bookEntry books a sale or skim into the session in the path, appends it to the audit journal
+// and responds with the session.
+func (s *Server) bookEntry(w http.ResponseWriter, r *http.Request, entryType EntryType) {
+	id, err := parseID(r, "id")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	var request SessionEntryRequest
+	if err := decodeStrict(r, &request); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	amount, err := ParseMoney(request.Amount)
+	if err != nil {
+		respondWithError(w, &ValidationError{Field: "amount", Reason: err.Error()})
+		return
+	}
+
+	entry := SessionEntry{Type: entryType, Amount: amount, Reference: request.Reference, At: time.Now().UTC()}
+	if identity, ok := identityFrom(r.Context()); ok {
+		entry.By = identity.User()
+	}
+	session, err := s.bookSessionEntry(r.Context(), id, entry)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, session.Payload())
+}
+
+// bookSessionEntry books entry into the session id, bound to the register of the caller, and
+// appends it to the audit journal in the same transaction.
+func (s *Server) bookSessionEntry(ctx context.Context, id uint64, entry SessionEntry) (Session, error) {
+	identity, bound := identityFrom(ctx)
+	journal := func(*bolt.Tx) error {
+		_, err := s.journal.Append(SessionEvent{SessionID: id, SessionEntry: entry})
+		return err
+	}
+	return s.store.UpdateSession(id, func(session *Session) error {
+		if bound && identity.RegisterID != "" && identity.RegisterID != session.RegisterID {
+			return &ValidationError{Field: "registerId", Reason: fmt.Sprintf("the credentials are bound to %q", identity.RegisterID)}
+		}
+		return session.book(entry)
+	}, journal)
+}
+
+// decodeStrict decodes the JSON body of r into value and rejects unknown fields.
+func decodeStrict(r *http.Request, value any) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(value); err != nil {
+		return fmt.Errorf("error decoding payload: %w", err)
+	}
+	return nil
+}
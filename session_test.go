@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveJSON sends a request with the given JSON body to handler and returns the recorder.
+func serveJSON(handler http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	var req *http.Request
+	if body == "" {
+		req = httptest.NewRequest(method, target, nil)
+	} else {
+		req = httptest.NewRequest(method, target, strings.NewReader(body))
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestBlindSession(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.routes()
+
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","blind":true,"expectedValue":"120,00"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var session SessionPayload
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&session))
+	assert.Equal(t, uint64(1), session.ID)
+	assert.Nil(t, session.ExpectedValue)
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/sessions/1", "")
+	assert.JSONEq(t, `null`, jsonField(t, rec.Body.Bytes(), "expectedValue"))
+
+	// the target cannot be sent along with a session count
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/counts", `{"targetValue":"0","counts":[]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/counts",
+		`{"cashier":"anna","counts":[{"denomination":"euro50","form":"loose","quantity":2}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var response ResponsePayload
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Equal(t, 1, response.Attempt)
+	assert.Equal(t, "-20,00", response.ResponseValues.DifferenceValue)
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/counts",
+		`{"cashier":"anna","counts":[{"denomination":"euro50","form":"loose","quantity":2},{"denomination":"euro20","form":"loose","quantity":1}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	response = ResponsePayload{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Equal(t, 2, response.Attempt)
+	assert.Equal(t, "0,00", response.ResponseValues.DifferenceValue)
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/sessions/1", "")
+	session = SessionPayload{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&session))
+	assert.Equal(t, Money(12000), *session.ExpectedValue)
+	assert.Equal(t, []uint64{1, 2}, session.Attempts)
+
+	record, err := server.store.Count(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "R1", record.RegisterID)
+	assert.Equal(t, uint64(1), record.SessionID)
+	assert.Equal(t, 1, record.Attempt)
+	assert.Equal(t, Money(12000), record.TargetValue)
+}
+
+func TestSessionErrors(t *testing.T) {
+	handler := newTestServer(t).routes()
+
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"","expectedValue":"1"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","expectedValue":"x"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions/7/counts", `{"counts":[]}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","expectedValue":"5"}`)
+	var session SessionPayload
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&session))
+	assert.Equal(t, Money(500), *session.ExpectedValue)
+}
+
+// decodeSession decodes the session in the body of rec.
+func decodeSession(t *testing.T, rec *httptest.ResponseRecorder) SessionPayload {
+	t.Helper()
+	var session SessionPayload
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&session))
+	return session
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	handler := newTestServer(t).routes()
+	fifties := `[{"denomination":"euro50","form":"loose","quantity":2}]`
+
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/sessions",
+		`{"registerId":"R1","cashier":"anna","expectedValue":"150,00","counts":`+fifties+`}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	session := decodeSession(t, rec)
+	assert.Equal(t, SessionOpen, session.State)
+	assert.Equal(t, uint64(1), session.OpeningCountID)
+	assert.Equal(t, Money(10000), session.OpeningFloat)
+	assert.Equal(t, Money(10000), *session.ExpectedValue)
+
+	for _, booking := range []struct{ path, amount string }{
+		{"sales", "250,00"}, {"sales", "-50,00"}, {"skims", "200,00"},
+	} {
+		rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/"+booking.path, `{"amount":"`+booking.amount+`"}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	session = decodeSession(t, rec)
+	assert.Equal(t, Money(20000), *session.Sales)
+	assert.Equal(t, Money(20000), session.Skims)
+	assert.Equal(t, Money(10000), *session.ExpectedValue)
+	assert.Len(t, session.Entries, 3)
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/skims", `{"amount":"-1"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/counts", `{"cashier":"anna","counts":`+fifties+`}`)
+	assert.JSONEq(t, `"0,00"`, jsonField(t, []byte(jsonField(t, rec.Body.Bytes(), "responseValues")), "differenceValue"))
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/counts", `{"countKind":"opening","counts":[]}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	// both cashiers count the drawer at the handover
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/handover", `{"cashier":"anna","counts":`+fifties+`}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/counts", `{"cashier":"anna","counts":`+fifties+`}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/handover", `{"cashier":"anna","counts":`+fifties+`}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/handover",
+		`{"cashier":"max","counts":[{"denomination":"euro50","form":"loose","quantity":1},{"denomination":"euro20","form":"loose","quantity":2}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/sessions/1", "")
+	session = decodeSession(t, rec)
+	assert.Equal(t, SessionOpen, session.State)
+	assert.Equal(t, "max", session.Cashier)
+	assert.Len(t, session.Handovers, 1)
+	assert.Equal(t, "anna", session.Handovers[0].From)
+	assert.Equal(t, "max", session.Handovers[0].To)
+	assert.Equal(t, Money(-1000), session.Handovers[0].DifferenceValue)
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/counts", `{"cashier":"max","countKind":"closing","counts":`+fifties+`}`)
+	assert.JSONEq(t, `2`, jsonField(t, rec.Body.Bytes(), "attempt"))
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/sessions/1", "")
+	session = decodeSession(t, rec)
+	assert.Equal(t, SessionClosed, session.State)
+	assert.NotNil(t, session.ClosedAt)
+	assert.Equal(t, []uint64{2, 5}, session.Attempts)
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/sales", `{"amount":"1"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/counts", `{"counts":[]}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
+
+func TestOneOpenSessionPerRegister(t *testing.T) {
+	handler := newTestServer(t).routes()
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","expectedValue":"0"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","expectedValue":"0"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/counts", `{"countKind":"closing","counts":[]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","expectedValue":"0"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestCreateSessionRollsBackWithoutJournal(t *testing.T) {
+	server := newTestServer(t)
+	assert.NoError(t, server.journal.Close())
+
+	rec := serveJSON(server.routes(), http.MethodPost, "/api/v1/sessions",
+		`{"registerId":"R1","expectedValue":"100,00","counts":[{"denomination":"euro50","form":"loose","quantity":2}]}`)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	sessions, err := server.store.ListSessions("")
+	assert.NoError(t, err)
+	assert.Empty(t, sessions)
+	_, err = server.store.Count(1)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestBlindSessionHidesSales(t *testing.T) {
+	handler := newTestServer(t).routes()
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","blind":true,"expectedValue":"100,00"}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/skims", `{"amount":"10,00"}`)
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/sales", `{"amount":"30,00","reference":"bon 4711"}`)
+
+	session := decodeSession(t, rec)
+	assert.Nil(t, session.ExpectedValue)
+	assert.Nil(t, session.Sales)
+	assert.Equal(t, Money(10000), session.OpeningFloat)
+	assert.Len(t, session.Entries, 1)
+	assert.Equal(t, EntrySkim, session.Entries[0].Type)
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/counts", `{"counts":[]}`)
+	assert.JSONEq(t, `"-120,00"`, jsonField(t, []byte(jsonField(t, rec.Body.Bytes(), "responseValues")), "differenceValue"))
+}
+
+// jsonField returns the raw JSON of the top-level field name in data.
+func jsonField(t *testing.T, data []byte, name string) string {
+	t.Helper()
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	return string(fields[name])
+}
+
+func TestBlindHandoverHidesDifference(t *testing.T) {
+	handler := newTestServer(t).routes()
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","blind":true,"expectedValue":"100,00"}`)
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/handover",
+		`{"cashier":"anna","counts":[{"denomination":"euro50","form":"loose","quantity":1}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var response ResponsePayload
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Equal(t, "50,00", response.ResponseValues.TotalValue)
+	assert.Empty(t, response.ResponseValues.DifferenceValue)
+	assert.Nil(t, response.Classification)
+	assert.Empty(t, response.Status)
+}
+
+func TestSaveSessionCountChecksExpectedValue(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.routes()
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","expectedValue":"100,00"}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/sales", `{"amount":"30,00"}`)
+
+	// the count was calculated before the sale was booked
+	record := CountRecord{RegisterID: "R1", SessionID: 1, Kind: CountInterim, TargetValue: 10000}
+	assert.ErrorIs(t, server.store.SaveSessionCount(&record), errExpectedChanged)
+	records, err := server.store.ListCounts(CountFilter{})
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+
+	record = CountRecord{RegisterID: "R1", SessionID: 1, Kind: CountInterim, TargetValue: 13000}
+	assert.NoError(t, server.store.SaveSessionCount(&record))
+}
+
+func TestUpdateAndDeleteSession(t *testing.T) {
+	handler := newTestServer(t).routes()
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","cashier":"anna","expectedValue":"100,00"}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R2","expectedValue":"100,00"}`)
+
+	rec := serveJSON(handler, http.MethodPut, "/api/v1/sessions/1", `{"cashier":"ben","blind":true,"expectedValue":"150,00"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	session := decodeSession(t, rec)
+	assert.Equal(t, "ben", session.Cashier)
+	assert.True(t, session.Blind)
+	assert.Equal(t, Money(15000), session.OpeningFloat)
+	assert.Nil(t, session.ExpectedValue)
+
+	tests := []struct {
+		body  string
+		field string
+	}{
+		{`{"registerId":"R2","expectedValue":"1"}`, "registerId"},
+		{`{"expectedValue":"1","counts":[]}`, "counts"},
+		{`{"expectedValue":"x"}`, "expectedValue"},
+		{`{"expectedValue":"1","currency":"XXX"}`, "currency"},
+	}
+	for _, tt := range tests {
+		rec = serveJSON(handler, http.MethodPut, "/api/v1/sessions/1", tt.body)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, tt.body)
+		assert.Contains(t, rec.Body.String(), `"field":"`+tt.field+`"`, tt.body)
+	}
+	rec = serveJSON(handler, http.MethodPut, "/api/v1/sessions/9", `{"expectedValue":"1"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// once something is booked the session belongs to the books
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions/1/sales", `{"amount":"10,00"}`)
+	rec = serveJSON(handler, http.MethodPut, "/api/v1/sessions/1", `{"expectedValue":"1"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec = serveJSON(handler, http.MethodDelete, "/api/v1/sessions/1", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = serveJSON(handler, http.MethodDelete, "/api/v1/sessions/2", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/sessions/2", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = serveJSON(handler, http.MethodDelete, "/api/v1/sessions/2", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BankAccount is the account deposit slips are made out to. Branch is used for slips whose
+// request does not name one.
+type BankAccount struct {
+	Branch        string `json:"branch"`
+	AccountHolder string `json:"accountHolder"`
+	IBAN          string `json:"iban"`
+}
+
+// SlipRequest turns a stored count into a deposit slip. Without counts what is left of the count
+// after earlier slips is deposited, otherwise only the given counts, which must not exceed that.
+// Date defaults to today.
+type SlipRequest struct {
+	CountID uint64  `json:"countId"`
+	Branch  string  `json:"branch,omitempty"`
+	Date    string  `json:"date,omitempty"`
+	Counts  []Count `json:"counts,omitempty"`
+}
+
+// SlipLine is one denomination on a deposit slip. Quantity is the number of notes, coins,
+// rolls or boxes, Pieces the number of notes or coins they hold.
+type SlipLine struct {
+	Denomination string `json:"denomination"`
+	FaceValue    Money  `json:"faceValue"`
+	Quantity     int    `json:"quantity"`
+	Pieces       int    `json:"pieces"`
+	TotalValue   Money  `json:"totalValue"`
+}
+
+// DepositSlip lists the cash handed to the bank. Slips are numbered without gaps in the order
+// they were issued. All amounts are in cents.
+type DepositSlip struct {
+	Number        uint64     `json:"number"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	Date          string     `json:"date"`
+	Branch        string     `json:"branch"`
+	AccountHolder string     `json:"accountHolder,omitempty"`
+	IBAN          string     `json:"iban,omitempty"`
+	CountID       uint64     `json:"countId"`
+	RegisterID    string     `json:"registerId"`
+	Currency      string     `json:"currency"`
+	Notes         []SlipLine `json:"notes"`
+	Coins         []SlipLine `json:"coins"`
+	Rolls         []SlipLine `json:"rolls"`
+	Boxes         []SlipLine `json:"boxes"`
+	NoteValue     Money      `json:"noteValue"`
+	CoinValue     Money      `json:"coinValue"`
+	RollValue     Money      `json:"rollValue"`
+	BoxValue      Money      `json:"boxValue"`
+	TotalValue    Money      `json:"totalValue"`
+}
+
+// BuildDepositSlip lists validated counts by denomination: loose notes, loose coins, rolls and
+// boxes. The totals of rolls and boxes are calculated with CalculateRollValues and
+// CalculateBoxValues, so the slip shows the same figures as the calculate endpoints.
+func BuildDepositSlip(currency *Currency, counts []Count) DepositSlip {
+	quantities := make(map[countKey]int)
+	for _, c := range counts {
+		quantities[countKey{c.Denomination, c.Form}] += c.Quantity
+	}
+
+	slip := DepositSlip{
+		Currency: currency.Code,
+		Notes:    []SlipLine{},
+		Coins:    []SlipLine{},
+		Rolls:    []SlipLine{},
+		Boxes:    []SlipLine{},
+	}
+	notes, coins := RequestValues{}, RequestValues{}
+	rolls, boxes := RollValues{}, BoxValues{}
+	for _, d := range currency.Denominations {
+		line := func(form CountForm, pieces int) SlipLine {
+			quantity := quantities[countKey{d.Code, form}]
+			return SlipLine{
+				Denomination: d.Code,
+				FaceValue:    d.Value,
+				Quantity:     quantity,
+				Pieces:       quantity * pieces,
+				TotalValue:   CountValue(&d, form, quantity),
+			}
+		}
+
+		if quantity := quantities[countKey{d.Code, FormLoose}]; quantity > 0 {
+			if d.Kind == KindNote {
+				slip.Notes = append(slip.Notes, line(FormLoose, 1))
+				notes[d.Code] = [5]int{quantity}
+			} else {
+				slip.Coins = append(slip.Coins, line(FormLoose, 1))
+				coins[d.Code] = [5]int{quantity}
+			}
+		}
+		if quantity := quantities[countKey{d.Code, FormRoll}]; quantity > 0 {
+			slip.Rolls = append(slip.Rolls, line(FormRoll, d.RollSize))
+			rolls[d.Code] = [2]int{quantity}
+		}
+		if quantity := quantities[countKey{d.Code, FormBox}]; quantity > 0 {
+			slip.Boxes = append(slip.Boxes, line(FormBox, d.RollSize*d.RollsPerBox))
+			boxes[d.Code] = [1]int{quantity}
+		}
+	}
+
+	slip.NoteValue = CalculateDailyValues(currency, notes)
+	slip.CoinValue = CalculateDailyValues(currency, coins)
+	slip.RollValue = CalculateRollValues(currency, rolls)
+	slip.BoxValue = CalculateBoxValues(currency, boxes)
+	slip.TotalValue = slip.NoteValue + slip.CoinValue + slip.RollValue + slip.BoxValue
+	return slip
+}
+
+// checkSubset returns a ValidationError if counts hold more of a denomination in a form than
+// counted does.
+func checkSubset(counts, counted []Count) error {
+	available := make(map[countKey]int)
+	for _, c := range counted {
+		available[countKey{c.Denomination, c.Form}] += c.Quantity
+	}
+	for i, c := range counts {
+		key := countKey{c.Denomination, c.Form}
+		available[key] -= c.Quantity
+		if available[key] < 0 {
+			return &ValidationError{Field: "counts[" + strconv.Itoa(i) + "].quantity", Reason: "exceeds what is left of the count"}
+		}
+	}
+	return nil
+}
+
+// Counts returns the notes, coins, rolls and boxes on the slip as counts.
+func (slip *DepositSlip) Counts() []Count {
+	var counts []Count
+	for _, group := range []struct {
+		form  CountForm
+		lines []SlipLine
+	}{{FormLoose, slip.Notes}, {FormLoose, slip.Coins}, {FormRoll, slip.Rolls}, {FormBox, slip.Boxes}} {
+		for _, line := range group.lines {
+			counts = append(counts, Count{Denomination: line.Denomination, Form: group.form, Quantity: line.Quantity})
+		}
+	}
+	return counts
+}
+
+// remainingCounts returns what is left of counted once deposited is taken away, in the order
+// the denominations and forms first appear in counted.
+func remainingCounts(counted, deposited []Count) []Count {
+	left := make(map[countKey]int)
+	var keys []countKey
+	for _, c := range counted {
+		key := countKey{c.Denomination, c.Form}
+		if _, ok := left[key]; !ok {
+			keys = append(keys, key)
+		}
+		left[key] += c.Quantity
+	}
+	for _, c := range deposited {
+		left[countKey{c.Denomination, c.Form}] -= c.Quantity
+	}
+	var counts []Count
+	for _, key := range keys {
+		if left[key] > 0 {
+			counts = append(counts, Count{Denomination: key.denomination, Form: key.form, Quantity: left[key]})
+		}
+	}
+	return counts
+}
+
+// depositedCounts returns the counts on the slips already issued for the count.
+func depositedCounts(tx *bolt.Tx, countID uint64) ([]Count, error) {
+	var counts []Count
+	err := tx.Bucket(slipsBucket).ForEach(func(key, data []byte) error {
+		var slip DepositSlip
+		if err := json.Unmarshal(data, &slip); err != nil {
+			return fmt.Errorf("deposit slip %d: %w", btoi(key), err)
+		}
+		if slip.CountID == countID {
+			counts = append(counts, slip.Counts()...)
+		}
+		return nil
+	})
+	return counts, err
+}
+
+// RemainingCounts returns what is left of the counted record after the deposit slips already
+// issued for it.
+func (s *Store) RemainingCounts(record *CountRecord) ([]Count, error) {
+	var counts []Count
+	err := s.db.View(func(tx *bolt.Tx) error {
+		deposited, err := depositedCounts(tx, record.ID)
+		counts = remainingCounts(record.Counts, deposited)
+		return err
+	})
+	return counts, err
+}
+
+// SaveSlip stores slip under the next slip number, sets slip.Number and books the deposit into
+// the cash book. A slip holding more than is left of its count after the slips already issued
+// for it is not stored. The functions in then run last in the transaction; if one fails,
+// nothing is stored.
+func (s *Store) SaveSlip(slip *DepositSlip, then ...func(*bolt.Tx) error) error {
+	if slip.CreatedAt.IsZero() {
+		slip.CreatedAt = time.Now().UTC()
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var record CountRecord
+		if err := getJSON(tx.Bucket(countsBucket), slip.CountID, "count", &record); err != nil {
+			return err
+		}
+		deposited, err := depositedCounts(tx, slip.CountID)
+		if err != nil {
+			return err
+		}
+		if err := checkSubset(slip.Counts(), remainingCounts(record.Counts, deposited)); err != nil {
+			return &ValidationError{Field: "counts", Reason: fmt.Sprintf("exceeds what is left of count %d", slip.CountID)}
+		}
+
+		bucket := tx.Bucket(slipsBucket)
+		number, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		slip.Number = number
+		if err := putJSON(bucket, itob(number), slip); err != nil {
+			return err
+		}
+		if err := appendCashBook(tx, slipEntry(slip)); err != nil {
+			return err
+		}
+		return runAll(tx, then)
+	})
+}
+
+// Slip returns the deposit slip with the given number or ErrNotFound.
+func (s *Store) Slip(number uint64) (DepositSlip, error) {
+	var slip DepositSlip
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(slipsBucket), number, "deposit slip", &slip)
+	})
+	return slip, err
+}
+
+// handleCreateDepositSlip issues a deposit slip for a stored count, or a part of it, and responds
+// with the slip as JSON or, with "format=pdf", as PDF. Only what is left of the count after the
+// slips already issued for it can be deposited; drops and counts that are pending, rejected or
+// superseded cannot be deposited at all. Callers bound to a register can only deposit its counts.
+// If the count belongs to a store, the slip takes the cash out of the safe of the store in the
+// same transaction, and is refused if the safe does not hold it.
+func (s *Server) handleCreateDepositSlip(w http.ResponseWriter, r *http.Request) {
+	var request SlipRequest
+	if err := decodeStrict(r, &request); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if request.CountID == 0 {
+		respondWithError(w, &ValidationError{Field: "countId", Reason: "value is empty"})
+		return
+	}
+	record, err := s.store.Count(request.CountID)
+	if errors.Is(err, ErrNotFound) {
+		err = &ValidationError{Field: "countId", Reason: fmt.Sprintf("unknown count %d", request.CountID)}
+	}
+	if err == nil {
+		err = checkRegister(r.Context(), record.RegisterID)
+	}
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	currency, ok := s.config.Catalog.Currency(record.Currency)
+	if !ok {
+		respondWithError(w, fmt.Errorf("count %d uses currency %s which is no longer configured", record.ID, record.Currency))
+		return
+	}
+
+	switch {
+	case record.Kind == CountDrop:
+		respondWithError(w, &ValidationError{Field: "countId", Reason: fmt.Sprintf("count %d is a drop into the safe", record.ID)})
+		return
+	case record.Status == StatusPending, record.Status == StatusRejected, record.Status == StatusSuperseded:
+		respondWithError(w, &ValidationError{Field: "countId", Reason: fmt.Sprintf("count %d is %s", record.ID, record.Status)})
+		return
+	}
+	counts, err := s.store.RemainingCounts(&record)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if len(counts) == 0 {
+		respondWithError(w, &ValidationError{Field: "countId", Reason: fmt.Sprintf("count %d is already deposited", record.ID)})
+		return
+	}
+	if len(request.Counts) > 0 {
+		if err := validateCountList(currency, request.Counts); err != nil {
+			respondWithError(w, err)
+			return
+		}
+		if err := checkSubset(request.Counts, counts); err != nil {
+			respondWithError(w, err)
+			return
+		}
+		counts = request.Counts
+	}
+
+	branch := request.Branch
+	if branch == "" {
+		branch = s.config.Bank.Branch
+	}
+	if branch == "" {
+		respondWithError(w, &ValidationError{Field: "branch", Reason: "value is empty"})
+		return
+	}
+	date := time.Now()
+	if request.Date != "" {
+		date, err = time.ParseInLocation(time.DateOnly, request.Date, time.Local)
+		if err != nil {
+			respondWithError(w, &ValidationError{Field: "date", Reason: "must be a date (YYYY-MM-DD)"})
+			return
+		}
+	}
+
+	slip := BuildDepositSlip(currency, counts)
+	slip.Date = date.Format(time.DateOnly)
+	slip.Branch = branch
+	slip.AccountHolder = s.config.Bank.AccountHolder
+	slip.IBAN = s.config.Bank.IBAN
+	slip.CountID = record.ID
+	slip.RegisterID = record.RegisterID
+	// the slip number is only known once the slip is stored
+	var then []func(*bolt.Tx) error
+	if record.StoreID != "" {
+		then = append(then, func(tx *bolt.Tx) error {
+			movement := slipMovement(&slip, record.StoreID)
+			return insertSafeMovement(tx, &movement)
+		})
+	}
+	if err := s.store.SaveSlip(&slip, then...); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithSlip(w, r, slip)
+}
+
+// handleGetDepositSlip returns the deposit slip with the number given in the path as JSON or,
+// with "format=pdf", as PDF. Callers bound to a register only get the slips of that register.
+func (s *Server) handleGetDepositSlip(w http.ResponseWriter, r *http.Request) {
+	number, err := parseID(r, "number")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	slip, err := s.store.Slip(number)
+	if err == nil {
+		err = checkRegister(r.Context(), slip.RegisterID)
+	}
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithSlip(w, r, slip)
+}
+
+// respondWithSlip writes slip in the format given in the "format" query parameter.
+func respondWithSlip(w http.ResponseWriter, r *http.Request, slip DepositSlip) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		respondWithJSON(w, slip)
+	case "pdf":
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="einzahlung-%06d.pdf"`, slip.Number))
+		if err := renderPDF(w, slipPage(slip)); err != nil {
+			respondWithError(w, err)
+		}
+	default:
+		respondWithError(w, &ValidationError{Field: "format", Reason: fmt.Sprintf("unknown format %q", format)})
+	}
+}
+
+// slipPage lays out a deposit slip as a German A4 form.
+func slipPage(slip DepositSlip) pdfPage {
+	const left, right = 56.0, 539.0
+	var page pdfPage
+	y := 780.0
+	text := func(x float64, size float64, bold, alignRight bool, s string) {
+		page.Texts = append(page.Texts, pdfText{X: x, Y: y, Size: size, Bold: bold, Right: alignRight, Text: s})
+	}
+
+	text(left, 18, true, false, "Einzahlungsbeleg")
+	text(right, 11, false, true, fmt.Sprintf("Beleg-Nr. %06d", slip.Number))
+	y -= 30
+	date, _ := time.Parse(time.DateOnly, slip.Date)
+	for _, field := range [][2]string{
+		{"Filiale", slip.Branch},
+		{"Datum", date.Format("02.01.2006")},
+		{"Kontoinhaber", slip.AccountHolder},
+		{"IBAN", slip.IBAN},
+		{"Kasse", slip.RegisterID},
+		{"Zählung", strconv.FormatUint(slip.CountID, 10)},
+		{"Währung", slip.Currency},
+	} {
+		if field[1] == "" {
+			continue
+		}
+		text(left, 10, true, false, field[0]+":")
+		text(left+90, 10, false, false, field[1])
+		y -= 14
+	}
+
+	for _, section := range []struct {
+		title string
+		unit  string
+		lines []SlipLine
+		total Money
+	}{
+		{"Banknoten", "Scheine", slip.Notes, slip.NoteValue},
+		{"Münzen", "Münzen", slip.Coins, slip.CoinValue},
+		{"Münzrollen", "Rollen", slip.Rolls, slip.RollValue},
+		{"Kartons", "Kartons", slip.Boxes, slip.BoxValue},
+	} {
+		if len(section.lines) == 0 {
+			continue
+		}
+		y -= 16
+		text(left, 12, true, false, section.title)
+		y -= 16
+		text(left, 9, true, false, "Nennwert")
+		text(300, 9, true, true, section.unit)
+		text(400, 9, true, true, "Stück")
+		text(right, 9, true, true, "Betrag")
+		page.Rules = append(page.Rules, pdfRule{X1: left, X2: right, Y: y - 4})
+		for _, line := range section.lines {
+			y -= 14
+			text(left, 10, false, false, FormatNumber(line.FaceValue))
+			text(300, 10, false, true, strconv.Itoa(line.Quantity))
+			text(400, 10, false, true, strconv.Itoa(line.Pieces))
+			text(right, 10, false, true, FormatNumber(line.TotalValue))
+		}
+		page.Rules = append(page.Rules, pdfRule{X1: left, X2: right, Y: y - 4})
+		y -= 14
+		text(left, 10, true, false, "Summe "+section.title)
+		text(right, 10, true, true, FormatNumber(section.total))
+	}
+
+	y -= 28
+	page.Rules = append(page.Rules, pdfRule{X1: left, X2: right, Y: y + 14})
+	text(left, 12, true, false, "Gesamtbetrag "+slip.Currency)
+	text(right, 12, true, true, FormatNumber(slip.TotalValue))
+
+	y -= 70
+	page.Rules = append(page.Rules, pdfRule{X1: left, X2: left + 200, Y: y + 12}, pdfRule{X1: right - 200, X2: right, Y: y + 12})
+	text(left, 9, false, false, "Unterschrift Einzahler")
+	text(right-200, 9, false, false, "Unterschrift Bank")
+	return page
+}
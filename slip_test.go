@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildDepositSlip(t *testing.T) {
+	currency := euro()
+	counts := []Count{
+		{Denomination: "euro50", Form: FormLoose, Quantity: 2},
+		{Denomination: "euro50", Form: FormLoose, Quantity: 1, Column: "2"},
+		{Denomination: "euro2", Form: FormLoose, Quantity: 4},
+		{Denomination: "euro2", Form: FormRoll, Quantity: 2},
+		{Denomination: "cent10", Form: FormBox, Quantity: 1},
+	}
+
+	slip := BuildDepositSlip(currency, counts)
+	assert.Equal(t, []SlipLine{{Denomination: "euro50", FaceValue: 5000, Quantity: 3, Pieces: 3, TotalValue: 15000}}, slip.Notes)
+	assert.Equal(t, []SlipLine{{Denomination: "euro2", FaceValue: 200, Quantity: 4, Pieces: 4, TotalValue: 800}}, slip.Coins)
+	assert.Equal(t, []SlipLine{{Denomination: "euro2", FaceValue: 200, Quantity: 2, Pieces: 50, TotalValue: 10000}}, slip.Rolls)
+	assert.Equal(t, []SlipLine{{Denomination: "cent10", FaceValue: 10, Quantity: 1, Pieces: 120, TotalValue: 1200}}, slip.Boxes)
+	assert.Equal(t, Money(15000), slip.NoteValue)
+	assert.Equal(t, Money(800), slip.CoinValue)
+	assert.Equal(t, CalculateRollValues(currency, RollValues{"euro2": {1, 1}}), slip.RollValue)
+	assert.Equal(t, CalculateBoxValues(currency, BoxValues{"cent10": {1}}), slip.BoxValue)
+	assert.Equal(t, Money(27000), slip.TotalValue)
+}
+
+func TestDepositSlipEndpoints(t *testing.T) {
+	server := newTestServer(t)
+	server.config.Bank = BankAccount{Branch: "Hauptstelle", AccountHolder: "Kiosk am Markt", IBAN: "DE02120300000000202051"}
+	handler := server.routes()
+
+	rec := serveJSON(handler, http.MethodPost, "/api/v2/calculate", `{"registerId":"R1","targetValue":"150,00","counts":[
+		{"denomination":"euro20","form":"loose","quantity":5},
+		{"denomination":"euro1","form":"roll","quantity":2}
+	]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// a part of it
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/deposit-slips",
+		`{"countId":1,"branch":"Filiale Nord","counts":[{"denomination":"euro20","form":"loose","quantity":3}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var slip DepositSlip
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&slip))
+	assert.Equal(t, uint64(1), slip.Number)
+	assert.Equal(t, "Filiale Nord", slip.Branch)
+	assert.Equal(t, Money(6000), slip.TotalValue)
+	assert.Empty(t, slip.Rolls)
+
+	// the rest of it with the configured branch, numbered next
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/deposit-slips", `{"countId":1,"date":"2026-10-17"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	slip = DepositSlip{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&slip))
+	assert.Equal(t, uint64(2), slip.Number)
+	assert.Equal(t, "Hauptstelle", slip.Branch)
+	assert.Equal(t, "2026-10-17", slip.Date)
+	assert.Equal(t, "R1", slip.RegisterID)
+	assert.Equal(t, Money(9000), slip.TotalValue)
+	assert.Len(t, slip.Rolls, 1)
+
+	// nothing is left
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/deposit-slips", `{"countId":1}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), "is already deposited")
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/deposit-slips/1", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `6000`, jsonField(t, rec.Body.Bytes(), "totalValue"))
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/deposit-slips/2?format=pdf", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/pdf", rec.Header().Get("Content-Type"))
+	assert.True(t, bytes.HasPrefix(rec.Body.Bytes(), []byte("%PDF-1.4")))
+	assert.Contains(t, rec.Body.String(), "(Beleg-Nr. 000002)")
+	assert.Contains(t, rec.Body.String(), "(90,00)")
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/deposit-slips/3", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestDepositSlipErrors(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.routes()
+	rec := serveJSON(handler, http.MethodPost, "/api/v2/calculate", `{"targetValue":"100,00","counts":[
+		{"denomination":"euro20","form":"loose","quantity":5}
+	]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	// a pending count, a drop and a count that is deposited in part
+	serveJSON(handler, http.MethodPost, "/api/v2/calculate", criticalCount)
+	serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R5","expectedValue":"300,00"}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/registers/R5/drops", `{"counts":[{"denomination":"euro200","form":"loose","quantity":1}]}`)
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/deposit-slips",
+		`{"countId":1,"branch":"B","counts":[{"denomination":"euro20","form":"loose","quantity":4}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	tests := []struct {
+		name  string
+		body  string
+		field string
+	}{
+		{"missing count", `{"branch":"B"}`, "countId"},
+		{"unknown count", `{"countId":9,"branch":"B"}`, "countId"},
+		{"missing branch", `{"countId":1}`, "branch"},
+		{"more than left", `{"countId":1,"branch":"B","counts":[
+			{"denomination":"euro20","form":"loose","quantity":1},
+			{"denomination":"euro20","form":"loose","quantity":1}
+		]}`, "counts[1].quantity"},
+		{"pending count", `{"countId":2,"branch":"B"}`, "countId"},
+		{"drop", `{"countId":3,"branch":"B"}`, "countId"},
+		{"not counted", `{"countId":1,"branch":"B","counts":[{"denomination":"euro5","form":"loose","quantity":1}]}`, "counts[0].quantity"},
+		{"invalid date", `{"countId":1,"branch":"B","date":"17.10.2026"}`, "date"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveJSON(handler, http.MethodPost, "/api/v1/deposit-slips", tt.body)
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+			var payload ErrorPayload
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(&payload))
+			assert.Equal(t, tt.field, payload.Error.Field)
+		})
+	}
+}
+
+func TestSaveSlipChecksRemaining(t *testing.T) {
+	server := newTestServer(t)
+	serveJSON(server.routes(), http.MethodPost, "/api/v2/calculate", `{"targetValue":"40,00","counts":[
+		{"denomination":"euro20","form":"loose","quantity":2}
+	]}`)
+	currency, _ := server.config.Catalog.Currency("")
+	half := []Count{{Denomination: "euro20", Form: FormLoose, Quantity: 1}}
+
+	for i := 0; i < 2; i++ {
+		slip := BuildDepositSlip(currency, half)
+		slip.CountID = 1
+		assert.NoError(t, server.store.SaveSlip(&slip))
+	}
+	slip := BuildDepositSlip(currency, half)
+	slip.CountID = 1
+	var validationErr *ValidationError
+	assert.ErrorAs(t, server.store.SaveSlip(&slip), &validationErr)
+	assert.Equal(t, "counts", validationErr.Field)
+}
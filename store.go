@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned for a change the stored records do not allow right now, such as a
+// second open session on a register.
+var ErrConflict = errors.New("conflict")
+
+var (
+	countsBucket    = []byte("counts")
+	sessionsBucket  = []byte("sessions")
+	slipsBucket     = []byte("slips")
+	settingsBucket  = []byte("settings")
+	shopsBucket     = []byte("stores")
+	registersBucket = []byte("registers")
+
+	safeCountsBucket    = []byte("safeCounts")
+	safeMovementsBucket = []byte("safeMovements")
+	posTotalsBucket     = []byte("posTotals")
+	cashBookBucket      = []byte("cashBook")
+)
+
+// buckets lists every bucket OpenStore creates.
+var buckets = [][]byte{countsBucket, sessionsBucket, slipsBucket, settingsBucket, shopsBucket, registersBucket,
+	safeCountsBucket, safeMovementsBucket, posTotalsBucket, cashBookBucket}
+
+// Store keeps submitted cash counts in an embedded bbolt database file, so no database
+// server is needed. Records are stored as JSON and keyed by their sequential ID.
+type Store struct {
+	db *bolt.DB
+}
+
+// CountRecord is a submitted cash count together with the values calculated for it.
+// All amounts are in cents.
+type CountRecord struct {
+	ID              uint64          `json:"id"`
+	CreatedAt       time.Time       `json:"createdAt"`
+	RegisterID      string          `json:"registerId"`
+	StoreID         string          `json:"storeId,omitempty"`
+	Cashier         string          `json:"cashier"`
+	Kind            CountKind       `json:"kind,omitempty"`
+	SessionID       uint64          `json:"sessionId,omitempty"`
+	Attempt         int             `json:"attempt,omitempty"`
+	Currency        string          `json:"currency"`
+	Counts          []Count         `json:"counts"`
+	LooseValue      Money           `json:"looseValue"`
+	RollValue       Money           `json:"rollValue"`
+	BoxValue        Money           `json:"boxValue"`
+	TotalValue      Money           `json:"totalValue"`
+	TargetValue     Money           `json:"targetValue"`
+	DifferenceValue Money           `json:"differenceValue"`
+	Classification  *Classification `json:"classification,omitempty"`
+	Status          ApprovalStatus  `json:"status,omitempty"`
+	History         []StatusChange  `json:"history,omitempty"`
+	RecountOf       uint64          `json:"recountOf,omitempty"`
+}
+
+// CountFilter selects stored counts. Empty fields do not filter; From is inclusive, To exclusive.
+// Limit caps the number of records returned, zero means no limit.
+type CountFilter struct {
+	RegisterID string
+	StoreID    string
+	Status     ApprovalStatus
+	From       time.Time
+	To         time.Time
+	Limit      int
+}
+
+// NewCountRecord calculates the values of validated counts and returns them as a record.
+// The caller fills in who counted which register before saving it.
+func NewCountRecord(currency *Currency, counts []Count, target Money) CountRecord {
+	loose, rolls, boxes := SumCounts(currency, counts)
+	return CountRecord{
+		Currency:        currency.Code,
+		Counts:          counts,
+		LooseValue:      loose,
+		RollValue:       rolls,
+		BoxValue:        boxes,
+		TotalValue:      loose + rolls + boxes,
+		TargetValue:     target,
+		DifferenceValue: loose + rolls + boxes - target,
+	}
+}
+
+// OpenStore opens the database file at path, creating it and its buckets if needed.
+func OpenStore(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening store %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating buckets: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database file.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// SaveCount stores record under the next free ID and sets record.ID. CreatedAt is set to the
+// current time unless the record already has one. The functions in then run last in the
+// transaction; if one fails, nothing is stored.
+func (s *Store) SaveCount(record *CountRecord, then ...func(*bolt.Tx) error) error {
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now().UTC()
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := insertCount(tx, record); err != nil {
+			return err
+		}
+		return runAll(tx, then)
+	})
+}
+
+// runAll calls the functions in order within the transaction and stops at the first error.
+func runAll(tx *bolt.Tx, then []func(*bolt.Tx) error) error {
+	for _, fn := range then {
+		if err := fn(tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertCount stores record under the next free ID and sets record.ID. A recount supersedes
+// the count it replaces, and a finalised closing count is booked into the cash book.
+func insertCount(tx *bolt.Tx, record *CountRecord) error {
+	bucket := tx.Bucket(countsBucket)
+	id, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	record.ID = id
+	if record.RecountOf != 0 {
+		if err := supersedeCount(tx, record); err != nil {
+			return err
+		}
+	}
+	if err := putJSON(bucket, itob(id), record); err != nil {
+		return err
+	}
+	if record.Kind == CountClosing && record.Status.finalised() {
+		return appendCashBook(tx, countEntry(record))
+	}
+	return nil
+}
+
+// Count returns the record with the given ID or ErrNotFound.
+func (s *Store) Count(id uint64) (CountRecord, error) {
+	var record CountRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(countsBucket), id, "count", &record)
+	})
+	return record, err
+}
+
+// UpdateCount applies update to the record with the given ID and stores the result in one
+// transaction, then runs the functions in then in the same transaction. Nothing is stored if
+// update or one of them returns an error. Finalised records are not changed and ErrImmutable is
+// returned; a closing count that becomes finalised is booked into the cash book.
+func (s *Store) UpdateCount(id uint64, update func(*CountRecord) error, then ...func(*bolt.Tx) error) (CountRecord, error) {
+	var record CountRecord
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(countsBucket)
+		if err := getJSON(bucket, id, "count", &record); err != nil {
+			return err
+		}
+		status := record.Status
+		if err := update(&record); err != nil {
+			return err
+		}
+		if status.finalised() {
+			return fmt.Errorf("count %d is %s: %w", id, status, ErrImmutable)
+		}
+		if err := putJSON(bucket, itob(id), record); err != nil {
+			return err
+		}
+		if record.Kind == CountClosing && record.Status.finalised() {
+			if err := appendCashBook(tx, countEntry(&record)); err != nil {
+				return err
+			}
+		}
+		return runAll(tx, then)
+	})
+	return record, err
+}
+
+// ListCounts returns the records matching filter, newest first.
+func (s *Store) ListCounts(filter CountFilter) ([]CountRecord, error) {
+	records := []CountRecord{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(countsBucket).Cursor()
+		for key, data := cursor.Last(); key != nil; key, data = cursor.Prev() {
+			var record CountRecord
+			if err := json.Unmarshal(data, &record); err != nil {
+				return fmt.Errorf("count %d: %w", btoi(key), err)
+			}
+			if !filter.matches(record) {
+				continue
+			}
+			records = append(records, record)
+			if filter.Limit > 0 && len(records) == filter.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+// countPage is the number of records EachCount reads in one transaction.
+const countPage = 500
+
+// EachCount calls fn for every record matching filter, oldest first, and stops at the first
+// error fn returns. filter.Limit is not applied. The records are read in pages of countPage,
+// each in a transaction of its own that is closed before fn is called, so a slow fn does not
+// keep a transaction open and may write to the store. Records stored while EachCount runs are
+// passed to fn if they come after the current page.
+func (s *Store) EachCount(filter CountFilter, fn func(CountRecord) error) error {
+	var after uint64
+	for {
+		page := make([]CountRecord, 0, countPage)
+		err := s.db.View(func(tx *bolt.Tx) error {
+			cursor := tx.Bucket(countsBucket).Cursor()
+			for key, data := cursor.Seek(itob(after + 1)); key != nil && len(page) < countPage; key, data = cursor.Next() {
+				after = btoi(key)
+				var record CountRecord
+				if err := json.Unmarshal(data, &record); err != nil {
+					return fmt.Errorf("count %d: %w", after, err)
+				}
+				if filter.matches(record) {
+					page = append(page, record)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, record := range page {
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		if len(page) < countPage {
+			return nil
+		}
+	}
+}
+
+// matches reports whether record passes the filter.
+func (f CountFilter) matches(record CountRecord) bool {
+	switch {
+	case f.RegisterID != "" && record.RegisterID != f.RegisterID:
+		return false
+	case f.StoreID != "" && record.StoreID != f.StoreID:
+		return false
+	case f.Status != "" && record.Status != f.Status:
+		return false
+	case !f.From.IsZero() && record.CreatedAt.Before(f.From):
+		return false
+	case !f.To.IsZero() && !record.CreatedAt.Before(f.To):
+		return false
+	}
+	return true
+}
+
+// putJSON stores value as JSON under key.
+func putJSON(bucket *bolt.Bucket, key []byte, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, data)
+}
+
+// getJSON decodes the value stored under id into value. A missing key is reported as
+// ErrNotFound, prefixed with what and the ID.
+func getJSON(bucket *bolt.Bucket, id uint64, what string, value any) error {
+	data := bucket.Get(itob(id))
+	if data == nil {
+		return fmt.Errorf("%s %d: %w", what, id, ErrNotFound)
+	}
+	return json.Unmarshal(data, value)
+}
+
+// itob encodes id as an 8-byte big-endian key, so keys sort in ID order.
+func itob(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// btoi decodes a key written by itob.
+func btoi(key []byte) uint64 {
+	return binary.BigEndian.Uint64(key)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+// newTestStore opens a store in a temporary directory that is closed when the test ends.
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := OpenStore(filepath.Join(t.TempDir(), "register.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// newTestServer returns a server with the default configuration, an empty store and an empty journal.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	config := DefaultConfig()
+	config.Auth.Disabled = true
+	return NewServer(&config, newTestStore(t), newTestJournal(t))
+}
+
+func TestStoreSaveAndListCounts(t *testing.T) {
+	store := newTestStore(t)
+	day := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+
+	records := []CountRecord{
+		{RegisterID: "R1", CreatedAt: day.Add(8 * time.Hour), TotalValue: 100},
+		{RegisterID: "R2", CreatedAt: day.Add(9 * time.Hour), TotalValue: 200},
+		{RegisterID: "R1", CreatedAt: day.Add(30 * time.Hour), TotalValue: 300},
+	}
+	for i := range records {
+		assert.NoError(t, store.SaveCount(&records[i]))
+		assert.Equal(t, uint64(i+1), records[i].ID)
+	}
+
+	all, err := store.ListCounts(CountFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{3, 2, 1}, recordIDs(all))
+
+	r1, err := store.ListCounts(CountFilter{RegisterID: "R1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{3, 1}, recordIDs(r1))
+
+	firstDay, err := store.ListCounts(CountFilter{From: day, To: day.AddDate(0, 0, 1)})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{2, 1}, recordIDs(firstDay))
+
+	limited, err := store.ListCounts(CountFilter{Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{3}, recordIDs(limited))
+
+	record, err := store.Count(2)
+	assert.NoError(t, err)
+	assert.Equal(t, records[1], record)
+
+	_, err = store.Count(4)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestEachCountReadsPages(t *testing.T) {
+	store := newTestStore(t)
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		for i := range 2*countPage + 1 {
+			record := CountRecord{RegisterID: fmt.Sprintf("R%d", i%2+1), CreatedAt: time.Now().UTC()}
+			if err := insertCount(tx, &record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// no transaction is open while fn runs, so it may store counts; those come after the page
+	var ids []uint64
+	err = store.EachCount(CountFilter{RegisterID: "R1"}, func(record CountRecord) error {
+		ids = append(ids, record.ID)
+		if record.ID == 1 {
+			return store.SaveCount(&CountRecord{RegisterID: "R1"})
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, ids, countPage+2)
+	assert.Equal(t, uint64(2*countPage+2), ids[len(ids)-1])
+	for i := 1; i < len(ids); i++ {
+		assert.Less(t, ids[i-1], ids[i])
+	}
+}
+
+// recordIDs returns the IDs of records in order.
+func recordIDs(records []CountRecord) []uint64 {
+	var ids []uint64
+	for _, record := range records {
+		ids = append(ids, record.ID)
+	}
+	return ids
+}
+
+func TestCalculateSavesCount(t *testing.T) {
+	handler := newTestServer(t).routes()
+
+	body := `{"registerId":"R1","cashier":"anna","requestValidation":{"targetValue":"100"},"rollValues":{"euro2":[1,0]},"requestValues":{"euro20":[2,0,0,0,0]}}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var response ResponsePayload
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Equal(t, uint64(1), response.CountID)
+
+	body = `{"registerId":"R2","targetValue":"0","counts":[{"denomination":"cent1","form":"loose","quantity":3}]}`
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v2/calculate", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/counts/1", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var record CountRecord
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&record))
+	assert.Equal(t, "R1", record.RegisterID)
+	assert.Equal(t, "anna", record.Cashier)
+	assert.Equal(t, "EUR", record.Currency)
+	assert.Equal(t, Money(4000), record.LooseValue)
+	assert.Equal(t, Money(5000), record.RollValue)
+	assert.Equal(t, Money(9000), record.TotalValue)
+	assert.Equal(t, Money(-1000), record.DifferenceValue)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/counts?registerId=R2", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var records []CountRecord
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&records))
+	assert.Equal(t, []uint64{2}, recordIDs(records))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/counts/9", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/counts?from=yesterday", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
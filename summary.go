@@ -0,0 +1,155 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Summaries group the closing counts by store, by region or for the whole company.
+const (
+	GroupByStore   = "store"
+	GroupByRegion  = "region"
+	GroupByCompany = "company"
+)
+
+// SummaryGroup sums the closing counts of one store or region, or of the company, in one
+// currency. All amounts are in cents.
+type SummaryGroup struct {
+	Key             string           `json:"key"`
+	Currency        string           `json:"currency"`
+	Registers       int              `json:"registers"`
+	Closings        int              `json:"closings"`
+	TotalValue      Money            `json:"totalValue"`
+	TargetValue     Money            `json:"targetValue"`
+	DifferenceValue Money            `json:"differenceValue"`
+	Severities      map[Severity]int `json:"severities"`
+}
+
+// Summary is the aggregate report of the registers over a period.
+type Summary struct {
+	GroupBy string         `json:"groupBy"`
+	From    time.Time      `json:"from"`
+	To      time.Time      `json:"to"`
+	Groups  []SummaryGroup `json:"groups"`
+}
+
+// closingCounts returns the closing count of every register and day, given the counts in the
+// order they were saved. As in the Z report the latest closing count of a day counts; rejected
+// and superseded counts are left out.
+func closingCounts(records []CountRecord) []CountRecord {
+	type registerDay struct{ registerID, date string }
+	latest := make(map[registerDay]CountRecord)
+	var days []registerDay
+	for _, record := range records {
+		if record.Kind != CountClosing || record.Status == StatusRejected || record.Status == StatusSuperseded {
+			continue
+		}
+		day := registerDay{record.RegisterID, record.CreatedAt.In(time.Local).Format(time.DateOnly)}
+		if _, ok := latest[day]; !ok {
+			days = append(days, day)
+		}
+		latest[day] = record
+	}
+	closings := make([]CountRecord, len(days))
+	for i, day := range days {
+		closings[i] = latest[day]
+	}
+	return closings
+}
+
+// BuildSummary sums the closing counts among records, given in the order they were saved, per
+// group and currency. shops maps store IDs to the stores, for their region. Counts of
+// registers without a store are grouped under an empty key.
+func BuildSummary(groupBy string, records []CountRecord, shops map[string]Shop) ([]SummaryGroup, error) {
+	if groupBy != GroupByStore && groupBy != GroupByRegion && groupBy != GroupByCompany {
+		return nil, &ValidationError{Field: "groupBy", Reason: fmt.Sprintf("unknown grouping %q", groupBy)}
+	}
+	key := func(record CountRecord) string {
+		switch groupBy {
+		case GroupByStore:
+			return record.StoreID
+		case GroupByRegion:
+			return shops[record.StoreID].Region
+		}
+		return ""
+	}
+
+	type groupKey struct{ key, currency string }
+	groups := make(map[groupKey]*SummaryGroup)
+	registers := make(map[groupKey]map[string]bool)
+	for _, record := range closingCounts(records) {
+		k := groupKey{key(record), record.Currency}
+		group, ok := groups[k]
+		if !ok {
+			group = &SummaryGroup{Key: k.key, Currency: k.currency, Severities: make(map[Severity]int)}
+			groups[k], registers[k] = group, make(map[string]bool)
+		}
+		registers[k][record.RegisterID] = true
+		group.Registers = len(registers[k])
+		group.Closings++
+		group.TotalValue += record.TotalValue
+		group.TargetValue += record.TargetValue
+		group.DifferenceValue += record.DifferenceValue
+		if record.Classification != nil {
+			group.Severities[record.Classification.Severity]++
+		}
+	}
+
+	summary := make([]SummaryGroup, 0, len(groups))
+	for _, group := range groups {
+		summary = append(summary, *group)
+	}
+	slices.SortFunc(summary, func(a, b SummaryGroup) int {
+		return cmp.Or(cmp.Compare(a.Key, b.Key), cmp.Compare(a.Currency, b.Currency))
+	})
+	return summary, nil
+}
+
+// handleSummary returns the closing counts summed per store ("groupBy=store", the default), per
+// region ("groupBy=region") or for the company ("groupBy=company"). The query parameters from
+// and to take a date or an RFC 3339 timestamp and default to today.
+func (s *Server) handleSummary(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	report := Summary{GroupBy: cmp.Or(query.Get("groupBy"), GroupByStore)}
+
+	var err error
+	if report.From, err = parseTimeParam(query.Get("from"), "from"); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if report.To, err = parseTimeParam(query.Get("to"), "to"); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if report.From.IsZero() {
+		report.From = startOfDay(time.Now())
+	}
+	if report.To.IsZero() {
+		report.To = report.From.AddDate(0, 0, 1)
+	}
+
+	records, err := s.store.ListCounts(CountFilter{From: report.From, To: report.To})
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	reverseRecords(records)
+	shops, err := s.store.ListShops()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	byID := make(map[string]Shop, len(shops))
+	for _, shop := range shops {
+		byID[shop.ID] = shop
+	}
+
+	if report.Groups, err = BuildSummary(report.GroupBy, records, byID); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, report)
+}
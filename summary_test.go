@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildSummary(t *testing.T) {
+	day := time.Date(2026, 10, 16, 18, 0, 0, 0, time.Local)
+	closing := func(register, store string, hours int, total, target Money, severity Severity, status ApprovalStatus) CountRecord {
+		return CountRecord{
+			RegisterID: register, StoreID: store, Kind: CountClosing, Currency: "EUR", Status: status,
+			CreatedAt:  day.Add(time.Duration(hours) * time.Hour),
+			TotalValue: total, TargetValue: target, DifferenceValue: total - target,
+			Classification: &Classification{Severity: severity},
+		}
+	}
+	records := []CountRecord{
+		{RegisterID: "R1", StoreID: "berlin-1", Kind: CountInterim, Currency: "EUR", CreatedAt: day, TotalValue: 99999},
+		closing("R1", "berlin-1", 1, 10000, 10100, SeverityWarning, StatusFinal),
+		// the recount of the day replaces the first closing count
+		closing("R1", "berlin-1", 2, 10100, 10100, SeverityOK, StatusFinal),
+		closing("R2", "berlin-1", 1, 5000, 5000, SeverityOK, StatusFinal),
+		closing("R3", "munich-1", 1, 7000, 8000, SeverityCritical, StatusPending),
+		closing("R3", "munich-1", 2, 1, 8000, SeverityCritical, StatusRejected),
+		closing("R3", "munich-1", 24, 8000, 8000, SeverityOK, StatusFinal),
+	}
+	shops := map[string]Shop{"berlin-1": {ID: "berlin-1", Region: "east"}, "munich-1": {ID: "munich-1", Region: "south"}}
+
+	groups, err := BuildSummary(GroupByStore, records, shops)
+	assert.NoError(t, err)
+	assert.Equal(t, []SummaryGroup{
+		{Key: "berlin-1", Currency: "EUR", Registers: 2, Closings: 2, TotalValue: 15100, TargetValue: 15100,
+			Severities: map[Severity]int{SeverityOK: 2}},
+		{Key: "munich-1", Currency: "EUR", Registers: 1, Closings: 2, TotalValue: 15000, TargetValue: 16000, DifferenceValue: -1000,
+			Severities: map[Severity]int{SeverityOK: 1, SeverityCritical: 1}},
+	}, groups)
+
+	groups, err = BuildSummary(GroupByRegion, records, shops)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"east", "south"}, []string{groups[0].Key, groups[1].Key})
+
+	groups, err = BuildSummary(GroupByCompany, records, shops)
+	assert.NoError(t, err)
+	assert.Len(t, groups, 1)
+	assert.Equal(t, 3, groups[0].Registers)
+	assert.Equal(t, Money(-1000), groups[0].DifferenceValue)
+
+	_, err = BuildSummary("country", records, shops)
+	assert.Error(t, err)
+}
+
+func TestSummaryEndpoint(t *testing.T) {
+	handler := newTestServer(t).routes()
+	serveJSON(handler, http.MethodPost, "/api/v1/stores", `{"id":"berlin-1","name":"Berlin","region":"east"}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/registers", `{"id":"R1","storeId":"berlin-1"}`)
+	serveJSON(handler, http.MethodPost, "/api/v2/calculate",
+		`{"registerId":"R1","countKind":"closing","targetValue":"100,00","counts":[{"denomination":"euro50","form":"loose","quantity":2}]}`)
+
+	rec := serveJSON(handler, http.MethodGet, "/api/v1/reports/summary?groupBy=region", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var summary Summary
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&summary))
+	assert.Len(t, summary.Groups, 1)
+	assert.Equal(t, "east", summary.Groups[0].Key)
+	assert.Equal(t, Money(10000), summary.Groups[0].TotalValue)
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/reports/summary?from=2020-01-01&to=2020-01-02", "")
+	assert.JSONEq(t, `[]`, jsonField(t, rec.Body.Bytes(), "groups"))
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/reports/summary?groupBy=country", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
+
+func TestSummaryUsesLocalBusinessDay(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("UTC+14", 14*60*60)
+	t.Cleanup(func() { time.Local = local })
+	server := newTestServer(t)
+	handler := server.routes()
+
+	// shortly after local midnight it is still the day before in UTC
+	day := startOfDay(time.Now())
+	record := CountRecord{RegisterID: "R1", Kind: CountClosing, Currency: "EUR", CreatedAt: day.Add(time.Minute), TotalValue: 100}
+	assert.NoError(t, server.store.SaveCount(&record))
+
+	for _, query := range []string{"", "?from=" + day.Format(time.DateOnly)} {
+		rec := serveJSON(handler, http.MethodGet, "/api/v1/reports/summary"+query, "")
+		assert.Equal(t, http.StatusOK, rec.Code, query)
+		var summary Summary
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&summary))
+		assert.True(t, summary.From.Equal(day), query)
+		if assert.Len(t, summary.Groups, 1, query) {
+			assert.Equal(t, 1, summary.Groups[0].Closings, query)
+		}
+	}
+}
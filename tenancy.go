@@ -0,0 +1,445 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Shop is a store of the company. It is called Shop in the code, since Store is the database;
+// the API calls it a store. Region groups stores in the aggregate reports.
+type Shop struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Region    string    `json:"region,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Register is a cash register of a store. Once tenancy is enabled, every count and session has
+// to name a known register.
+type Register struct {
+	ID        string    `json:"id"`
+	StoreID   string    `json:"storeId"`
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// TenancyConfig enables tenancy: every count and session has to name a known register, and
+// nothing can be counted before the registers are set up. Without Enabled any register is
+// accepted.
+type TenancyConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// maxResourceID is the maximum length of the ID of a store or register.
+const maxResourceID = 64
+
+// validateResourceID checks that id can be used in a path: letters, digits, '.', '_' and '-'.
+func validateResourceID(field, id string) error {
+	if id == "" {
+		return &ValidationError{Field: field, Reason: "value is empty"}
+	}
+	if len(id) > maxResourceID {
+		return &ValidationError{Field: field, Reason: fmt.Sprintf("must be at most %d characters", maxResourceID)}
+	}
+	valid := func(r rune) bool {
+		return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("._-", r)
+	}
+	if strings.IndexFunc(id, func(r rune) bool { return !valid(r) }) >= 0 {
+		return &ValidationError{Field: field, Reason: "may only contain letters, digits, '.', '_' and '-'"}
+	}
+	return nil
+}
+
+// Validate checks the ID and name of the store.
+func (shop Shop) Validate() error {
+	if err := validateResourceID("id", shop.ID); err != nil {
+		return err
+	}
+	if shop.Name == "" {
+		return &ValidationError{Field: "name", Reason: "value is empty"}
+	}
+	return nil
+}
+
+// Validate checks the IDs of the register and its store.
+func (register Register) Validate() error {
+	if err := validateResourceID("id", register.ID); err != nil {
+		return err
+	}
+	return validateResourceID("storeId", register.StoreID)
+}
+
+// getNamed decodes the value stored under the string id into value. A missing key is reported
+// as ErrNotFound, prefixed with what and the ID.
+func getNamed(bucket *bolt.Bucket, id, what string, value any) error {
+	data := bucket.Get([]byte(id))
+	if data == nil {
+		return fmt.Errorf("%s %s: %w", what, id, ErrNotFound)
+	}
+	return json.Unmarshal(data, value)
+}
+
+// listNamed decodes every value of bucket in key order and returns those keep accepts.
+func listNamed[T any](bucket *bolt.Bucket, what string, keep func(T) bool) ([]T, error) {
+	values := []T{}
+	err := bucket.ForEach(func(key, data []byte) error {
+		var value T
+		if err := json.Unmarshal(data, &value); err != nil {
+			return fmt.Errorf("%s %s: %w", what, key, err)
+		}
+		if keep(value) {
+			values = append(values, value)
+		}
+		return nil
+	})
+	return values, err
+}
+
+// CreateShop stores a new store. CreatedAt is set to the current time.
+func (s *Store) CreateShop(shop *Shop) error {
+	shop.CreatedAt = time.Now().UTC()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(shopsBucket)
+		if bucket.Get([]byte(shop.ID)) != nil {
+			return &ValidationError{Field: "id", Reason: fmt.Sprintf("store %s already exists", shop.ID)}
+		}
+		return putJSON(bucket, []byte(shop.ID), shop)
+	})
+}
+
+// UpdateShop replaces the name and region of an existing store.
+func (s *Store) UpdateShop(shop *Shop) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(shopsBucket)
+		var stored Shop
+		if err := getNamed(bucket, shop.ID, "store", &stored); err != nil {
+			return err
+		}
+		shop.CreatedAt = stored.CreatedAt
+		return putJSON(bucket, []byte(shop.ID), shop)
+	})
+}
+
+// Shop returns the store with the given ID or ErrNotFound.
+func (s *Store) Shop(id string) (Shop, error) {
+	var shop Shop
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getNamed(tx.Bucket(shopsBucket), id, "store", &shop)
+	})
+	return shop, err
+}
+
+// ListShops returns all stores ordered by ID.
+func (s *Store) ListShops() ([]Shop, error) {
+	var shops []Shop
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		shops, err = listNamed(tx.Bucket(shopsBucket), "store", func(Shop) bool { return true })
+		return err
+	})
+	return shops, err
+}
+
+// DeleteShop removes a store that has no registers left.
+func (s *Store) DeleteShop(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(shopsBucket)
+		var shop Shop
+		if err := getNamed(bucket, id, "store", &shop); err != nil {
+			return err
+		}
+		registers, err := listNamed(tx.Bucket(registersBucket), "register", func(r Register) bool { return r.StoreID == id })
+		if err != nil {
+			return err
+		}
+		if len(registers) > 0 {
+			return &ValidationError{Field: "storeId", Reason: fmt.Sprintf("store %s still has %d registers", id, len(registers))}
+		}
+		return bucket.Delete([]byte(id))
+	})
+}
+
+// checkShop returns a ValidationError if the store of register does not exist.
+func checkShop(tx *bolt.Tx, register *Register) error {
+	if tx.Bucket(shopsBucket).Get([]byte(register.StoreID)) == nil {
+		return &ValidationError{Field: "storeId", Reason: fmt.Sprintf("unknown store %s", register.StoreID)}
+	}
+	return nil
+}
+
+// CreateRegister stores a new register of an existing store. CreatedAt is set to the current time.
+func (s *Store) CreateRegister(register *Register) error {
+	register.CreatedAt = time.Now().UTC()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(registersBucket)
+		if bucket.Get([]byte(register.ID)) != nil {
+			return &ValidationError{Field: "id", Reason: fmt.Sprintf("register %s already exists", register.ID)}
+		}
+		if err := checkShop(tx, register); err != nil {
+			return err
+		}
+		return putJSON(bucket, []byte(register.ID), register)
+	})
+}
+
+// UpdateRegister replaces the name and store of an existing register. Counts saved before keep
+// the store they were saved for.
+func (s *Store) UpdateRegister(register *Register) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(registersBucket)
+		var stored Register
+		if err := getNamed(bucket, register.ID, "register", &stored); err != nil {
+			return err
+		}
+		if err := checkShop(tx, register); err != nil {
+			return err
+		}
+		register.CreatedAt = stored.CreatedAt
+		return putJSON(bucket, []byte(register.ID), register)
+	})
+}
+
+// Register returns the register with the given ID or ErrNotFound.
+func (s *Store) Register(id string) (Register, error) {
+	var register Register
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getNamed(tx.Bucket(registersBucket), id, "register", &register)
+	})
+	return register, err
+}
+
+// ListRegisters returns the registers of the store, or all registers if storeID is empty,
+// ordered by ID.
+func (s *Store) ListRegisters(storeID string) ([]Register, error) {
+	var registers []Register
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		registers, err = listNamed(tx.Bucket(registersBucket), "register", func(r Register) bool {
+			return storeID == "" || r.StoreID == storeID
+		})
+		return err
+	})
+	return registers, err
+}
+
+// registerRecords lists the buckets whose records name a register in their "registerId" field,
+// with what they hold.
+var registerRecords = []struct {
+	bucket []byte
+	what   string
+}{
+	{countsBucket, "counts"},
+	{sessionsBucket, "sessions"},
+	{posTotalsBucket, "pos imports"},
+	{cashBookBucket, "cash book entries"},
+}
+
+// DeleteRegister removes a register nothing has been counted on yet. Registers with counts,
+// sessions, POS imports or cash book entries stay, so these keep pointing to a known register.
+func (s *Store) DeleteRegister(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(registersBucket)
+		var register Register
+		if err := getNamed(bucket, id, "register", &register); err != nil {
+			return err
+		}
+		for _, records := range registerRecords {
+			used := false
+			err := tx.Bucket(records.bucket).ForEach(func(key, data []byte) error {
+				var record struct {
+					RegisterID string `json:"registerId"`
+				}
+				if err := json.Unmarshal(data, &record); err != nil {
+					return fmt.Errorf("%s %d: %w", records.what, btoi(key), err)
+				}
+				used = used || record.RegisterID == id
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if used {
+				return &ValidationError{Field: "registerId", Reason: fmt.Sprintf("register %s has %s and cannot be deleted", id, records.what)}
+			}
+		}
+		return bucket.Delete([]byte(id))
+	})
+}
+
+// RegisterStore returns the store of a register. With tenancy enabled the register has to be
+// known; without it any register ID is accepted, and unknown registers belong to no store.
+func (s *Store) RegisterStore(registerID string, tenancy TenancyConfig) (string, error) {
+	var storeID string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var register Register
+		err := getNamed(tx.Bucket(registersBucket), registerID, "register", &register)
+		switch {
+		case err == nil:
+			storeID = register.StoreID
+		case errors.Is(err, ErrNotFound) && !tenancy.Enabled:
+		case registerID == "":
+			return &ValidationError{Field: "registerId", Reason: "value is empty"}
+		case errors.Is(err, ErrNotFound):
+			return &ValidationError{Field: "registerId", Reason: fmt.Sprintf("unknown register %s", registerID)}
+		default:
+			return err
+		}
+		return nil
+	})
+	return storeID, err
+}
+
+// handleCreateShop sets up a new store.
+func (s *Server) handleCreateShop(w http.ResponseWriter, r *http.Request) {
+	var shop Shop
+	if err := decodeStrict(r, &shop); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if err := shop.Validate(); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := s.store.CreateShop(&shop); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, shop)
+}
+
+// handleListShops returns all stores.
+func (s *Server) handleListShops(w http.ResponseWriter, r *http.Request) {
+	shops, err := s.store.ListShops()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, shops)
+}
+
+// handleGetShop returns the store in the path.
+func (s *Server) handleGetShop(w http.ResponseWriter, r *http.Request) {
+	shop, err := s.store.Shop(r.PathValue("storeId"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, shop)
+}
+
+// handleUpdateShop changes the name and region of the store in the path. The body may leave out
+// the ID, but not name another store.
+func (s *Server) handleUpdateShop(w http.ResponseWriter, r *http.Request) {
+	var shop Shop
+	if err := decodeStrict(r, &shop); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if err := pathID(r, "storeId", &shop.ID); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := shop.Validate(); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := s.store.UpdateShop(&shop); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, shop)
+}
+
+// handleDeleteShop removes the store in the path once its registers are gone.
+func (s *Server) handleDeleteShop(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.DeleteShop(r.PathValue("storeId")); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCreateRegister sets up a new register of an existing store.
+func (s *Server) handleCreateRegister(w http.ResponseWriter, r *http.Request) {
+	var register Register
+	if err := decodeStrict(r, &register); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if err := register.Validate(); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := s.store.CreateRegister(&register); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, register)
+}
+
+// handleListRegisters returns the registers, narrowed to one store by the storeId query parameter.
+func (s *Server) handleListRegisters(w http.ResponseWriter, r *http.Request) {
+	registers, err := s.store.ListRegisters(r.URL.Query().Get("storeId"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, registers)
+}
+
+// handleGetRegister returns the register in the path.
+func (s *Server) handleGetRegister(w http.ResponseWriter, r *http.Request) {
+	register, err := s.store.Register(r.PathValue("registerId"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, register)
+}
+
+// handleUpdateRegister changes the name or moves the register in the path to another store.
+func (s *Server) handleUpdateRegister(w http.ResponseWriter, r *http.Request) {
+	var register Register
+	if err := decodeStrict(r, &register); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if err := pathID(r, "registerId", &register.ID); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := register.Validate(); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := s.store.UpdateRegister(&register); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, register)
+}
+
+// handleDeleteRegister removes the register in the path if it has no counts.
+func (s *Server) handleDeleteRegister(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.DeleteRegister(r.PathValue("registerId")); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pathID sets id to the path parameter name of r. An ID in the body has to match the path.
+func pathID(r *http.Request, name string, id *string) error {
+	value := r.PathValue(name)
+	if *id != "" && *id != value {
+		return &ValidationError{Field: "id", Reason: fmt.Sprintf("does not match %s %s in the path", name, value)}
+	}
+	*id = value
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShopsAndRegistersCRUD(t *testing.T) {
+	handler := newTestServer(t).routes()
+
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/stores", `{"id":"berlin-1","name":"Berlin Mitte","region":"east"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/stores", `{"id":"berlin-1","name":"again"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/stores", `{"id":"a/b","name":"slash"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/registers", `{"id":"R1","storeId":"hamburg-1"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"field":"storeId"`)
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/registers", `{"id":"R1","storeId":"berlin-1","name":"Kasse 1"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serveJSON(handler, http.MethodPut, "/api/v1/stores/berlin-1", `{"name":"Berlin Alexanderplatz","region":"east"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serveJSON(handler, http.MethodPut, "/api/v1/stores/berlin-1", `{"id":"other","name":"x"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec = serveJSON(handler, http.MethodPut, "/api/v1/stores/hamburg-1", `{"name":"x"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/stores/berlin-1", "")
+	assert.JSONEq(t, `"Berlin Alexanderplatz"`, jsonField(t, rec.Body.Bytes(), "name"))
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/registers?storeId=berlin-1", "")
+	var registers []Register
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&registers))
+	assert.Len(t, registers, 1)
+	assert.Equal(t, "Kasse 1", registers[0].Name)
+
+	// a store with registers cannot be deleted
+	rec = serveJSON(handler, http.MethodDelete, "/api/v1/stores/berlin-1", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec = serveJSON(handler, http.MethodDelete, "/api/v1/registers/R1", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = serveJSON(handler, http.MethodDelete, "/api/v1/stores/berlin-1", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/stores", "")
+	assert.JSONEq(t, `[]`, rec.Body.String())
+}
+
+func TestCountsAreScopedToRegisters(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.routes()
+
+	// without tenancy any register ID is accepted
+	rec := serveJSON(handler, http.MethodPost, "/api/v2/calculate", `{"targetValue":"0","counts":[]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	serveJSON(handler, http.MethodPost, "/api/v1/stores", `{"id":"berlin-1","name":"Berlin"}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/registers", `{"id":"R1","storeId":"berlin-1"}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/registers", `{"id":"R2","storeId":"berlin-1"}`)
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v2/calculate", `{"registerId":"R9","targetValue":"0","counts":[]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	record, err := server.store.Count(2)
+	assert.NoError(t, err)
+	assert.Empty(t, record.StoreID)
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v2/calculate", `{"registerId":"R1","targetValue":"0","counts":[]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	record, err = server.store.Count(3)
+	assert.NoError(t, err)
+	assert.Equal(t, "berlin-1", record.StoreID)
+
+	rec = serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R2","expectedValue":"0"}`)
+	assert.JSONEq(t, `"berlin-1"`, jsonField(t, rec.Body.Bytes(), "storeId"))
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/sessions?registerId=R2", "")
+	var sessions []SessionPayload
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&sessions))
+	assert.Len(t, sessions, 1)
+
+	rec = serveJSON(handler, http.MethodGet, "/api/v1/counts?storeId=berlin-1", "")
+	var records []CountRecord
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&records))
+	assert.Equal(t, []uint64{3}, recordIDs(records))
+
+	// registers with counts or sessions stay
+	rec = serveJSON(handler, http.MethodDelete, "/api/v1/registers/R1", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), "has counts")
+	rec = serveJSON(handler, http.MethodDelete, "/api/v1/registers/R2", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), "has sessions")
+}
+
+func TestTenancyEnabledRequiresRegisters(t *testing.T) {
+	server := newTestServer(t)
+	server.config.Tenancy.Enabled = true
+	handler := server.routes()
+
+	for _, body := range []string{
+		`{"targetValue":"0","counts":[]}`,
+		`{"registerId":"R1","targetValue":"0","counts":[]}`,
+	} {
+		rec := serveJSON(handler, http.MethodPost, "/api/v2/calculate", body)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, body)
+		assert.Contains(t, rec.Body.String(), `"field":"registerId"`)
+	}
+	rec := serveJSON(handler, http.MethodPost, "/api/v1/sessions", `{"registerId":"R1","expectedValue":"0"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	serveJSON(handler, http.MethodPost, "/api/v1/stores", `{"id":"berlin-1","name":"Berlin"}`)
+	serveJSON(handler, http.MethodPost, "/api/v1/registers", `{"id":"R1","storeId":"berlin-1"}`)
+	rec = serveJSON(handler, http.MethodPost, "/api/v2/calculate", `{"registerId":"R1","targetValue":"0","counts":[]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
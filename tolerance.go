@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Severity classifies the difference of a count.
+type Severity string
+
+const (
+	SeverityOK       Severity = "ok"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+// severityRanks orders the severities from harmless to critical.
+var severityRanks = map[Severity]int{SeverityOK: 1, SeverityWarning: 2, SeverityCritical: 3}
+
+// Validate returns an error if s is not one of the known severities.
+func (s Severity) Validate() error {
+	if _, ok := severityRanks[s]; !ok {
+		return fmt.Errorf("unknown severity %q", s)
+	}
+	return nil
+}
+
+// AtLeast reports whether s is as severe as other or more.
+func (s Severity) AtLeast(other Severity) bool {
+	return severityRanks[s] >= severityRanks[other]
+}
+
+// ToleranceRule gives a difference its severity. A rule applies to the registers it lists, or
+// to all registers if it lists none, and matches a difference whose absolute value is at most
+// MaxAbsolute cents and at most MaxPercent percent of the target. A limit that is not set does
+// not restrict the rule, so a rule without limits matches every difference.
+type ToleranceRule struct {
+	Name        string   `json:"name"`
+	Severity    Severity `json:"severity"`
+	MaxAbsolute *Money   `json:"maxAbsolute,omitempty"`
+	MaxPercent  *float64 `json:"maxPercent,omitempty"`
+	Registers   []string `json:"registers,omitempty"`
+}
+
+// ToleranceRules are checked in order; the first matching rule classifies the difference.
+type ToleranceRules []ToleranceRule
+
+// Classification is the severity of a difference and the name of the rule that matched.
+type Classification struct {
+	Severity Severity `json:"severity"`
+	Rule     string   `json:"rule"`
+}
+
+// DefaultToleranceRules accepts differences up to 0,50, warns up to 5,00 and treats anything
+// above as critical.
+func DefaultToleranceRules() ToleranceRules {
+	ok, warning := Money(50), Money(500)
+	return ToleranceRules{
+		{Name: "ok", Severity: SeverityOK, MaxAbsolute: &ok},
+		{Name: "warning", Severity: SeverityWarning, MaxAbsolute: &warning},
+		{Name: "critical", Severity: SeverityCritical},
+	}
+}
+
+// matches reports whether the rule applies to the register and difference.
+func (rule ToleranceRule) matches(registerID string, difference, target Money) bool {
+	if len(rule.Registers) > 0 && !slices.Contains(rule.Registers, registerID) {
+		return false
+	}
+	difference, target = max(difference, -difference), max(target, -target)
+	if rule.MaxAbsolute != nil && difference > *rule.MaxAbsolute {
+		return false
+	}
+	if rule.MaxPercent != nil && float64(difference)*100 > *rule.MaxPercent*float64(target) {
+		return false
+	}
+	return true
+}
+
+// Classify returns the classification of the first rule matching the difference of a count of
+// the register. A difference no rule matches is critical.
+func (rules ToleranceRules) Classify(registerID string, difference, target Money) Classification {
+	for _, rule := range rules {
+		if rule.matches(registerID, difference, target) {
+			return Classification{Severity: rule.Severity, Rule: rule.Name}
+		}
+	}
+	return Classification{Severity: SeverityCritical}
+}
+
+// Validate checks that every rule has a unique name, a known severity and no negative limits.
+func (rules ToleranceRules) Validate() error {
+	names := make(map[string]bool)
+	for i, rule := range rules {
+		switch {
+		case rule.Name == "":
+			return fmt.Errorf("rule %d has no name", i)
+		case names[rule.Name]:
+			return fmt.Errorf("rule %q is defined twice", rule.Name)
+		case rule.MaxAbsolute != nil && *rule.MaxAbsolute < 0:
+			return fmt.Errorf("rule %q: maxAbsolute must not be negative", rule.Name)
+		case rule.MaxPercent != nil && *rule.MaxPercent < 0:
+			return fmt.Errorf("rule %q: maxPercent must not be negative", rule.Name)
+		}
+		if err := rule.Severity.Validate(); err != nil {
+			return fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+		names[rule.Name] = true
+	}
+	return nil
+}
+
+// toleranceKey is the key of the tolerance rules in the settings bucket.
+var toleranceKey = []byte("tolerance")
+
+// ToleranceEvent is the journal entry of a change to the tolerance rules.
+type ToleranceEvent struct {
+	ToleranceRules ToleranceRules `json:"toleranceRules"`
+	By             string         `json:"by"`
+	At             time.Time      `json:"at"`
+}
+
+// ToleranceRules returns the tolerance rules last changed through the API. It reports false if
+// they were never changed.
+func (s *Store) ToleranceRules() (ToleranceRules, bool, error) {
+	var rules ToleranceRules
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(settingsBucket).Get(toleranceKey)
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &rules)
+	})
+	return rules, found, err
+}
+
+// SaveToleranceRules stores rules, so they outlast a restart, and runs the functions in then in
+// the same transaction.
+func (s *Store) SaveToleranceRules(rules ToleranceRules, then ...func(*bolt.Tx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := putJSON(tx.Bucket(settingsBucket), toleranceKey, rules); err != nil {
+			return err
+		}
+		return runAll(tx, then)
+	})
+}
+
+// toleranceRules returns the tolerance rules in effect.
+func (s *Server) toleranceRules() ToleranceRules {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config.Tolerance
+}
+
+// handleGetToleranceRules returns the tolerance rules in effect.
+func (s *Server) handleGetToleranceRules(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, s.toleranceRules())
+}
+
+// handlePutToleranceRules replaces the tolerance rules. The new rules apply to counts saved from
+// now on, are kept across restarts and are written to the audit journal in the transaction that
+// stores them.
+func (s *Server) handlePutToleranceRules(w http.ResponseWriter, r *http.Request) {
+	var rules ToleranceRules
+	if err := decodeStrict(r, &rules); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if rules == nil {
+		rules = ToleranceRules{}
+	}
+	if err := rules.Validate(); err != nil {
+		respondWithError(w, &ValidationError{Field: "toleranceRules", Reason: err.Error()})
+		return
+	}
+
+	event := ToleranceEvent{ToleranceRules: rules, At: time.Now().UTC()}
+	if identity, ok := identityFrom(r.Context()); ok {
+		event.By = identity.User()
+	}
+	journal := func(*bolt.Tx) error {
+		_, err := s.journal.Append(event)
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.store.SaveToleranceRules(rules, journal); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	s.config.Tolerance = rules
+	respondWithJSON(w, rules)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyDefaultRules(t *testing.T) {
+	rules := DefaultToleranceRules()
+
+	tests := []struct {
+		difference Money
+		want       Classification
+	}{
+		{0, Classification{SeverityOK, "ok"}},
+		{-50, Classification{SeverityOK, "ok"}},
+		{51, Classification{SeverityWarning, "warning"}},
+		{-500, Classification{SeverityWarning, "warning"}},
+		{501, Classification{SeverityCritical, "critical"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, rules.Classify("R1", tt.difference, 100000), "difference %d", tt.difference)
+	}
+}
+
+func TestClassifyPercentAndRegisterRules(t *testing.T) {
+	strict, onePercent := Money(10), 1.0
+	rules := ToleranceRules{
+		{Name: "safe", Severity: SeverityOK, MaxAbsolute: &strict, Registers: []string{"SAFE"}},
+		{Name: "one-percent", Severity: SeverityWarning, MaxPercent: &onePercent},
+	}
+
+	assert.Equal(t, Classification{SeverityOK, "safe"}, rules.Classify("SAFE", 10, 1000))
+	assert.Equal(t, Classification{SeverityWarning, "one-percent"}, rules.Classify("R1", 10, 1000))
+	assert.Equal(t, Classification{SeverityWarning, "one-percent"}, rules.Classify("SAFE", -100, 10000))
+	// no rule matches
+	assert.Equal(t, Classification{SeverityCritical, ""}, rules.Classify("R1", 101, 10000))
+	assert.Equal(t, Classification{SeverityCritical, ""}, rules.Classify("R1", 1, 0))
+}
+
+func TestSeverityAtLeast(t *testing.T) {
+	assert.True(t, SeverityCritical.AtLeast(SeverityWarning))
+	assert.True(t, SeverityWarning.AtLeast(SeverityWarning))
+	assert.False(t, SeverityOK.AtLeast(SeverityWarning))
+}
+
+func TestLoadConfigTolerance(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, `{"tolerance": [
+		{"name": "exact", "severity": "ok", "maxAbsolute": 0},
+		{"name": "rest", "severity": "critical"}
+	]}`))
+	assert.NoError(t, err)
+	assert.Len(t, config.Tolerance, 2)
+	assert.Equal(t, Classification{SeverityCritical, "rest"}, config.Tolerance.Classify("R1", 1, 100))
+
+	for _, content := range []string{
+		`{"tolerance": [{"severity": "ok"}]}`,
+		`{"tolerance": [{"name": "a", "severity": "fine"}]}`,
+		`{"tolerance": [{"name": "a", "severity": "ok"}, {"name": "a", "severity": "warning"}]}`,
+		`{"tolerance": [{"name": "a", "severity": "ok", "maxAbsolute": -1}]}`,
+		`{"tolerance": [{"name": "a", "severity": "ok", "maxPercent": -1}]}`,
+	} {
+		_, err := LoadConfig(writeConfig(t, content))
+		assert.Error(t, err, content)
+	}
+}
+
+func TestCalculateClassifiesDifference(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.routes()
+
+	rec := serveJSON(handler, http.MethodPost, "/api/v2/calculate",
+		`{"registerId":"R1","targetValue":"52,00","counts":[{"denomination":"euro50","form":"loose","quantity":1}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"severity":"warning","rule":"warning"}`, jsonField(t, rec.Body.Bytes(), "classification"))
+
+	record, err := server.store.Count(1)
+	assert.NoError(t, err)
+	assert.Equal(t, &Classification{SeverityWarning, "warning"}, record.Classification)
+}
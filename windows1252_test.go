@@ -0,0 +1,11 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeWindows1252(t *testing.T) {
+	assert.Equal(t, []byte{'A', 0xc4, 0x80, 0x84, 0x93, 0xdf, '?', '?'}, encodeWindows1252("AÄ€„“ß\u0081😀"))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// xlsxParts are the parts of a workbook with a single worksheet, except the worksheet itself.
+// The styles give numbers the format of cell style 1, filled in by newXLSX.
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`},
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border/></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="%d" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/></cellXfs>
+</styleSheet>`},
+}
+
+// Built-in number formats of SpreadsheetML. The spreadsheet application shows them with the
+// separators of its locale.
+const (
+	xlsxDecimal        = 2 // 0.00
+	xlsxGroupedDecimal = 4 // #,##0.00
+)
+
+// xlsxWriter writes a workbook with a single worksheet row by row, so the rows never have to be
+// held in memory. Cells are strings or Money; amounts are written as numbers in units with
+// the number format given to newXLSX.
+type xlsxWriter struct {
+	archive *zip.Writer
+	sheet   *bufio.Writer
+	row     int
+}
+
+// newXLSX writes the parts of the workbook up to the first row of the worksheet named sheet.
+func newXLSX(w io.Writer, sheet string, numberFormat int) (*xlsxWriter, error) {
+	archive := zip.NewWriter(w)
+	var name bytes.Buffer
+	if err := xml.EscapeText(&name, []byte(sheet)); err != nil {
+		return nil, err
+	}
+	for _, part := range xlsxParts {
+		content := part.content
+		switch part.name {
+		case "xl/workbook.xml":
+			content = fmt.Sprintf(content, name.String())
+		case "xl/styles.xml":
+			content = fmt.Sprintf(content, numberFormat)
+		}
+		file, err := archive.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(file, content); err != nil {
+			return nil, err
+		}
+	}
+	file, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	sheetWriter := bufio.NewWriter(file)
+	_, err = sheetWriter.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	return &xlsxWriter{archive: archive, sheet: sheetWriter}, err
+}
+
+// WriteRow appends a row to the worksheet. Nothing is written if a cell is neither a string nor
+// Money.
+func (x *xlsxWriter) WriteRow(cells []any) error {
+	var row bytes.Buffer
+	fmt.Fprintf(&row, `<row r="%d">`, x.row+1)
+	for i, cell := range cells {
+		ref := xlsxColumn(i) + strconv.Itoa(x.row+1)
+		switch value := cell.(type) {
+		case Money:
+			fmt.Fprintf(&row, `<c r="%s" s="1"><v>%s</v></c>`, ref, value.String())
+		case string:
+			if value == "" {
+				continue
+			}
+			fmt.Fprintf(&row, `<c r="%s" t="inlineStr"><is><t>`, ref)
+			xml.EscapeText(&row, []byte(value))
+			row.WriteString(`</t></is></c>`)
+		default:
+			return fmt.Errorf("cannot write %T to a worksheet", cell)
+		}
+	}
+	row.WriteString(`</row>`)
+	x.row++
+	_, err := row.WriteTo(x.sheet)
+	return err
+}
+
+// Close finishes the worksheet and the archive.
+func (x *xlsxWriter) Close() error {
+	if _, err := x.sheet.WriteString(`</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.archive.Close()
+}
+
+// xlsxColumn returns the letters of the zero-based column i: A to Z, then AA, AB and so on.
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readXLSX unpacks a workbook, checks that every part is well-formed XML and returns the parts
+// by name.
+func readXLSX(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	parts := make(map[string]string)
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		assert.NoError(t, err)
+		content, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		reader.Close()
+
+		decoder := xml.NewDecoder(bytes.NewReader(content))
+		for {
+			if _, err := decoder.Token(); err != nil {
+				assert.True(t, errors.Is(err, io.EOF), "%s: %v", file.Name, err)
+				break
+			}
+		}
+		parts[file.Name] = string(content)
+	}
+	return parts
+}
+
+func TestXLSXWriter(t *testing.T) {
+	var buffer bytes.Buffer
+	writer, err := newXLSX(&buffer, "Q&A", xlsxDecimal)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.WriteRow([]any{"name", "<amount>"}))
+	assert.NoError(t, writer.WriteRow([]any{"", Money(-123456)}))
+	assert.Error(t, writer.WriteRow([]any{42}))
+	assert.NoError(t, writer.Close())
+
+	parts := readXLSX(t, buffer.Bytes())
+	assert.ElementsMatch(t, []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml",
+		"xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet1.xml"}, partNames(parts))
+	assert.Contains(t, parts["xl/workbook.xml"], `name="Q&amp;A"`)
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	assert.Contains(t, sheet, `<t>&lt;amount&gt;</t>`)
+	assert.Contains(t, sheet, `<row r="2"><c r="B2" s="1"><v>-1234.56</v></c></row>`)
+}
+
+func TestXLSXColumn(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		assert.Equal(t, want, xlsxColumn(i), i)
+	}
+}
+
+// partNames returns the names of the parts of a workbook.
+func partNames(parts map[string]string) []string {
+	var names []string
+	for name := range parts {
+		names = append(names, name)
+	}
+	return names
+}